@@ -0,0 +1,272 @@
+package testfixtures
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// Backup holds the rows the fixture tables had before fixtures were loaded,
+// see Loader.LoadWithBackup.
+type Backup struct {
+	// Result describes what was done when loading the fixtures.
+	Result *LoadResult
+
+	loader   *Loader
+	tables   []tableBackup
+	restored bool
+}
+
+type tableBackup struct {
+	name    string
+	columns []string
+	rows    [][]any
+}
+
+// LoadWithBackup is like LoadWithResult, but first saves the rows of the
+// fixture tables, so they can be put back with Backup.Restore. Useful on
+// shared development databases, where loading fixtures would otherwise lose
+// their data:
+//
+//	backup, err := fixtures.LoadWithBackup()
+//	if err != nil {
+//	        ...
+//	}
+//	defer backup.Restore()
+//
+// Rows are kept in memory, so this is meant for small tables. Rows of other
+// tables deleted in cascade are not saved.
+//
+// With UseDatabaseLock, the lock is held from the backup to the end of the
+// load, and while restoring the tables.
+//
+// The backup is also returned when loading failed, so the tables can still
+// be restored.
+func (l *Loader) LoadWithBackup() (*Backup, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	backup := &Backup{loader: l}
+
+	// The lock is held from the backup to the end of the load, so other
+	// processes can't write to the tables in between.
+	var backedUp bool
+	err := l.withDatabaseLock(context.Background(), func() error {
+		var elapsed time.Duration
+		err := l.tracer.runStep(StepBackup, &elapsed, func() error {
+			seen := make(map[string]bool, len(l.fixturesFiles))
+			for _, file := range l.fixturesFiles {
+				tableName := file.tableName()
+				if seen[tableName] {
+					continue
+				}
+				seen[tableName] = true
+
+				table, err := l.backupTable(tableName)
+				if err != nil {
+					return err
+				}
+				backup.tables = append(backup.tables, table)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		backedUp = true
+
+		backup.Result, err = l.loadLocked(context.Background(), l.fixturesFiles)
+		return err
+	})
+	if !backedUp {
+		return nil, err
+	}
+	return backup, err
+}
+
+func (l *Loader) backupTable(tableName string) (tableBackup, error) {
+	table := tableBackup{name: tableName}
+
+	rows, err := l.conn.Query(fmt.Sprintf("SELECT * FROM %s", l.helper.quoteKeyword(tableName)))
+	if err != nil {
+		return table, fmt.Errorf(`testfixtures: could not back up table "%s": %w`, tableName, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	table.columns, err = rows.Columns()
+	if err != nil {
+		return table, err
+	}
+	binary, err := binaryColumns(rows)
+	if err != nil {
+		return table, err
+	}
+
+	for rows.Next() {
+		values := make([]any, len(table.columns))
+		pointers := make([]any, len(table.columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return table, err
+		}
+		for i, value := range values {
+			// Drivers may reuse the buffer of bytes between rows, and give
+			// text columns as bytes, which can't be inserted back as is in
+			// columns like JSON ones.
+			if b, ok := value.([]byte); ok {
+				if binary == nil || binary[i] {
+					values[i] = bytes.Clone(b)
+				} else {
+					values[i] = string(b)
+				}
+			}
+		}
+		table.rows = append(table.rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return table, err
+	}
+	return table, nil
+}
+
+// binaryColumns returns which columns of rows are binary ones, or nil when
+// the types of the columns are unknown, like with rows not read through
+// database/sql.
+func binaryColumns(rows shared.Rows) ([]bool, error) {
+	typed, ok := rows.(interface {
+		ColumnTypes() ([]*sql.ColumnType, error)
+	})
+	if !ok {
+		return nil, nil
+	}
+	columnTypes, err := typed.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	binary := make([]bool, len(columnTypes))
+	for i, columnType := range columnTypes {
+		binary[i] = isBinaryColumn(columnType.DatabaseTypeName())
+	}
+	return binary, nil
+}
+
+func isBinaryColumn(databaseTypeName string) bool {
+	name := strings.ToUpper(databaseTypeName)
+	switch {
+	case strings.Contains(name, "BLOB"), strings.Contains(name, "BINARY"):
+		return true
+	case name == "BYTEA", name == "BYTES", name == "IMAGE", name == "UNIQUEIDENTIFIER":
+		return true
+	}
+	return false
+}
+
+// Restore cleans the fixture tables and puts back the rows they had before
+// fixtures were loaded. Calling it again does nothing. Sequences of the
+// tables are reset after the greatest value of their column, or to the value
+// given with ResetSequencesTo if it's higher.
+//
+// The tables are considered modified afterwards, so the next load reloads
+// them.
+func (b *Backup) Restore() error {
+	l := b.loader
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b.restored {
+		return nil
+	}
+	return l.withDatabaseLock(context.Background(), b.restore)
+}
+
+// restore is Restore for callers holding l.mu and the lock of
+// UseDatabaseLock.
+func (b *Backup) restore() error {
+	l := b.loader
+	var elapsed time.Duration
+	err := l.tracer.runStep(StepConstraints, &elapsed, func() error {
+		return l.disableReferentialIntegrity(func(tx shared.Querier) error {
+			err := l.tracer.runStep(StepCleanup, &elapsed, func() error {
+				for _, table := range b.tables {
+					if _, err := tx.Exec(l.helper.cleanTableQuery(l.helper.quoteKeyword(table.name))); err != nil {
+						return fmt.Errorf(`testfixtures: could not clean table "%s": %w`, table.name, err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return l.tracer.runStep(StepInsert, &elapsed, func() error {
+				statements := l.newStatementCache(tx)
+				err := b.insertTables(tx, statements)
+				if closeErr := statements.close(); err == nil {
+					err = closeErr
+				}
+				return err
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := l.tracer.runStep(StepResetSequences, &elapsed, func() error {
+		if err := l.helper.resetSequences(l.conn); err != nil {
+			return err
+		}
+		tables := make([]string, 0, len(b.tables))
+		for _, table := range b.tables {
+			tables = append(tables, table.name)
+		}
+		return l.helper.restoreSequences(l.conn, tables)
+	}); err != nil {
+		return err
+	}
+
+	b.restored = true
+	return nil
+}
+
+func (b *Backup) insertTables(tx shared.Querier, statements *statementCache) error {
+	l := b.loader
+	for _, table := range b.tables {
+		if len(table.rows) == 0 {
+			continue
+		}
+
+		columns := make([]string, 0, len(table.columns))
+		values := make([]string, 0, len(table.columns))
+		for i, column := range table.columns {
+			columns = append(columns, l.helper.quoteKeyword(column))
+			values = append(values, l.helper.paramType().placeholder(i+1))
+		}
+		query, err := l.helper.buildInsertSQL(l.conn, l.helper.quoteKeyword(table.name), columns, values)
+		if err != nil {
+			return err
+		}
+
+		err = l.helper.whileInsertOnTable(tx, table.name, func() error {
+			for _, row := range table.rows {
+				if _, err := statements.exec(query, row...); err != nil {
+					return fmt.Errorf(`testfixtures: could not restore table "%s": %w`, table.name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package testfixtures
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// errBatchAborted is given to hooks for the statements of a batch that were
+// not run, as a previous one failed.
+var errBatchAborted = errors.New("testfixtures: statement not run as a previous statement of the batch failed")
+
+// untraced returns the transaction under the tracing of hooks, to find out
+// what it implements.
+func untraced(tx shared.Querier) shared.Querier {
+	if t, ok := tx.(tracedTransaction); ok {
+		return t.tx
+	}
+	return tx
+}
+
+// copyFile inserts the records of a file with the copy protocol of the
+// database, if the transaction supports it and the records allow it.
+func (l *Loader) copyFile(tx shared.Querier, file *fixtureFile) (rowsInserted int64, ok bool, err error) {
+	copier, isCopier := untraced(tx).(shared.Copier)
+	if !isCopier || !l.canCopy(file) {
+		return 0, false, nil
+	}
+
+	tableName := file.tableName()
+	columns := file.insertSQLs[0].record.columnNames()
+	rows := make([][]any, 0, len(file.insertSQLs))
+	for _, insert := range file.insertSQLs {
+		rows = append(rows, insert.params)
+	}
+
+	done := l.tracer.trace(fmt.Sprintf("COPY %s FROM STDIN", l.helper.quoteKeyword(tableName)), nil)
+	rowsInserted, err = copier.CopyFrom(tableName, columns, rows)
+	done(err)
+	if err != nil {
+		return 0, true, fmt.Errorf(`testfixtures: could not copy records into table "%s": %w`, tableName, err)
+	}
+	return rowsInserted, true, nil
+}
+
+// canCopy returns whether the records of a file can be copied instead of
+// inserted: only on PostgreSQL, without upserts, identity columns and raw
+// SQL values, and when all records have the same columns.
+func (l *Loader) canCopy(file *fixtureFile) bool {
+	pg, ok := l.helper.(*postgreSQL)
+	if !ok || l.upsert || len(file.insertSQLs) == 0 {
+		return false
+	}
+	if pg.tableHasIdentityColumn(file.tableName()) {
+		return false
+	}
+	columns := file.insertSQLs[0].columns
+	for _, insert := range file.insertSQLs {
+		if !slices.Equal(insert.columns, columns) || len(insert.params) != len(insert.values) {
+			return false
+		}
+	}
+	return true
+}
+
+// batchFile inserts the records of a file in a single round trip, if the
+// transaction supports batches.
+func (l *Loader) batchFile(tx shared.Querier, file *fixtureFile) (rowsInserted int64, ok bool, err error) {
+	batcher, isBatcher := untraced(tx).(shared.Batcher)
+	if !isBatcher || len(file.insertSQLs) == 0 {
+		return 0, false, nil
+	}
+
+	var (
+		queries = make([]string, 0, len(file.insertSQLs))
+		args    = make([][]any, 0, len(file.insertSQLs))
+		dones   = make([]func(error), 0, len(file.insertSQLs))
+	)
+	for _, insert := range file.insertSQLs {
+		queries = append(queries, insert.sql)
+		args = append(args, insert.params)
+		dones = append(dones, l.tracer.trace(insert.sql, insert.params))
+	}
+
+	succeeded, err := batcher.ExecBatch(queries, args)
+	for i, done := range dones {
+		switch {
+		case i < succeeded:
+			done(nil)
+		case i == succeeded:
+			done(err)
+		default:
+			done(errBatchAborted)
+		}
+	}
+	if err != nil {
+		if succeeded < len(file.insertSQLs) {
+			return int64(succeeded), true, l.newInsertError(err, file, file.insertSQLs[succeeded])
+		}
+		return int64(succeeded), true, err
+	}
+	return int64(succeeded), true, nil
+}
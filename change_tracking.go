@@ -0,0 +1,59 @@
+package testfixtures
+
+import (
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// changeTrackingTable is the bookkeeping table where triggers record a
+// version for each table, which is incremented on every change.
+const changeTrackingTable = "testfixtures_table_versions"
+
+// tableVersionFunc returns the key a table is stored with in the bookkeeping
+// table, alongside its current version.
+type tableVersionFunc func(q shared.Querier, tableName string) (key string, version int64, err error)
+
+// changeTracking implements table change detection based on triggers. It is
+// embedded by the helpers that support it and enabled by
+// UseTriggerChangeTracking.
+type changeTracking struct {
+	trackChanges    bool
+	triggersCreated bool
+	versions        map[string]int64
+}
+
+func (c *changeTracking) isTableModified(q shared.Querier, tableName string, fn tableVersionFunc) (bool, error) {
+	if !c.triggersCreated {
+		return true, nil
+	}
+
+	key, version, err := fn(q, tableName)
+	if err != nil {
+		return true, err
+	}
+	snapshot, found := c.versions[key]
+	return !found || version != snapshot, nil
+}
+
+// snapshotVersions creates the triggers if it was not done yet, and stores
+// the current version of the given tables, so any later change can be
+// detected. Tables without a stored version are considered modified.
+func (c *changeTracking) snapshotVersions(q shared.Querier, createTriggers func(shared.Querier) error, tables []string, fn tableVersionFunc) error {
+	if !c.triggersCreated {
+		if err := createTriggers(q); err != nil {
+			return err
+		}
+		c.triggersCreated = true
+	}
+
+	if c.versions == nil {
+		c.versions = make(map[string]int64, len(tables))
+	}
+	for _, table := range tables {
+		key, version, err := fn(q, table)
+		if err != nil {
+			return err
+		}
+		c.versions[key] = version
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package testfixtures
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// checksumsTable is where table checksums are stored when
+// PersistTableChecksums is used.
+const checksumsTable = "testfixtures_checksums"
+
+type persistedChecksum struct {
+	fixturesChecksum string
+	tableChecksum    string
+}
+
+// PersistTableChecksums makes Loader store the checksum of each loaded table
+// in the "testfixtures_checksums" table of the test database, alongside a
+// hash of its fixtures. The table is created once checksums are first stored.
+//
+// By default checksums are only kept in memory, so each test binary starts
+// by reloading all tables. With this option, a Loader created by another
+// package or CI step against the same database skips the tables whose
+// fixtures and contents did not change since they were loaded.
+//
+// Only valid for PostgreSQL, MySQL, SQLite and SQL Server. Returns an error
+// otherwise.
+func PersistTableChecksums() func(*Loader) error {
+	return func(l *Loader) error {
+		switch l.helper.(type) {
+		case *postgreSQL, *mySQL, *sqlite, *sqlserver:
+			l.persistChecksums = true
+		default:
+			return fmt.Errorf("testfixtures: PersistTableChecksums is only valid for PostgreSQL, MySQL, SQLite and SQL Server databases")
+		}
+		return nil
+	}
+}
+
+// computeFixturesChecksums computes a hash of the fixtures of each table, so
+// changes in fixture files invalidate the persisted checksums.
+func (l *Loader) computeFixturesChecksums() {
+	hashes := make(map[string][]byte, len(l.fixturesFiles))
+	for _, file := range l.fixturesFiles {
+		tableName := file.tableName()
+
+		h := sha256.New()
+		h.Write(hashes[tableName])
+		h.Write(file.content)
+		file.writeChanges(h)
+		if l.location != nil {
+			h.Write([]byte(l.location.String()))
+		}
+		hashes[tableName] = h.Sum(nil)
+	}
+
+	l.fixturesChecksums = make(map[string]string, len(hashes))
+	for tableName, hash := range hashes {
+		l.fixturesChecksums[tableName] = hex.EncodeToString(hash)
+	}
+}
+
+// readPersistedChecksums returns the persisted checksums by table. There are
+// none until the checksums table is created by persistTablesChecksum.
+func (l *Loader) readPersistedChecksums() (map[string]persistedChecksum, error) {
+	checksums := make(map[string]persistedChecksum)
+	if !l.checksumsTableExists {
+		exists, err := internalTableExists(l.conn, l.helper, checksumsTable)
+		if err != nil || !exists {
+			return checksums, err
+		}
+		l.checksumsTableExists = true
+	}
+
+	rows, err := l.conn.Query(fmt.Sprintf("SELECT table_name, fixtures_checksum, table_checksum FROM %s", l.helper.quoteKeyword(checksumsTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var (
+			tableName string
+			checksum  persistedChecksum
+		)
+		if err = rows.Scan(&tableName, &checksum.fixturesChecksum, &checksum.tableChecksum); err != nil {
+			return nil, err
+		}
+		checksums[tableName] = checksum
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// fixturesChecksum returns the hash of the fixtures of a table. When only
+// some of its records are loaded, the selection is part of the hash.
+func (l *Loader) fixturesChecksum(tableName, selection string) string {
+	if selection == "" {
+		return l.fixturesChecksums[tableName]
+	}
+	h := sha256.New()
+	h.Write([]byte(l.fixturesChecksums[tableName]))
+	h.Write([]byte(selection))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (l *Loader) isPersistedTableModified(q shared.Querier, checksums map[string]persistedChecksum, tableName, fixturesChecksum string) (bool, error) {
+	persisted, found := checksums[tableName]
+	if !found || persisted.fixturesChecksum != fixturesChecksum {
+		return true, nil
+	}
+
+	checksum, err := l.helper.getChecksum(q, tableName)
+	if err != nil {
+		return true, err
+	}
+	return checksum != persisted.tableChecksum, nil
+}
+
+// persistTablesChecksum stores the checksum of the given tables, which must
+// have just been loaded with the given selections.
+func (l *Loader) persistTablesChecksum(tables []string, selections map[string]string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+
+	var (
+		table       = l.helper.quoteKeyword(checksumsTable)
+		paramType   = l.helper.paramType()
+		deleteQuery = fmt.Sprintf("DELETE FROM %s WHERE table_name = %s", table, paramType.placeholder(1))
+		insertQuery = fmt.Sprintf(
+			"INSERT INTO %s (table_name, fixtures_checksum, table_checksum) VALUES (%s, %s, %s)",
+			table,
+			paramType.placeholder(1),
+			paramType.placeholder(2),
+			paramType.placeholder(3),
+		)
+	)
+
+	if !l.checksumsTableExists {
+		createQuery := l.helper.createTableIfNotExistsQuery(
+			table,
+			"table_name VARCHAR(255) NOT NULL PRIMARY KEY, fixtures_checksum VARCHAR(64) NOT NULL, table_checksum VARCHAR(255) NOT NULL",
+		)
+		if _, err := l.conn.Exec(createQuery); err != nil {
+			return fmt.Errorf("testfixtures: could not create checksums table: %w", err)
+		}
+		l.checksumsTableExists = true
+	}
+
+	tx, err := l.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, tableName := range tables {
+		checksum, err := l.helper.getChecksum(tx, tableName)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteQuery, tableName); err != nil {
+			return fmt.Errorf("testfixtures: could not persist checksum of table %s: %w", tableName, err)
+		}
+		if _, err := tx.Exec(insertQuery, tableName, l.fixturesChecksum(tableName, selections[tableName]), checksum); err != nil {
+			return fmt.Errorf("testfixtures: could not persist checksum of table %s: %w", tableName, err)
+		}
+	}
+
+	return tx.Commit()
+}
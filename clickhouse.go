@@ -1,8 +1,9 @@
 package testfixtures
 
 import (
-	"database/sql"
 	"fmt"
+	"regexp"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
@@ -10,10 +11,11 @@ import (
 type clickhouse struct {
 	baseHelper
 
-	cleanTableFn func(string) string
+	cleanTableFn   func(string) string
+	tablesChecksum map[string]string
 }
 
-func (h *clickhouse) init(_ *sql.DB) error {
+func (h *clickhouse) init(_ shared.Querier) error {
 	if h.cleanTableFn == nil {
 		h.cleanTableFn = func(tableName string) string {
 			return fmt.Sprintf("TRUNCATE TABLE %s", tableName)
@@ -24,13 +26,13 @@ func (h *clickhouse) init(_ *sql.DB) error {
 }
 
 func (clickhouse) getDefaultParamType() ParamType { return ParamTypeDollar }
-func (*clickhouse) databaseName(q shared.Queryable) (string, error) {
+func (*clickhouse) databaseName(q shared.Querier) (string, error) {
 	var dbName string
 	err := q.QueryRow("SELECT DATABASE()").Scan(&dbName)
 	return dbName, err
 }
 
-func (h *clickhouse) tableNames(q shared.Queryable) ([]string, error) {
+func (h *clickhouse) tableNames(q shared.Querier) ([]string, error) {
 	query := `
 		SELECT name
 		FROM system.tables
@@ -64,7 +66,7 @@ func (h *clickhouse) tableNames(q shared.Queryable) ([]string, error) {
 
 }
 
-func (h *clickhouse) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *clickhouse) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -88,3 +90,56 @@ func (h *clickhouse) cleanTableQuery(tableName string) string {
 
 	return h.cleanTableFn(tableName)
 }
+
+func (h *clickhouse) sqlLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteStringWithBackslashes(v), nil
+	case []byte:
+		return fmt.Sprintf("unhex('%x')", v), nil
+	case time.Time:
+		return fmt.Sprintf("parseDateTime64BestEffort('%s', 9)", v.Format(time.RFC3339Nano)), nil
+	}
+	return h.baseHelper.sqlLiteral(value)
+}
+
+func (h *clickhouse) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	return isChecksumModified(q, h.tablesChecksum, tableName, h.getChecksum)
+}
+
+func (h *clickhouse) computeTablesChecksum(q shared.Querier, tables []string) error {
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, tables, h.getChecksum)
+	return err
+}
+
+func (h *clickhouse) getChecksum(q shared.Querier, tableName string) (string, error) {
+	sqlStr := fmt.Sprintf(`
+			SELECT concat(toString(count()), ':', toString(sum(cityHash64(*))), ':', toString(groupBitXor(cityHash64(*))))
+			FROM %s
+		`,
+		h.quoteKeyword(tableName),
+	)
+
+	var checksum string
+	if err := q.QueryRow(sqlStr).Scan(&checksum); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+var (
+	clickhouseErrorCodeRegexp = regexp.MustCompile(`code: (\d+)`)
+	clickhouseColumnRegexp    = regexp.MustCompile(`No such column (\S+)`)
+)
+
+func (*clickhouse) classifyInsertError(err error) (InsertErrorCause, string) {
+	message := err.Error()
+	switch submatch(clickhouseErrorCodeRegexp, message) {
+	case "16":
+		return CauseUnknownColumn, submatch(clickhouseColumnRegexp, message)
+	case "6", "27", "38", "41", "53", "70", "72":
+		return CauseTypeMismatch, ""
+	}
+	return CauseUnknown, ""
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	_ "github.com/go-sql-driver/mysql"
@@ -27,12 +28,19 @@ func main() {
 		dir                   string
 		files                 []string
 		paths                 []string
+		globs                 []string
+		layers                []string
+		recursive             bool
 		useDropContraint      bool
 		useAlterContraint     bool
 		skipResetSequences    bool
 		resetSequencesTo      int64
 		skipTestDatabaseCheck bool
+		skipSeedDatabaseCheck bool
+		upsert                bool
 		dumpFlag              bool
+		dryRun                bool
+		outputSQL             string
 	)
 
 	pflag.BoolVar(&versionFlag, "version", false, "show testfixtures version")
@@ -41,12 +49,19 @@ func main() {
 	pflag.StringVarP(&dir, "dir", "D", "", "a directory of YAML fixtures to load or to dump to")
 	pflag.StringSliceVarP(&files, "files", "f", nil, "a list of YAML files to load or tables to dump")
 	pflag.StringSliceVarP(&paths, "paths", "p", nil, "a list of fixture paths to load (directory or file)")
+	pflag.StringSliceVarP(&globs, "glob", "g", nil, `a list of patterns of fixture files to load, like "fixtures/**/*.yml"`)
+	pflag.StringSliceVarP(&layers, "layers", "l", nil, "a list of fixture paths to load as layers, each one overriding the records of the previous ones")
+	pflag.BoolVarP(&recursive, "recursive", "r", false, "also load the fixtures of the subdirectories of --dir and --paths")
 	pflag.BoolVar(&useDropContraint, "drop-constraint", false, "use ALTER CONSTRAINT to disable referential integrity (CockroachDB only)")
 	pflag.BoolVar(&useAlterContraint, "alter-constraint", false, "use ALTER CONSTRAINT to disable referential integrity (PostgreSQL only)")
 	pflag.BoolVar(&skipResetSequences, "no-reset-sequences", false, "skip reset of sequences after loading (PostgreSQL and MySQL/MariaDB only)")
 	pflag.Int64Var(&resetSequencesTo, "reset-sequences-to", 0, "sets the number sequences will be reset after loading fixtures (PostgreSQL and MySQL/MariaDB only, defaults to 10000)")
 	pflag.BoolVar(&skipTestDatabaseCheck, "dangerous-no-test-database-check", false, `skips check for "test" in database name (use with caution)`)
+	pflag.BoolVar(&skipSeedDatabaseCheck, "dangerous-no-seed-database-check", false, `skips check for "test" in database name when seeding only (use with caution)`)
+	pflag.BoolVar(&upsert, "upsert", false, "insert or update the fixture records instead of cleaning the tables, leaving other rows untouched")
 	pflag.BoolVar(&dumpFlag, "dump", false, "dumping fixtures from the database into a directory")
+	pflag.BoolVar(&dryRun, "dry-run", false, "print the SQL script that would load the fixtures instead of loading them")
+	pflag.StringVar(&outputSQL, "output-sql", "", "write the SQL script that would load the fixtures to a file instead of loading them")
 	pflag.Parse()
 
 	if versionFlag {
@@ -54,6 +69,14 @@ func main() {
 		return
 	}
 
+	// "seed" applies the fixture files not applied yet, and "status" lists
+	// them. "repair" restores the referential integrity of the database after
+	// an interrupted load. Without a command, fixtures are loaded.
+	command := pflag.Arg(0)
+	if command != "" && command != "seed" && command != "status" && command != "repair" {
+		log.Fatalf(`testfixtures: unknown command "%s", expected "seed", "status" or "repair"`, command)
+	}
+
 	if dialect == "" && connString == "" {
 		log.Fatal("testfixtures: both --dialect (-d) and --conn (-c) are required")
 		return
@@ -61,8 +84,8 @@ func main() {
 	if dumpFlag && dir == "" {
 		log.Fatalf("testfixtures: if use dump, --dir (-D) is required")
 	}
-	if !dumpFlag && dir == "" && len(files) == 0 && len(paths) == 0 {
-		log.Fatal("testfixtures: either --dir (-D) or --files (-f) or --paths (-p) need to be given")
+	if !dumpFlag && command != "repair" && dir == "" && len(files) == 0 && len(paths) == 0 && len(globs) == 0 && len(layers) == 0 {
+		log.Fatal("testfixtures: either --dir (-D) or --files (-f) or --paths (-p) or --glob (-g) or --layers (-l) need to be given")
 		return
 	}
 
@@ -90,6 +113,18 @@ func main() {
 		return
 	}
 
+	if command == "repair" {
+		statements, err := testfixtures.Repair(db, dialect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, statement := range statements {
+			log.Printf("testfixtures: ran %s", statement)
+		}
+		log.Printf("testfixtures: %d statements run to repair the database", len(statements))
+		return
+	}
+
 	if dumpFlag {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Fatal(err)
@@ -128,6 +163,15 @@ func main() {
 	if len(paths) > 0 {
 		options = append(options, testfixtures.Paths(paths...))
 	}
+	if len(globs) > 0 {
+		options = append(options, testfixtures.Glob(globs...))
+	}
+	if len(layers) > 0 {
+		options = append(options, testfixtures.Layers(layers...))
+	}
+	if recursive {
+		options = append(options, testfixtures.RecursiveDirectories())
+	}
 	if useDropContraint {
 		options = append(options, testfixtures.UseDropConstraint())
 	}
@@ -143,17 +187,89 @@ func main() {
 	if skipTestDatabaseCheck {
 		options = append(options, testfixtures.DangerousSkipTestDatabaseCheck())
 	}
+	if skipSeedDatabaseCheck {
+		options = append(options, testfixtures.DangerousSkipSeedDatabaseCheck())
+	}
+	if upsert {
+		options = append(options, testfixtures.UseUpsert())
+	}
 
 	loader, err := testfixtures.New(options...)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	switch command {
+	case "seed":
+		applied, err := loader.Seed()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, file := range applied {
+			log.Printf("testfixtures: applied %s", file.Path)
+		}
+		log.Printf("testfixtures: %d fixture files applied", len(applied))
+		return
+	case "status":
+		if err := printSeedStatus(loader); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if dryRun || outputSQL != "" {
+		if err := generateSQL(loader, outputSQL); err != nil {
+			log.Fatal(err)
+		}
+		if outputSQL != "" {
+			log.Printf("testfixtures: SQL script has been written to %s", outputSQL)
+		}
+		return
+	}
+
 	if err := loader.Load(); err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("testfixtures: fixtures loaded successfully")
 }
 
+func generateSQL(loader *testfixtures.Loader, outputSQL string) error {
+	if outputSQL == "" {
+		return loader.GenerateSQL(os.Stdout)
+	}
+
+	f, err := os.Create(outputSQL)
+	if err != nil {
+		return fmt.Errorf("testfixtures: could not create SQL file: %w", err)
+	}
+	if err := loader.GenerateSQL(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printSeedStatus(loader *testfixtures.Loader) error {
+	status, err := loader.SeedStatus()
+	if err != nil {
+		return err
+	}
+	for _, file := range status {
+		state, appliedAt := "applied", ""
+		if !file.AppliedAt.IsZero() {
+			appliedAt = file.AppliedAt.Format(time.RFC3339)
+		}
+		switch {
+		case file.AppliedAt.IsZero():
+			state = "pending"
+		case file.Pending:
+			state = "changed"
+		}
+		fmt.Printf("%-8s %-20s %s\n", state, appliedAt, file.Path)
+	}
+	return nil
+}
+
 func getDialect(dialect string) (string, error) {
 	switch dialect {
 	case "postgres", "postgresql", "timescaledb":
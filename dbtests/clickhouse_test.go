@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	_ "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/go-testfixtures/testfixtures/v3"
 )
 
 func TestClickhouse(t *testing.T) {
@@ -13,4 +14,8 @@ func TestClickhouse(t *testing.T) {
 	db := openDB(t, "clickhouse", connStr)
 	loadSchemaInBatchesBySplitter(t, db, "testdata/schema/clickhouse.sql", []byte(";\n"))
 	testLoader(t, db, "clickhouse")
+
+	t.Run("WithParallelism", func(t *testing.T) {
+		testLoader(t, db, "clickhouse", testfixtures.Parallelism(4))
+	})
 }
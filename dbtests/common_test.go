@@ -2,10 +2,13 @@ package dbtests
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,7 +19,7 @@ import (
 //go:embed testdata
 var fixtures embed.FS
 
-func openDB(t *testing.T, dialect, connStr string) *sql.DB {
+func openDB(t testing.TB, dialect, connStr string) *sql.DB {
 	t.Helper()
 	db, err := sql.Open(dialect, connStr)
 	if err != nil {
@@ -32,7 +35,7 @@ func openDB(t *testing.T, dialect, connStr string) *sql.DB {
 	return db
 }
 
-func loadSchemaInOneQuery(t *testing.T, db *sql.DB, schemaFilePath string) {
+func loadSchemaInOneQuery(t testing.TB, db *sql.DB, schemaFilePath string) {
 	t.Helper()
 	schema, err := os.ReadFile(schemaFilePath)
 	if err != nil {
@@ -53,7 +56,7 @@ func loadSchemaInBatchesBySplitter(t *testing.T, db *sql.DB, schemaFilePath stri
 	loadSchemaInBatches(t, db, batches)
 }
 
-func loadSchemaInBatches(t *testing.T, db *sql.DB, batches [][]byte) {
+func loadSchemaInBatches(t testing.TB, db *sql.DB, batches [][]byte) {
 	t.Helper()
 	for _, b := range batches {
 		if len(b) == 0 {
@@ -506,6 +509,137 @@ func testLoader(t *testing.T, db *sql.DB, dialect string, additionalOptions ...f
 		assertFixturesLoaded(t, db)
 	})
 
+	t.Run("ReloadModifiedTables", func(t *testing.T) {
+		if dialect == "clickhouse" {
+			t.Skip("ClickHouse does not support standard UPDATE statements")
+		}
+		options := append(
+			[]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect(dialect),
+				testfixtures.Template(),
+				testfixtures.TemplateData(map[string]interface{}{
+					"PostIds": []int{1, 2},
+					"TagIds":  []int{1, 2, 3},
+				}),
+				testfixtures.Files(
+					"testdata/fixtures/posts.yml",
+					"testdata/fixtures/comments.yml",
+					"testdata/fixtures/tags.yml",
+					"testdata/fixtures/posts_tags.yml",
+					"testdata/fixtures/users.yml",
+					"testdata/fixtures/assets.yml",
+					"testdata/fixtures/accounts.yml",
+					"testdata/fixtures/transactions.yml",
+				),
+			},
+			additionalOptions...,
+		)
+		l, err := testfixtures.New(options...)
+		if err != nil {
+			t.Errorf("failed to create Loader: %v", err)
+			return
+		}
+		if err := l.Load(); err != nil {
+			t.Errorf("cannot load fixtures: %v", err)
+		}
+
+		if _, err := db.Exec("UPDATE posts SET title = 'Modified' WHERE id = 1"); err != nil {
+			t.Errorf("cannot update post: %v", err)
+		}
+
+		// Reloading posts may cascade to other tables, which must be
+		// reloaded as well.
+		if err := l.Load(); err != nil {
+			t.Errorf("cannot load fixtures: %v", err)
+		}
+		assertFixturesLoaded(t, db)
+
+		var title string
+		if err := db.QueryRow("SELECT title FROM posts WHERE id = 1").Scan(&title); err != nil {
+			t.Errorf("cannot query post: %v", err)
+		}
+		if title != "Post 1" {
+			t.Errorf("post title should be reloaded, but is %q", title)
+		}
+	})
+
+	t.Run("GenerateSQL", func(t *testing.T) {
+		if dialect == "spanner" {
+			t.Skip("Spanner does not support loading fixtures from a directory")
+		}
+		options := append(
+			[]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect(dialect),
+				testfixtures.Template(),
+				testfixtures.TemplateData(map[string]interface{}{
+					"PostIds": []int{1, 2},
+					"TagIds":  []int{1, 2, 3},
+				}),
+				testfixtures.Directory("testdata/fixtures"),
+			},
+			additionalOptions...,
+		)
+		// Generating the script must not write to the database.
+		l, err := testfixtures.New(append(slices.Clip(options), testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+			if !isReadStatement(s.SQL) {
+				t.Errorf("GenerateSQL should only read the database, got %q", s.SQL)
+			}
+			return nil
+		}))...)
+		if err != nil {
+			t.Errorf("failed to create Loader: %v", err)
+			return
+		}
+
+		var script strings.Builder
+		if err := l.GenerateSQL(&script); err != nil {
+			t.Errorf("cannot generate SQL: %v", err)
+			return
+		}
+
+		// Records and columns are in the order of the fixture files, so
+		// another Loader generates the same script.
+		l2, err := testfixtures.New(options...)
+		if err != nil {
+			t.Errorf("failed to create Loader: %v", err)
+			return
+		}
+		var script2 strings.Builder
+		if err := l2.GenerateSQL(&script2); err != nil {
+			t.Errorf("cannot generate SQL: %v", err)
+			return
+		}
+		if script.String() != script2.String() {
+			t.Errorf("generated SQL should be deterministic")
+		}
+		if _, err := db.Exec("DELETE FROM posts_tags"); err != nil {
+			t.Errorf("cannot delete from table: %v", err)
+			return
+		}
+
+		// Run the script on a single connection, as it may use transactions.
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Errorf("cannot get connection: %v", err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		for _, statement := range strings.SplitAfter(script.String(), ";\n") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+				t.Errorf("cannot run statement %q: %v", statement, err)
+				return
+			}
+		}
+		assertFixturesLoaded(t, db)
+	})
+
 	t.Run("GenerateAndLoad", func(t *testing.T) {
 		if dialect == "spanner" {
 			t.Skip("Spanner does not support loading fixtures from a directory")
@@ -595,3 +729,16 @@ func assertCount(t *testing.T, db *sql.DB, table string, expectedCount int) {
 		t.Errorf("%s should have %d, but has %d", table, expectedCount, count)
 	}
 }
+
+// isReadStatement returns whether the statement only reads the database.
+func isReadStatement(statement string) bool {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "SHOW", "PRAGMA":
+		return true
+	}
+	return false
+}
@@ -156,7 +156,7 @@ func createPostgreSQLContainer(t *testing.T) string {
 	return createConnString(host, port)
 }
 
-func createSQLite(t *testing.T) string {
+func createSQLite(t testing.TB) string {
 	t.Helper()
 
 	if connStr := os.Getenv(sqliteConnStringEnv); connStr != "" {
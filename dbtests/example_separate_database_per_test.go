@@ -12,6 +12,12 @@ import (
 func TestExampleSeparateDatabasePerTest(t *testing.T) {
 	t.Parallel()
 
+	// Read and parse the fixtures once for all databases.
+	set, err := testfixtures.NewFixtureSet(
+		testfixtures.Directory("testdata/fixtures_dirs/fixtures2"),
+	)
+	require.NoError(t, err)
+
 	// Create a separate database for each test.
 	setupDB := func(t *testing.T) *sql.DB {
 		t.Helper()
@@ -21,10 +27,9 @@ func TestExampleSeparateDatabasePerTest(t *testing.T) {
 		db := openDB(t, "postgres", connString)
 		loadSchemaInOneQuery(t, db, "testdata/schema/postgresql.sql")
 
-		fixtures, err := testfixtures.New(
-			testfixtures.Database(db),
-			testfixtures.Dialect("postgres"),
-			testfixtures.Directory("testdata/fixtures_dirs/fixtures2"),
+		fixtures, err := set.Loader(
+			db,
+			"postgres",
 			testfixtures.SkipTableChecksumComputation(), // not needed in this example as we use fixtures only once per database
 		)
 		require.NoError(t, err)
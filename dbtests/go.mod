@@ -8,10 +8,12 @@ require (
 	github.com/denisenkom/go-mssqldb v0.12.3
 	github.com/docker/go-connections v0.7.0
 	github.com/go-sql-driver/mysql v1.10.0
+	github.com/go-testfixtures/testfixtures/pgxfixtures/v3 v3.0.0
 	github.com/go-testfixtures/testfixtures/v3 v3.0.0
 	github.com/google/uuid v1.6.0
 	github.com/googleapis/go-sql-spanner v1.25.1
 	github.com/jackc/pgx/v4 v4.18.3
+	github.com/jackc/pgx/v5 v5.10.0
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.44
@@ -75,6 +77,7 @@ require (
 	github.com/jackc/pgproto3/v2 v2.3.3 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/pgtype v1.14.0 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	github.com/klauspost/compress v1.18.3 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
 	github.com/magiconair/properties v1.8.10 // indirect
@@ -130,3 +133,5 @@ require (
 )
 
 replace github.com/go-testfixtures/testfixtures/v3 => ../.
+
+replace github.com/go-testfixtures/testfixtures/pgxfixtures/v3 => ../pgxfixtures
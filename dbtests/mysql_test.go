@@ -22,4 +22,47 @@ func TestMySQL(t *testing.T) {
 		loadSchemaInOneQuery(t, db, "testdata/schema/mysql.sql")
 		testLoader(t, db, "mysql", testfixtures.AllowMultipleStatementsInOneQuery())
 	})
+
+	t.Run("WithTriggerChangeTracking", func(t *testing.T) {
+		db := openDB(t, "mysql", connStr)
+		loadSchemaInBatchesBySplitter(t, db, "testdata/schema/mysql.sql", []byte(";\n"))
+		testLoader(t, db, "mysql", testfixtures.UseTriggerChangeTracking())
+	})
+
+	t.Run("TruncateWithTriggerChangeTracking", func(t *testing.T) {
+		db := openDB(t, "mysql", connStr)
+		loadSchemaInBatchesBySplitter(t, db, "testdata/schema/mysql.sql", []byte(";\n"))
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("mysql"),
+			testfixtures.UseTriggerChangeTracking(),
+			testfixtures.Template(),
+			testfixtures.TemplateData(map[string]interface{}{
+				"PostIds": []int{1, 2},
+				"TagIds":  []int{1, 2, 3},
+			}),
+			testfixtures.Directory("testdata/fixtures"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		// TRUNCATE TABLE doesn't fire triggers.
+		if _, err := db.Exec("TRUNCATE TABLE posts_tags"); err != nil {
+			t.Fatalf("cannot truncate table: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertFixturesLoaded(t, db)
+	})
+
+	t.Run("WithVerifyReferentialIntegrity", func(t *testing.T) {
+		db := openDB(t, "mysql", connStr)
+		loadSchemaInBatchesBySplitter(t, db, "testdata/schema/mysql.sql", []byte(";\n"))
+		testLoader(t, db, "mysql", testfixtures.VerifyReferentialIntegrity())
+	})
 }
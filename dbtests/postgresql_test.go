@@ -1,10 +1,16 @@
 package dbtests
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/go-testfixtures/testfixtures/pgxfixtures/v3"
 	"github.com/go-testfixtures/testfixtures/v3"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/lib/pq"
 )
 
@@ -23,6 +29,217 @@ func TestPostgreSQL(t *testing.T) {
 	t.Run("WithDropConstraint", func(t *testing.T) {
 		testPostgreSQL(t, connStr, testfixtures.UseDropConstraint())
 	})
+
+	t.Run("WithTriggerChangeTracking", func(t *testing.T) {
+		testPostgreSQL(t, connStr, testfixtures.UseTriggerChangeTracking())
+	})
+
+	t.Run("WithParallelism", func(t *testing.T) {
+		testPostgreSQL(t, connStr, testfixtures.UseDropConstraint(), testfixtures.Parallelism(4))
+	})
+
+	t.Run("WithVerifyReferentialIntegrity", func(t *testing.T) {
+		testPostgreSQL(t, connStr, testfixtures.VerifyReferentialIntegrity())
+	})
+
+	t.Run("WithPgxPool", func(t *testing.T) {
+		testPostgreSQL(t, connStr, pgxfixtures.Database(openPgxPool(t, connStr)))
+	})
+
+	t.Run("WithPgxPoolAndCopyFrom", func(t *testing.T) {
+		testPostgreSQL(t, connStr, pgxfixtures.Database(openPgxPool(t, connStr), pgxfixtures.UseCopyFrom()))
+	})
+
+	t.Run("RestoreSequences", func(t *testing.T) {
+		db := openDB(t, "postgres", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/postgresql.sql")
+
+		_, err := db.Exec(`INSERT INTO posts (id, title, content, created_at, updated_at) OVERRIDING SYSTEM VALUE VALUES (20000, 'Mine', 'Mine', NOW(), NOW())`)
+		if err != nil {
+			t.Fatalf("cannot insert post: %v", err)
+		}
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("postgres"),
+			testfixtures.Files("testdata/fixtures/posts.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		backup, err := l.LoadWithBackup()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		if err := backup.Restore(); err != nil {
+			t.Fatalf("cannot restore backup: %v", err)
+		}
+
+		// The sequence is after the restored rows, not at the default 10000.
+		var id int
+		err = db.QueryRow(`INSERT INTO posts (title, content, created_at, updated_at) VALUES ('New', 'New', NOW(), NOW()) RETURNING id`).Scan(&id)
+		if err != nil {
+			t.Fatalf("cannot insert post: %v", err)
+		}
+		if id <= 20000 {
+			t.Errorf("expected a generated id after 20000, got %d", id)
+		}
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		db := openDB(t, "postgres", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/postgresql.sql")
+
+		// Simulate a process that died while loading with dropped constraints.
+		_, err := db.Exec(`
+			ALTER TABLE comments DROP CONSTRAINT comments_post_id_fkey;
+			DROP TABLE IF EXISTS testfixtures_journal;
+			CREATE TABLE testfixtures_journal (load_id VARCHAR(32) NOT NULL, position INTEGER NOT NULL, statement TEXT NOT NULL, PRIMARY KEY (load_id, position));
+			INSERT INTO testfixtures_journal VALUES ('dead', 0, 'ALTER TABLE comments ADD CONSTRAINT comments_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE');
+		`)
+		if err != nil {
+			t.Fatalf("cannot simulate interrupted load: %v", err)
+		}
+
+		newLoader := func(options ...func(*testfixtures.Loader) error) *testfixtures.Loader {
+			t.Helper()
+			l, err := testfixtures.New(append([]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect("postgres"),
+				testfixtures.UseDropConstraint(),
+				testfixtures.Template(),
+				testfixtures.TemplateData(map[string]interface{}{
+					"PostIds": []int{1, 2},
+					"TagIds":  []int{1, 2, 3},
+				}),
+				testfixtures.Directory("testdata/fixtures"),
+			}, options...)...)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			return l
+		}
+
+		// Without the database lock, the journal of other loads is left
+		// alone, as they may still be running.
+		if err := newLoader().Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 0)
+		assertCount(t, db, "testfixtures_journal", 1)
+
+		if err := newLoader(testfixtures.UseDatabaseLock("", time.Minute)).Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 1)
+		assertCount(t, db, "testfixtures_journal", 0)
+
+		statements, err := testfixtures.Repair(db, "postgres")
+		if err != nil {
+			t.Fatalf("cannot repair database: %v", err)
+		}
+		if len(statements) != 0 {
+			t.Errorf("expected nothing to repair, got %v", statements)
+		}
+	})
+
+	t.Run("VerifyReferentialIntegrityWithDropConstraint", func(t *testing.T) {
+		db := openDB(t, "postgres", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/postgresql.sql")
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("postgres"),
+			testfixtures.UseDropConstraint(),
+			testfixtures.VerifyReferentialIntegrity(),
+			testfixtures.FS(fstest.MapFS{
+				"comments.yml": {Data: []byte(`
+orphan:
+  id: 1
+  post_id: 99
+  author_name: John Doe
+  author_email: john@doe.com
+  content: Orphan
+  created_at: 2020-01-01 00:00:00
+  updated_at: 2020-01-01 00:00:00
+`)},
+			}),
+			testfixtures.Files("comments.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+
+		// The foreign keys are checked even though they are dropped while
+		// loading.
+		var integrityErr *testfixtures.ReferentialIntegrityError
+		if err := l.Load(); !errors.As(err, &integrityErr) {
+			t.Fatalf("expected a referential integrity error, got %v", err)
+		}
+		if len(integrityErr.Violations) != 1 || integrityErr.Violations[0].Label != "orphan" {
+			t.Errorf("expected the orphan comment to be reported, got %v", integrityErr.Violations)
+		}
+		assertCount(t, db, "comments", 0)
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 1)
+	})
+
+	t.Run("RepairAfterFailedRestore", func(t *testing.T) {
+		db := openDB(t, "postgres", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/postgresql.sql")
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("postgres"),
+			testfixtures.UseDropConstraint(),
+			testfixtures.FS(fstest.MapFS{
+				"comments.yml": {Data: []byte(`
+- id: 1
+  post_id: 99
+  author_name: John Doe
+  author_email: john@doe.com
+  content: Orphan
+  created_at: 2020-01-01 00:00:00
+  updated_at: 2020-01-01 00:00:00
+`)},
+			}),
+			testfixtures.Files("comments.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+
+		// The constraint can't be added back over the orphan comment, so the
+		// journal is kept.
+		if err := l.Load(); err == nil {
+			t.Fatal("expected an error restoring the constraints")
+		}
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 0)
+		assertCount(t, db, "testfixtures_journal", 1)
+
+		if _, err := db.Exec("DELETE FROM comments"); err != nil {
+			t.Fatalf("cannot delete orphan comment: %v", err)
+		}
+		statements, err := testfixtures.Repair(db, "postgres")
+		if err != nil {
+			t.Fatalf("cannot repair database: %v", err)
+		}
+		if len(statements) != 1 {
+			t.Fatalf("expected one statement to repair, got %v", statements)
+		}
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 1)
+		assertCount(t, db, "testfixtures_journal", 0)
+
+		// A process dying after adding the constraint back but before
+		// clearing the journal doesn't prevent repairing.
+		_, err = db.Exec("INSERT INTO testfixtures_journal VALUES ('dead', 0, $1)", statements[0])
+		if err != nil {
+			t.Fatalf("cannot simulate interrupted load: %v", err)
+		}
+		if _, err := testfixtures.Repair(db, "postgres"); err != nil {
+			t.Fatalf("cannot repair database with the constraint already added: %v", err)
+		}
+		assertCount(t, db, "pg_constraint WHERE conname = 'comments_post_id_fkey'", 1)
+		assertCount(t, db, "testfixtures_journal", 0)
+	})
 }
 
 func testPostgreSQL(t *testing.T, connStr string, additionalOptions ...func(*testfixtures.Loader) error) {
@@ -40,3 +257,13 @@ func testPostgreSQL(t *testing.T, connStr string, additionalOptions ...func(*tes
 		})
 	}
 }
+
+func openPgxPool(t testing.TB, connStr string) *pgxpool.Pool {
+	t.Helper()
+	pool, err := pgxpool.New(context.Background(), connStr)
+	if err != nil {
+		t.Fatalf("failed to open pgx pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
@@ -1,7 +1,18 @@
 package dbtests
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3"
 	_ "github.com/mattn/go-sqlite3"
@@ -10,8 +21,1241 @@ import (
 func TestSQLite(t *testing.T) {
 	t.Parallel()
 
+	t.Run("Standard", func(t *testing.T) {
+		testSQLite(t, testfixtures.DangerousSkipTestDatabaseCheck())
+	})
+
+	t.Run("WithTriggerChangeTracking", func(t *testing.T) {
+		testSQLite(t, testfixtures.DangerousSkipTestDatabaseCheck(), testfixtures.UseTriggerChangeTracking())
+	})
+
+	t.Run("WithPersistedTableChecksums", func(t *testing.T) {
+		testSQLite(t, testfixtures.DangerousSkipTestDatabaseCheck(), testfixtures.PersistTableChecksums())
+	})
+
+	t.Run("WithVerifyReferentialIntegrity", func(t *testing.T) {
+		testSQLite(t, testfixtures.DangerousSkipTestDatabaseCheck(), testfixtures.VerifyReferentialIntegrity())
+	})
+
+	t.Run("PersistedTableChecksumsAcrossLoaders", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		newLoader := func(files ...string) *testfixtures.Loader {
+			t.Helper()
+			l, err := testfixtures.New(
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.DangerousSkipTestDatabaseCheck(),
+				testfixtures.PersistTableChecksums(),
+				testfixtures.Files(files...),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			return l
+		}
+
+		if err := newLoader("testdata/fixtures/users.yml", "testdata/fixtures/assets.yml").Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		if _, err := db.Exec(`
+			CREATE TRIGGER assets_no_insert BEFORE INSERT ON assets
+			BEGIN
+				SELECT RAISE(ABORT, 'assets must not be reloaded');
+			END
+		`); err != nil {
+			t.Fatalf("cannot create trigger: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+			t.Fatalf("cannot delete user: %v", err)
+		}
+
+		// A new Loader, as created by another test binary, knows that
+		// assets were not modified since they were loaded.
+		if err := newLoader("testdata/fixtures/users.yml", "testdata/fixtures/assets.yml").Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+		assertCount(t, db, "assets", 1)
+
+		// Different fixtures for the same table invalidate the checksum.
+		usersFile := filepath.Join(t.TempDir(), "users.yml")
+		if err := os.WriteFile(usersFile, []byte("- id: 1\n  attributes: {}\n"), 0o644); err != nil {
+			t.Fatalf("cannot write fixture file: %v", err)
+		}
+		if err := newLoader(usersFile).Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "users", 1)
+	})
+
+	t.Run("PersistedTableChecksumsTableCreatedOnce", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		var creates atomic.Int32
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.PersistTableChecksums(),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+			testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+				if strings.Contains(s.SQL, "CREATE TABLE") {
+					creates.Add(1)
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if creates.Load() != 0 {
+			t.Errorf("New should not create the checksums table")
+		}
+		for range 3 {
+			if _, err := db.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+				t.Fatalf("cannot delete user: %v", err)
+			}
+			if err := l.Load(); err != nil {
+				t.Fatalf("cannot load fixtures: %v", err)
+			}
+		}
+		if n := creates.Load(); n != 1 {
+			t.Errorf("expected the checksums table to be created once, got %d statements", n)
+		}
+		assertCount(t, db, "users", 2)
+	})
+
+	t.Run("InsertError", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		usersFile := filepath.Join(t.TempDir(), "users.yml")
+		content := "john:\n  id: 1\n  attributes: {}\n\njane:\n  id: 2\n  attributes: {}\n\nbob:\n  attributes: {}\n  id: 1\n"
+		if err := os.WriteFile(usersFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("cannot write fixture file: %v", err)
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Files(usersFile),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		err = l.Load()
+
+		var insertErr *testfixtures.InsertError
+		if !errors.As(err, &insertErr) {
+			t.Fatalf("expected an InsertError, got %v", err)
+		}
+		if insertErr.Label != "bob" || insertErr.Index != 2 || insertErr.Table != "users" || insertErr.Path != usersFile {
+			t.Errorf("unexpected record in error: %+v", insertErr)
+		}
+		if insertErr.Cause != testfixtures.CauseUniqueViolation || insertErr.ColumnName != "id" {
+			t.Errorf("unexpected cause in error: %+v", insertErr)
+		}
+		if insertErr.Line != 11 || insertErr.Column != 3 {
+			t.Errorf("expected error at 11:3, got %d:%d", insertErr.Line, insertErr.Column)
+		}
+	})
+
+	t.Run("LoadTablesAndGroups", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		dir := t.TempDir()
+		files := map[string]string{
+			"core/users.yml":     "john:\n  id: 1\n  attributes: {}\njane:\n  id: 2\n  attributes: {}\n  _tags: billing\n",
+			"billing/assets.yml": "- id: 1\n  data: 0x1234\n",
+		}
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("cannot create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("cannot write fixture file: %v", err)
+			}
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Files(filepath.Join(dir, "core/users.yml"), filepath.Join(dir, "billing/assets.yml")),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		ctx := context.Background()
+
+		if err := l.LoadTables(ctx, "assets"); err != nil {
+			t.Fatalf("cannot load tables: %v", err)
+		}
+		assertCount(t, db, "users", 0)
+		assertCount(t, db, "assets", 1)
+
+		if err := l.LoadGroups(ctx, "billing"); err != nil {
+			t.Fatalf("cannot load groups: %v", err)
+		}
+		assertCount(t, db, "users", 1)
+		assertCount(t, db, "assets", 1)
+
+		// The users table was partially loaded, so it is reloaded even
+		// though it was not modified since.
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+
+		if err := l.LoadTables(ctx, "unknown"); err == nil {
+			t.Error("expected an error for a table without fixtures")
+		}
+		if err := l.LoadGroups(ctx, "unknown"); err == nil {
+			t.Error("expected an error for an empty group")
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := l.LoadTables(canceled, "users"); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("LoadTablesCanceledWhileLoading", func(t *testing.T) {
+		db := openDB(t, "sqlite3", createSQLite(t))
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+			testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+				if s.Step == testfixtures.StepInsert {
+					cancel()
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+
+		// The context is given to the driver, so the statements following
+		// the cancellation fail, and the load is rolled back.
+		if err := l.LoadTables(ctx, "users"); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		assertCount(t, db, "users", 0)
+	})
+
+	t.Run("LoadAfterLoadTables", func(t *testing.T) {
+		for name, option := range map[string]func(*testfixtures.Loader) error{
+			"Checksums":      testfixtures.DangerousSkipTestDatabaseCheck(),
+			"ChangeTracking": testfixtures.UseTriggerChangeTracking(),
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := openDB(t, "sqlite3", createSQLite(t))
+				loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+				l, err := testfixtures.New(
+					testfixtures.Database(db),
+					testfixtures.Dialect("sqlite3"),
+					testfixtures.DangerousSkipTestDatabaseCheck(),
+					option,
+					testfixtures.Files(
+						"testdata/fixtures/users.yml",
+						"testdata/fixtures/posts.yml",
+						"testdata/fixtures/assets.yml",
+					),
+				)
+				if err != nil {
+					t.Fatalf("failed to create Loader: %v", err)
+				}
+
+				if err := l.LoadTables(context.Background(), "users"); err != nil {
+					t.Fatalf("cannot load tables: %v", err)
+				}
+				assertCount(t, db, "posts", 0)
+
+				// The tables not loaded yet have no checksum, so they must
+				// be loaded.
+				result, err := l.LoadWithResult()
+				if err != nil {
+					t.Fatalf("cannot load fixtures: %v", err)
+				}
+				assertCount(t, db, "users", 2)
+				assertCount(t, db, "posts", 2)
+				assertCount(t, db, "assets", 1)
+				for _, table := range result.Tables {
+					if skipped := table.Name == "users"; table.Skipped != skipped {
+						t.Errorf("expected table %s skipped to be %v", table.Name, skipped)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("TriggerChangeTrackingWithQuoteInTableName", func(t *testing.T) {
+		db := openDB(t, "sqlite3", createSQLite(t))
+		if _, err := db.Exec(`CREATE TABLE "o'brien" (id INTEGER PRIMARY KEY)`); err != nil {
+			t.Fatalf("cannot create table: %v", err)
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.UseTriggerChangeTracking(),
+			testfixtures.FS(fstest.MapFS{
+				"o'brien.yml": {Data: []byte("- id: 1\n")},
+			}),
+			testfixtures.Files("o'brien.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		if _, err := db.Exec(`INSERT INTO "o'brien" (id) VALUES (2)`); err != nil {
+			t.Fatalf("cannot insert row: %v", err)
+		}
+		result, err := l.LoadWithResult()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		if len(result.Tables) != 1 || result.Tables[0].Skipped {
+			t.Errorf("expected the modified table to be reloaded, got %+v", result.Tables)
+		}
+		assertCount(t, db, `"o'brien"`, 1)
+	})
+
+	t.Run("UseUpsert", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		if _, err := db.Exec(`INSERT INTO users (id, attributes) VALUES (1, 'outdated'), (100, 'not a fixture')`); err != nil {
+			t.Fatalf("cannot insert users: %v", err)
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.UseUpsert(),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		for range 2 {
+			if err := l.Load(); err != nil {
+				t.Fatalf("cannot load fixtures: %v", err)
+			}
+		}
+
+		assertCount(t, db, "users", 3)
+		var attributes string
+		if err := db.QueryRow("SELECT attributes FROM users WHERE id = 1").Scan(&attributes); err != nil {
+			t.Fatalf("cannot query user: %v", err)
+		}
+		if attributes == "outdated" {
+			t.Error("existing fixture record should have been updated")
+		}
+	})
+
+	t.Run("Seed", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		usersFile := filepath.Join(t.TempDir(), "users.yml")
+		seed := func(content string) []testfixtures.SeedFile {
+			t.Helper()
+			if err := os.WriteFile(usersFile, []byte(content), 0o644); err != nil {
+				t.Fatalf("cannot write fixture file: %v", err)
+			}
+			l, err := testfixtures.New(
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.DangerousSkipTestDatabaseCheck(),
+				testfixtures.UseUpsert(),
+				testfixtures.Files(usersFile, "testdata/fixtures/assets.yml"),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			applied, err := l.Seed()
+			if err != nil {
+				t.Fatalf("cannot seed: %v", err)
+			}
+			status, err := l.SeedStatus()
+			if err != nil {
+				t.Fatalf("cannot get seed status: %v", err)
+			}
+			for _, file := range status {
+				if file.Pending || file.AppliedAt.IsZero() {
+					t.Errorf("%s should have been applied", file.Path)
+				}
+			}
+			return applied
+		}
+
+		if applied := seed("- id: 1\n  attributes: {}\n"); len(applied) != 2 {
+			t.Errorf("expected all files to be applied, got %+v", applied)
+		}
+		if _, err := db.Exec(`INSERT INTO users (id, attributes) VALUES (100, '{}')`); err != nil {
+			t.Fatalf("cannot insert user: %v", err)
+		}
+		if applied := seed("- id: 1\n  attributes: {}\n"); len(applied) != 0 {
+			t.Errorf("expected no file to be applied, got %+v", applied)
+		}
+		if applied := seed("- id: 1\n  attributes: {}\n- id: 2\n  attributes: {}\n"); len(applied) != 1 || applied[0].Path != usersFile {
+			t.Errorf("expected the changed file to be applied, got %+v", applied)
+		}
+		assertCount(t, db, "users", 3)
+		assertCount(t, db, "assets", 1)
+
+		// The rows of the records removed from the file are deleted, others
+		// are kept.
+		if applied := seed("- id: 2\n  attributes: {}\n"); len(applied) != 1 {
+			t.Errorf("expected the changed file to be applied, got %+v", applied)
+		}
+		assertCount(t, db, "users", 2)
+		assertCount(t, db, "users WHERE id = 1", 0)
+	})
+
+	t.Run("SeedChangedFileWithoutUpsert", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		usersFile := filepath.Join(t.TempDir(), "users.yml")
+		newLoader := func(content string) *testfixtures.Loader {
+			t.Helper()
+			if err := os.WriteFile(usersFile, []byte(content), 0o644); err != nil {
+				t.Fatalf("cannot write fixture file: %v", err)
+			}
+			l, err := testfixtures.New(
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.DangerousSkipTestDatabaseCheck(),
+				testfixtures.Files(usersFile),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			return l
+		}
+
+		// The status is read without creating the seeds table.
+		status, err := newLoader("- id: 1\n  attributes: {}\n").SeedStatus()
+		if err != nil {
+			t.Fatalf("cannot get seed status: %v", err)
+		}
+		if len(status) != 1 || !status[0].Pending {
+			t.Errorf("expected the file to be pending, got %+v", status)
+		}
+		assertCount(t, db, "sqlite_master WHERE name = 'testfixtures_seeds'", 0)
+
+		if _, err := newLoader("- id: 1\n  attributes: {}\n").Seed(); err != nil {
+			t.Fatalf("cannot seed: %v", err)
+		}
+		if _, err := newLoader("- id: 1\n  attributes: {\"changed\": true}\n- id: 2\n  attributes: {}\n").Seed(); err != nil {
+			t.Fatalf("cannot seed the changed file: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+		assertCount(t, db, `users WHERE id = 1 AND attributes = '{"changed":true}'`, 1)
+
+		// The rows of the previous version of the file are deleted, even
+		// those of the records that were removed from it.
+		if _, err := newLoader("- id: 3\n  attributes: {}\n").Seed(); err != nil {
+			t.Fatalf("cannot seed the changed file: %v", err)
+		}
+		assertCount(t, db, "users", 1)
+		assertCount(t, db, "users WHERE id = 3", 1)
+	})
+
+	t.Run("DangerousSkipSeedDatabaseCheck", func(t *testing.T) {
+		connStr := "file:" + filepath.Join(t.TempDir(), "dev.sqlite3")
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipSeedDatabaseCheck(),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if _, err := l.Seed(); err != nil {
+			t.Fatalf("cannot seed: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+		if err := l.Load(); err == nil {
+			t.Error("expected loading fixtures to still check the database")
+		}
+	})
+
+	t.Run("VerifyReferentialIntegrity", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.VerifyReferentialIntegrity(),
+			testfixtures.Template(),
+			testfixtures.TemplateData(map[string]any{
+				"PostIds": []int{1, 2},
+				"TagIds":  []int{1, 2, 3},
+			}),
+			testfixtures.Directory("testdata/fixtures"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		commentsFile := filepath.Join(t.TempDir(), "comments.yml")
+		const comments = `
+valid:
+  id: 1
+  post_id: 1
+  author_name: John Doe
+  author_email: john@doe.com
+  content: Valid comment
+  created_at: 2016-01-01 12:30:12
+  updated_at: 2016-01-01 12:30:12
+orphan:
+  id: 2
+  post_id: 99
+  author_name: John Doe
+  author_email: john@doe.com
+  content: Orphan comment
+  created_at: 2016-01-01 12:30:12
+  updated_at: 2016-01-01 12:30:12
+`
+		if err := os.WriteFile(commentsFile, []byte(comments), 0o644); err != nil {
+			t.Fatalf("cannot write fixture file: %v", err)
+		}
+		l, err = testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.VerifyReferentialIntegrity(),
+			testfixtures.Files("testdata/fixtures/posts.yml", commentsFile),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		err = l.Load()
+
+		var integrityErr *testfixtures.ReferentialIntegrityError
+		if !errors.As(err, &integrityErr) {
+			t.Fatalf("expected a ReferentialIntegrityError, got %v", err)
+		}
+		if len(integrityErr.Violations) != 1 {
+			t.Fatalf("expected one violation, got %+v", integrityErr.Violations)
+		}
+		violation := integrityErr.Violations[0]
+		if violation.Table != "comments" || violation.Label != "orphan" || violation.Line != 12 ||
+			violation.ReferencedTable != "posts" || !slices.Equal(violation.ReferencedColumns, []string{"id"}) ||
+			fmt.Sprint(violation.Values) != "[99]" {
+			t.Errorf("unexpected violation %+v", violation)
+		}
+		if !strings.Contains(err.Error(), `record "orphan" of table "comments": (post_id) = (99) not found in posts (id)`) {
+			t.Errorf("unexpected error message: %v", err)
+		}
+		// The load was rolled back.
+		assertCount(t, db, "comments", 4)
+
+		// Reloading a table may leave rows of the other tables referencing
+		// the rows that were removed.
+		_, err = db.Exec(`
+			CREATE TABLE authors (id INTEGER PRIMARY KEY);
+			CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER REFERENCES authors (id));
+			INSERT INTO authors VALUES (1), (2);
+			INSERT INTO books VALUES (1, 1), (2, 2);
+		`)
+		if err != nil {
+			t.Fatalf("cannot create tables: %v", err)
+		}
+		authorsFile := filepath.Join(t.TempDir(), "authors.yml")
+		if err := os.WriteFile(authorsFile, []byte("- id: 1\n"), 0o644); err != nil {
+			t.Fatalf("cannot write fixture file: %v", err)
+		}
+		l, err = testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.VerifyReferentialIntegrity(),
+			testfixtures.Files(authorsFile),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		err = l.Load()
+		if !errors.As(err, &integrityErr) {
+			t.Fatalf("expected a ReferentialIntegrityError, got %v", err)
+		}
+		if len(integrityErr.Violations) != 1 {
+			t.Fatalf("expected one violation, got %+v", integrityErr.Violations)
+		}
+		violation = integrityErr.Violations[0]
+		if violation.Table != "books" || violation.ReferencedTable != "authors" || fmt.Sprint(violation.Values) != "[2]" || violation.Path != "" {
+			t.Errorf("unexpected violation %+v", violation)
+		}
+		assertCount(t, db, "authors", 2)
+	})
+
+	t.Run("LoadWithBackup", func(t *testing.T) {
+		connStr := createSQLite(t)
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		if _, err := db.Exec(`INSERT INTO users (id, attributes) VALUES (100, '{"name": "mine"}')`); err != nil {
+			t.Fatalf("cannot insert user: %v", err)
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		backup, err := l.LoadWithBackup()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+
+		for range 2 {
+			if err := backup.Restore(); err != nil {
+				t.Fatalf("cannot restore backup: %v", err)
+			}
+		}
+		assertCount(t, db, "users", 1)
+		var attributes string
+		if err := db.QueryRow("SELECT attributes FROM users WHERE id = 100").Scan(&attributes); err != nil {
+			t.Fatalf("cannot query user: %v", err)
+		}
+		if attributes != `{"name": "mine"}` {
+			t.Errorf("unexpected restored attributes %q", attributes)
+		}
+
+		result, err := l.LoadWithResult()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		if skipped := result.SkippedTables(); len(skipped) != 0 {
+			t.Errorf("restored tables should be reloaded, got %v skipped", skipped)
+		}
+		assertCount(t, db, "users", 2)
+	})
+
+	t.Run("FixtureSet", func(t *testing.T) {
+		dir := t.TempDir()
+		content, err := os.ReadFile("testdata/fixtures/users.yml")
+		if err != nil {
+			t.Fatalf("cannot read fixtures: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "users.yml"), content, 0o600); err != nil {
+			t.Fatalf("cannot write fixtures: %v", err)
+		}
+
+		set, err := testfixtures.NewFixtureSet(
+			testfixtures.Directory(dir),
+			testfixtures.Files("testdata/fixtures/assets.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create FixtureSet: %v", err)
+		}
+		// The fixtures were read by NewFixtureSet.
+		if err := os.Remove(filepath.Join(dir, "users.yml")); err != nil {
+			t.Fatalf("cannot remove fixtures: %v", err)
+		}
+
+		for range 3 {
+			db := openDB(t, "sqlite3", createSQLite(t))
+			loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+			l, err := set.Loader(db, "sqlite3", testfixtures.DangerousSkipTestDatabaseCheck())
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			if err := l.Load(); err != nil {
+				t.Fatalf("cannot load fixtures: %v", err)
+			}
+			assertCount(t, db, "users", 2)
+			assertCount(t, db, "assets", 1)
+		}
+	})
+
+	t.Run("OverrideExcludeExtra", func(t *testing.T) {
+		db := openDB(t, "sqlite3", createSQLite(t))
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		set, err := testfixtures.NewFixtureSet(
+			testfixtures.Files("testdata/fixtures/users.yml", "testdata/fixtures/posts.yml"),
+		)
+		if err != nil {
+			t.Fatalf("failed to create FixtureSet: %v", err)
+		}
+		load := func(options ...func(*testfixtures.Loader) error) {
+			t.Helper()
+			options = append(options, testfixtures.DangerousSkipTestDatabaseCheck(), testfixtures.PersistTableChecksums())
+			l, err := set.Loader(db, "sqlite3", options...)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			if err := l.Load(); err != nil {
+				t.Fatalf("cannot load fixtures: %v", err)
+			}
+		}
+		assertTitles := func(expected ...string) {
+			t.Helper()
+			rows, err := db.Query("SELECT title FROM posts ORDER BY id")
+			if err != nil {
+				t.Fatalf("cannot query posts: %v", err)
+			}
+			defer rows.Close()
+			var titles []string
+			for rows.Next() {
+				var title string
+				if err := rows.Scan(&title); err != nil {
+					t.Fatalf("cannot scan post: %v", err)
+				}
+				titles = append(titles, title)
+			}
+			if !slices.Equal(titles, expected) {
+				t.Errorf("expected posts %q, got %q", expected, titles)
+			}
+		}
+
+		load()
+		if _, err := db.Exec(`
+			CREATE TRIGGER users_no_insert BEFORE INSERT ON users
+			BEGIN
+				SELECT RAISE(ABORT, 'users must not be reloaded');
+			END
+		`); err != nil {
+			t.Fatalf("cannot create trigger: %v", err)
+		}
+
+		// Only the changed table is reloaded.
+		load(
+			testfixtures.Override("posts", "one", map[string]any{"title": "Changed"}),
+			testfixtures.Exclude("posts", "two"),
+			testfixtures.Extra("posts", map[string]any{
+				"id":         3,
+				"title":      "Post 3",
+				"content":    "Post 3 content",
+				"created_at": "2016-01-01 12:30:12",
+				"updated_at": "2016-01-01 12:30:12",
+			}),
+		)
+		assertTitles("Changed", "Post 3")
+
+		// The changes don't leak into other Loaders of the set.
+		load()
+		assertTitles("Post 1", "Post 2")
+	})
+
+	t.Run("Layers", func(t *testing.T) {
+		base, overlay := t.TempDir(), t.TempDir()
+		for _, name := range []string{"users.yml", "posts.yml"} {
+			content, err := os.ReadFile(filepath.Join("testdata/fixtures", name))
+			if err != nil {
+				t.Fatalf("cannot read fixtures: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(base, name), content, 0o600); err != nil {
+				t.Fatalf("cannot write fixtures: %v", err)
+			}
+		}
+		files := map[string]string{
+			"users.yml": "- id: 2\n  _delete: true\n- id: 3\n  attributes: {}\n",
+			"posts.yml": "two:\n  id: 2\n  title: Overlay\n  content: Overlay content\n  created_at: 2016-01-01 12:30:12\n  updated_at: 2016-01-01 12:30:12\n",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(overlay, name), []byte(content), 0o600); err != nil {
+				t.Fatalf("cannot write fixtures: %v", err)
+			}
+		}
+
+		db := openDB(t, "sqlite3", createSQLite(t))
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Layers(base, overlay),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		assertCount(t, db, "users", 2)
+		assertCount(t, db, "posts", 2)
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = 2)").Scan(&exists); err != nil {
+			t.Fatalf("cannot query users: %v", err)
+		}
+		if exists {
+			t.Errorf("user 2 should have been deleted by the overlay")
+		}
+		var title string
+		if err := db.QueryRow("SELECT title FROM posts WHERE id = 2").Scan(&title); err != nil {
+			t.Fatalf("cannot query posts: %v", err)
+		}
+		if title != "Overlay" {
+			t.Errorf("expected the title of the overlay, got %q", title)
+		}
+	})
+
+	t.Run("RecordsWithGeneratedKeys", func(t *testing.T) {
+		dir := t.TempDir()
+		content := "first:\n  id: 10\n  title: First\n  created_at: 2016-01-01 12:30:12\n" +
+			"second:\n  title: Second\n  created_at: 2016-01-01 12:30:12\n" +
+			"third:\n  title: RAW=upper('third')\n  created_at: 2016-01-01 12:30:12\n"
+		if err := os.WriteFile(filepath.Join(dir, "notes.yml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("cannot write fixtures: %v", err)
+		}
+
+		db := openDB(t, "sqlite3", createSQLite(t))
+		if _, err := db.Exec(`
+			CREATE TABLE notes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT
+				,title TEXT NOT NULL
+				,created_at TIMESTAMP NOT NULL
+			)
+		`); err != nil {
+			t.Fatalf("cannot create table: %v", err)
+		}
+
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.CaptureGeneratedKeys(),
+			testfixtures.Directory(dir),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		second, err := l.Record("notes", "second")
+		if err != nil {
+			t.Fatalf("cannot get record: %v", err)
+		}
+		if second["id"] != int64(11) || second["title"] != "Second" {
+			t.Errorf("expected the generated id and the values of the fixture, got %v", second)
+		}
+		if createdAt, ok := second["created_at"].(time.Time); !ok || createdAt.Year() != 2016 {
+			t.Errorf("expected created_at converted to a time, got %v", second["created_at"])
+		}
+
+		records, err := l.Records("notes")
+		if err != nil {
+			t.Fatalf("cannot get records: %v", err)
+		}
+		if len(records) != 3 || records[0]["id"] != uint64(10) || records[2]["id"] != int64(12) {
+			t.Errorf("expected the records in order with their ids, got %v", records)
+		}
+		if _, ok := records[2]["title"]; ok {
+			t.Errorf("values given as raw SQL should be left out, got %v", records[2])
+		}
+
+		if _, err := l.Record("notes", "fourth"); err == nil {
+			t.Errorf("expected an error for an unknown record")
+		}
+	})
+
+	t.Run("TableDeclaredInFiles", func(t *testing.T) {
+		dir := t.TempDir()
+		content, err := os.ReadFile("testdata/fixtures/users.yml")
+		if err != nil {
+			t.Fatalf("cannot read fixtures: %v", err)
+		}
+		files := map[string]string{
+			"users.yml":         string(content),
+			"more_users.yml":    "---\ntable: users\n---\n- id: 3\n  attributes: {}\n",
+			"archived.yml":      "_table: users\narchived:\n  id: 4\n  attributes: {}\n",
+			"accounts_main.yml": "---\ntable: accounts\n---\n- id: 1\n  user_id: 1\n  currency: USD\n  balance: 100\n  created_at: 2024-01-01 00:00:00\n  updated_at: 2024-01-01 00:00:00\n",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+				t.Fatalf("cannot write fixtures: %v", err)
+			}
+		}
+
+		db := openDB(t, "sqlite3", createSQLite(t))
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		var deletes atomic.Int64
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Directory(dir),
+			testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+				if strings.HasPrefix(s.SQL, "DELETE FROM") {
+					deletes.Add(1)
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		result, err := l.LoadWithResult()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		assertCount(t, db, "users", 4)
+		assertCount(t, db, "accounts", 1)
+		if deletes.Load() != 2 {
+			t.Errorf("each table should be cleaned once, got %d deletes", deletes.Load())
+		}
+		var names []string
+		for _, table := range result.Tables {
+			names = append(names, fmt.Sprintf("%s:%d", table.Name, table.RowsInserted))
+		}
+		if expected := []string{"accounts:1", "users:4"}; !slices.Equal(names, expected) {
+			t.Errorf("expected tables %v, got %v", expected, names)
+		}
+	})
+
+	t.Run("ConcurrentLoad", func(t *testing.T) {
+		// Meant to be run with the race detector, see "task test:race".
+		db := openDB(t, "sqlite3", createSQLite(t))
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		var statements atomic.Int64
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.Files(
+				"testdata/fixtures/users.yml",
+				"testdata/fixtures/accounts.yml",
+				"testdata/fixtures/transactions.yml",
+				"testdata/fixtures/assets.yml",
+			),
+			testfixtures.Hook(func(testfixtures.Statement) func(error) {
+				statements.Add(1)
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8*3)
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- l.Load()
+				errs <- l.LoadTables(context.Background(), "users", "accounts")
+				_, err := l.LoadWithResult()
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("cannot load fixtures: %v", err)
+			}
+		}
+
+		if statements.Load() == 0 {
+			t.Error("expected statements to be traced")
+		}
+		assertCount(t, db, "users", 2)
+		assertCount(t, db, "transactions", 4)
+		assertCount(t, db, "assets", 1)
+	})
+
+	t.Run("UseDatabaseLock", func(t *testing.T) {
+		// A file database, as in-memory shared cache databases have table
+		// locks instead of database locks.
+		connStr := "file:" + filepath.Join(t.TempDir(), "testdb.sqlite3")
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+
+		newLoader := func(timeout time.Duration) *testfixtures.Loader {
+			t.Helper()
+			l, err := testfixtures.New(
+				testfixtures.Database(openDB(t, "sqlite3", connStr)),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.DangerousSkipTestDatabaseCheck(),
+				testfixtures.UseDatabaseLock("", timeout),
+				testfixtures.Files("testdata/fixtures/users.yml"),
+			)
+			if err != nil {
+				t.Fatalf("failed to create Loader: %v", err)
+			}
+			return l
+		}
+		holder := newLoader(time.Second)
+		waiter := newLoader(100 * time.Millisecond)
+
+		unlock, err := holder.Lock(context.Background())
+		if err != nil {
+			t.Fatalf("cannot acquire lock: %v", err)
+		}
+		if err := holder.Load(); err != nil {
+			t.Fatalf("cannot load fixtures while holding the lock: %v", err)
+		}
+		// The fixtures are loaded in the transaction of the lock, so other
+		// connections don't see them until it's released.
+		assertCount(t, db, "users", 0)
+		if err := waiter.Load(); err == nil || !strings.Contains(err.Error(), "database lock") {
+			t.Fatalf("expected loading fixtures to time out while the lock is held, got %v", err)
+		}
+		if err := unlock(); err != nil {
+			t.Fatalf("cannot release lock: %v", err)
+		}
+		assertCount(t, db, "users", 2)
+
+		if err := waiter.Load(); err != nil {
+			t.Fatalf("cannot load fixtures after the lock was released: %v", err)
+		}
+		unlock, err = holder.Lock(context.Background())
+		if err != nil {
+			t.Fatalf("cannot acquire lock again: %v", err)
+		}
+		if err := unlock(); err != nil {
+			t.Fatalf("cannot release lock: %v", err)
+		}
+	})
+
+	t.Run("LoadWithBackupHoldsDatabaseLock", func(t *testing.T) {
+		connStr := "file:" + filepath.Join(t.TempDir(), "testdb.sqlite3")
+		db := openDB(t, "sqlite3", connStr)
+		loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
+		writer := openDB(t, "sqlite3", connStr+"?_busy_timeout=0")
+
+		var writeErr error
+		l, err := testfixtures.New(
+			testfixtures.Database(db),
+			testfixtures.Dialect("sqlite3"),
+			testfixtures.DangerousSkipTestDatabaseCheck(),
+			testfixtures.UseDatabaseLock("", time.Second),
+			testfixtures.Files("testdata/fixtures/users.yml"),
+			testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+				if s.Step == testfixtures.StepBackup && writeErr == nil {
+					_, writeErr = writer.Exec("DELETE FROM users")
+					if writeErr == nil {
+						writeErr = errors.New("the tables could be written while being backed up")
+					}
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+
+		backup, err := l.LoadWithBackup()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		if writeErr == nil || !strings.Contains(writeErr.Error(), "locked") {
+			t.Errorf("expected other connections to be locked out during the backup, got %v", writeErr)
+		}
+		if err := backup.Restore(); err != nil {
+			t.Fatalf("cannot restore backup: %v", err)
+		}
+		assertCount(t, db, "users", 0)
+	})
+}
+
+func testSQLite(t *testing.T, additionalOptions ...func(*testfixtures.Loader) error) {
+	t.Helper()
 	connStr := createSQLite(t)
 	db := openDB(t, "sqlite3", connStr)
 	loadSchemaInOneQuery(t, db, "testdata/schema/sqlite.sql")
-	testLoader(t, db, "sqlite3", testfixtures.DangerousSkipTestDatabaseCheck())
+	testLoader(t, db, "sqlite3", additionalOptions...)
+
+	t.Run("SkipUnchangedTables", func(t *testing.T) {
+		options := append(
+			[]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.Files(
+					"testdata/fixtures/users.yml",
+					"testdata/fixtures/accounts.yml",
+					"testdata/fixtures/transactions.yml",
+					"testdata/fixtures/assets.yml",
+				),
+			},
+			additionalOptions...,
+		)
+		l, err := testfixtures.New(options...)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		// Creating a trigger does not change the table data, so the
+		// table must be skipped instead of failing on insert.
+		if _, err := db.Exec(`
+			CREATE TRIGGER assets_no_insert BEFORE INSERT ON assets
+			BEGIN
+				SELECT RAISE(ABORT, 'assets must not be reloaded');
+			END
+		`); err != nil {
+			t.Fatalf("cannot create trigger: %v", err)
+		}
+		t.Cleanup(func() {
+			_, _ = db.Exec("DROP TRIGGER IF EXISTS assets_no_insert")
+		})
+
+		if _, err := db.Exec("DELETE FROM transactions WHERE id = 1"); err != nil {
+			t.Fatalf("cannot delete transaction: %v", err)
+		}
+		if err := l.Load(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+		assertCount(t, db, "transactions", 4)
+		assertCount(t, db, "assets", 1)
+	})
+
+	t.Run("LoadWithResult", func(t *testing.T) {
+		var statements []testfixtures.Statement
+		options := append(
+			[]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.Files(
+					"testdata/fixtures/users.yml",
+					"testdata/fixtures/accounts.yml",
+					"testdata/fixtures/transactions.yml",
+				),
+				testfixtures.Hook(func(s testfixtures.Statement) func(error) {
+					statements = append(statements, s)
+					return nil
+				}),
+			},
+			additionalOptions...,
+		)
+		l, err := testfixtures.New(options...)
+		if err != nil {
+			t.Fatalf("failed to create Loader: %v", err)
+		}
+		if _, err := l.LoadWithResult(); err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		if _, err := db.Exec("DELETE FROM transactions WHERE id = 1"); err != nil {
+			t.Fatalf("cannot delete transaction: %v", err)
+		}
+		statements = nil
+		result, err := l.LoadWithResult()
+		if err != nil {
+			t.Fatalf("cannot load fixtures: %v", err)
+		}
+
+		expected := []testfixtures.TableResult{
+			{Name: "users", Skipped: true},
+			{Name: "accounts", Skipped: true},
+			{Name: "transactions", RowsDeleted: 3, RowsInserted: 4},
+		}
+		if !slices.Equal(result.Tables, expected) {
+			t.Errorf("expected tables %+v, got %+v", expected, result.Tables)
+		}
+		if result.TotalDuration <= 0 || result.InsertDuration <= 0 {
+			t.Errorf("expected durations to be measured, got %+v", result)
+		}
+
+		steps := make(map[testfixtures.LoadStep]int)
+		for _, s := range statements {
+			steps[s.Step]++
+		}
+		if steps[testfixtures.StepCleanup] != 1 || steps[testfixtures.StepInsert] != 4 || steps[testfixtures.StepChecksum] == 0 {
+			t.Errorf("unexpected statements by step: %v", steps)
+		}
+	})
+}
+
+func BenchmarkSQLite(b *testing.B) {
+	db := openDB(b, "sqlite3", createSQLite(b))
+	loadSchemaInOneQuery(b, db, "testdata/schema/sqlite.sql")
+
+	var fixture strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&fixture, "- id: %d\n  attributes: '{}'\n", i+1)
+	}
+	usersFile := filepath.Join(b.TempDir(), "users.yml")
+	if err := os.WriteFile(usersFile, []byte(fixture.String()), 0o644); err != nil {
+		b.Fatalf("cannot write fixture file: %v", err)
+	}
+
+	benchmark := func(b *testing.B, additionalOptions ...func(*testfixtures.Loader) error) {
+		options := append(
+			[]func(*testfixtures.Loader) error{
+				testfixtures.Database(db),
+				testfixtures.Dialect("sqlite3"),
+				testfixtures.DangerousSkipTestDatabaseCheck(),
+				testfixtures.SkipTableChecksumComputation(),
+				testfixtures.Files(usersFile),
+			},
+			additionalOptions...,
+		)
+		l, err := testfixtures.New(options...)
+		if err != nil {
+			b.Fatalf("failed to create Loader: %v", err)
+		}
+		for b.Loop() {
+			if err := l.Load(); err != nil {
+				b.Fatalf("cannot load fixtures: %v", err)
+			}
+		}
+	}
+
+	b.Run("PreparedStatements", func(b *testing.B) {
+		benchmark(b)
+	})
+	b.Run("SkipPreparedStatements", func(b *testing.B) {
+		benchmark(b, testfixtures.SkipPreparedStatements())
+	})
 }
@@ -8,13 +8,14 @@ import (
 	"path/filepath"
 	"unicode/utf8"
 
+	"github.com/go-testfixtures/testfixtures/v3/shared"
 	"github.com/goccy/go-yaml"
 )
 
 // Dumper is resposible for dumping fixtures from the database into a
 // directory.
 type Dumper struct {
-	db     *sql.DB
+	db     shared.Querier
 	helper helper
 	dir    string
 
@@ -38,6 +39,15 @@ func NewDumper(options ...func(*Dumper) error) (*Dumper, error) {
 
 // DumpDatabase sets the database to be dumped.
 func DumpDatabase(db *sql.DB) func(*Dumper) error {
+	return func(d *Dumper) error {
+		d.db = shared.SQL(db)
+		return nil
+	}
+}
+
+// DumpConnection sets the database to be dumped when it's not a sql.DB, like
+// a pgx connection or pool given by the pgxfixtures package.
+func DumpConnection(db shared.Querier) func(*Dumper) error {
 	return func(d *Dumper) error {
 		d.db = db
 		return nil
@@ -81,11 +91,15 @@ func DumpTables(tables ...string) func(*Dumper) error {
 func (d *Dumper) Dump() error {
 	tables := d.tables
 	if len(tables) == 0 {
-		var err error
-		tables, err = d.helper.tableNames(d.db)
+		allTables, err := d.helper.tableNames(d.db)
 		if err != nil {
 			return err
 		}
+		for _, table := range allTables {
+			if !isInternalTable(table) {
+				tables = append(tables, table)
+			}
+		}
 	}
 
 	for _, table := range tables {
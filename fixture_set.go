@@ -0,0 +1,152 @@
+package testfixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// FixtureSet holds fixtures read and parsed once, which can be loaded into
+// any number of databases, like when each test creates its own database:
+//
+//	set, err := testfixtures.NewFixtureSet(
+//	        testfixtures.Directory("testdata/fixtures"),
+//	)
+//	if err != nil {
+//	        ...
+//	}
+//
+//	func TestSomething(t *testing.T) {
+//	        db := createTestDatabase(t)
+//	        fixtures, err := set.Loader(db, "postgres")
+//	        ...
+//	}
+//
+// What Loader reads from the database when it's created, like its tables and
+// constraints, is also cached by a fingerprint of the schema, so databases
+// created from the same schema share it. Only PostgreSQL, which reads the
+// most, caches it.
+//
+// A FixtureSet is safe for concurrent use.
+type FixtureSet struct {
+	options  []func(*Loader) error
+	files    []*fixtureFile
+	catalogs *catalogCache
+}
+
+// NewFixtureSet reads and parses the fixtures given by options like
+// Directory, Files, Paths and FilesMultiTables, applying the FS and template
+// options.
+//
+// Other options not depending on the database, like Location or Hook, apply
+// to every Loader of the set. The ones depending on the database must be
+// given to FixtureSet.Loader instead.
+func NewFixtureSet(options ...func(*Loader) error) (*FixtureSet, error) {
+	l := newLoader()
+	for _, option := range options {
+		if err := option(l); err != nil {
+			return nil, err
+		}
+	}
+	if l.db != nil || l.database != nil || l.helper != nil {
+		return nil, fmt.Errorf("testfixtures: the database and dialect of a FixtureSet must be given to FixtureSet.Loader")
+	}
+
+	if err := l.loadPendingSources(); err != nil {
+		return nil, err
+	}
+	for _, file := range l.fixturesFiles {
+		records, err := file.parseRecords()
+		if err != nil {
+			return nil, err
+		}
+		file.records = records
+	}
+
+	return &FixtureSet{
+		options:  options,
+		files:    l.fixturesFiles,
+		catalogs: &catalogCache{catalogs: make(map[string]any)},
+	}, nil
+}
+
+// Loader returns a Loader of the fixtures of the set into db. Options are
+// applied to this Loader only, after the ones of the set. Fixtures given by
+// options are loaded after the ones of the set.
+func (s *FixtureSet) Loader(db *sql.DB, dialect string, options ...func(*Loader) error) (*Loader, error) {
+	all := make([]func(*Loader) error, 0, len(s.options)+len(options)+3)
+	all = append(all, Database(db), Dialect(dialect))
+	all = append(all, s.options...)
+	all = append(all, s.bind)
+	all = append(all, options...)
+	return New(all...)
+}
+
+// bind replaces the fixture sources of the set, which were already read, by
+// copies of its parsed fixture files.
+func (s *FixtureSet) bind(l *Loader) error {
+	if err := l.checkPendingSources(); err != nil {
+		return err
+	}
+	l.pendingSources = nil
+
+	l.fixturesFiles = make([]*fixtureFile, 0, len(s.files))
+	for _, file := range s.files {
+		file := *file
+		l.fixturesFiles = append(l.fixturesFiles, &file)
+	}
+	l.catalogs = s.catalogs
+	return nil
+}
+
+// catalogCache holds what helpers read from databases in init, by schema
+// fingerprint.
+type catalogCache struct {
+	mu       sync.Mutex
+	catalogs map[string]any
+}
+
+func (c *catalogCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	catalog, ok := c.catalogs[key]
+	return catalog, ok
+}
+
+func (c *catalogCache) put(key string, catalog any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catalogs[key] = catalog
+}
+
+// initHelper reads the metadata of the database. Loaders created by a
+// FixtureSet read it once per schema fingerprint.
+func (l *Loader) initHelper() error {
+	if l.catalogs == nil {
+		return l.helper.init(l.conn)
+	}
+
+	fingerprint, err := l.helper.schemaFingerprint(l.conn)
+	if err != nil {
+		return fmt.Errorf("testfixtures: could not compute the schema fingerprint: %w", err)
+	}
+	if fingerprint == "" {
+		return l.helper.init(l.conn)
+	}
+
+	// The driver is part of the key, as what's read may depend on it.
+	var driver any = l.database
+	if l.db != nil {
+		driver = l.db.Driver()
+	}
+	key := fmt.Sprintf("%T:%T:%s", l.helper, driver, fingerprint)
+	if catalog, ok := l.catalogs.get(key); ok {
+		l.helper.setCatalog(catalog)
+		return nil
+	}
+	if err := l.helper.init(l.conn); err != nil {
+		return err
+	}
+	l.catalogs.put(key, l.helper.catalog())
+	return nil
+}
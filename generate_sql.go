@@ -0,0 +1,79 @@
+package testfixtures
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GenerateSQL writes to w a SQL script equivalent to what Loader.Load does
+// on a clean run, without touching the database: the statements that disable
+// referential integrity, the cleanup of the fixture tables, the inserts and
+// the sequences reset.
+//
+// Values are inlined as SQL literals instead of being passed as params, so
+// the script can be run by tools like psql, mysql or sqlite3, or be used as
+// an init script of a database Docker image.
+//
+//	f, err := os.Create("fixtures.sql")
+//	...
+//	if err := fixtures.GenerateSQL(f); err != nil {
+//	        ...
+//	}
+func (l *Loader) GenerateSQL(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var statements []string
+
+	before, after := l.helper.disableReferentialIntegrityScript()
+	statements = append(statements, before...)
+
+	tables := groupByTable(l.fixturesFiles)
+	if !l.skipCleanup {
+		for _, table := range tables {
+			statements = append(statements, l.helper.cleanTableQuery(l.helper.quoteKeyword(table.name)))
+		}
+	}
+
+	for _, table := range tables {
+		beforeInsert, afterInsert := l.helper.whileInsertOnTableScript(table.name)
+		statements = append(statements, beforeInsert...)
+		for _, file := range table.files {
+			for _, i := range file.insertSQLs {
+				insert, err := l.buildInsertSQLWithLiterals(table.name, i)
+				if err != nil {
+					return fmt.Errorf("testfixtures: could not generate SQL for file %s: %w", file.fileName, err)
+				}
+				statements = append(statements, insert)
+			}
+		}
+		statements = append(statements, afterInsert...)
+	}
+
+	statements = append(statements, after...)
+
+	for _, s := range statements {
+		if _, err := fmt.Fprintf(w, "%s;\n", strings.TrimSuffix(s, ";")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Loader) buildInsertSQLWithLiterals(tableName string, i insertSQL) (string, error) {
+	values := make([]string, 0, len(i.values))
+	for _, value := range i.values {
+		if raw, ok := value.(rawSQL); ok {
+			values = append(values, string(raw))
+			continue
+		}
+		literal, err := l.helper.sqlLiteral(value)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, literal)
+	}
+
+	return l.buildInsertStatement(tableName, i.columns, values)
+}
@@ -0,0 +1,194 @@
+package testfixtures
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// fixturesFromDirRecursive reads the YAML files of dir and its
+// subdirectories, in lexical order of their paths. Without
+// RecursiveDirectories, only the schema directories of dir are read.
+func (l *Loader) fixturesFromDirRecursive(dir string) ([]*fixtureFile, error) {
+	var paths []string
+	err := fs.WalkDir(l.fs, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if !l.recursiveDirectories && strings.Contains(relativePath(dir, p), "/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ext := path.Ext(p); ext == ".yml" || ext == ".yaml" {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(`testfixtures: could not read directory "%s": %w`, dir, err)
+	}
+
+	slices.Sort(paths)
+	files, err := l.fixturesFromFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	if l.schemaDirectories {
+		for _, file := range files {
+			schema, _, ok := strings.Cut(relativePath(dir, file.path), "/")
+			if ok && !file.tableDeclared {
+				file.table = schema + "." + file.fileNameWithoutExtension()
+			}
+		}
+	}
+	if err := checkSameTableFiles(files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// relativePath returns the path of p in dir, or "." for dir itself.
+func relativePath(dir, p string) string {
+	dir = path.Clean(dir)
+	if dir == "." {
+		return p
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+// fixturesFromGlob reads the files matching the patterns, in the order of
+// the patterns and in lexical order of their paths for each one.
+func (l *Loader) fixturesFromGlob(patterns ...string) ([]*fixtureFile, error) {
+	var (
+		paths []string
+		seen  = make(map[string]bool)
+	)
+	for _, pattern := range patterns {
+		matches, err := l.glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf(`testfixtures: no files match the pattern "%s"`, pattern)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	files, err := l.fixturesFromFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSameTableFiles(files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// glob returns the files matching pattern, sorted. The directories of the
+// pattern without meta characters are the root of the search.
+func (l *Loader) glob(pattern string) ([]string, error) {
+	segments := strings.Split(pattern, "/")
+	n := 0
+	for n < len(segments)-1 && !hasGlobMeta(segments[n]) {
+		n++
+	}
+	root := strings.Join(segments[:n], "/")
+	switch {
+	case root == "" && n > 0:
+		root = "/"
+	case root == "":
+		root = "."
+	}
+
+	var matches []string
+	err := fs.WalkDir(l.fs, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if matchGlob(segments[n:], strings.Split(relativePath(root, p), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(`testfixtures: could not read directory "%s": %w`, root, err)
+	}
+
+	slices.Sort(matches)
+	return matches, nil
+}
+
+// matchGlob returns whether the elements of a path match the ones of a
+// pattern, where "**" matches any number of elements.
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf(`testfixtures: invalid pattern "%s": %w`, pattern, err)
+		}
+	}
+	return nil
+}
+
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// checkSameTableFiles returns an error if two of the files are fixtures of
+// the same table because they have the same name. Files can only share a
+// table they declare.
+func checkSameTableFiles(files []*fixtureFile) error {
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if file.tableDeclared {
+			continue
+		}
+		table := file.tableName()
+		if other, ok := paths[table]; ok {
+			return fmt.Errorf(`testfixtures: files "%s" and "%s" are both fixtures of table "%s", declare the table in the files to load them into the same table`, other, file.path, table)
+		}
+		paths[table] = file.path
+	}
+	return nil
+}
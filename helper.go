@@ -2,9 +2,14 @@ package testfixtures
 
 import (
 	"cmp"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
@@ -30,22 +35,182 @@ const (
 	ParamTypeAtSign   ParamType = "@"
 )
 
-type loadFunction func(tx *sql.Tx) error
+// placeholder returns the placeholder for the i-th param of a query,
+// starting at 1.
+func (p ParamType) placeholder(i int) string {
+	switch p {
+	case ParamTypeDollar:
+		return fmt.Sprintf("$%d", i)
+	case ParamTypeAtSign:
+		return fmt.Sprintf("@p%d", i)
+	default:
+		return "?"
+	}
+}
+
+type loadFunction func(tx shared.Querier) error
+
+// database is what helpers use to run statements. It's implemented by
+// *sql.DB, through sqlDatabase, by the databases given with Connection, and
+// allows statements to be traced.
+type database = shared.Database
+
+type transaction = shared.Transaction
+
+// statement is a prepared statement. It's implemented by *sql.Stmt.
+type statement = shared.Statement
+
+type sqlDatabase struct {
+	shared.Querier
+	db  *sql.DB
+	ctx context.Context
+}
+
+func newSQLDatabase(db *sql.DB) sqlDatabase {
+	return sqlDatabase{shared.SQL(db), db, context.Background()}
+}
+
+func (db sqlDatabase) Begin() (transaction, error) {
+	tx, err := db.db.BeginTx(db.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTransaction{shared.SQLContext(db.ctx, tx), tx, db.ctx}, nil
+}
+
+func (db sqlDatabase) Conn(ctx context.Context) (shared.Conn, error) {
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sqlConn{shared.SQL(conn), conn}, nil
+}
+
+func (db sqlDatabase) WithContext(ctx context.Context) database {
+	return sqlDatabase{shared.SQLContext(ctx, db.db), db.db, ctx}
+}
+
+type sqlTransaction struct {
+	shared.Querier
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+func (tx sqlTransaction) Prepare(query string) (statement, error) {
+	stmt, err := tx.tx.PrepareContext(tx.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return sqlStatement{stmt, tx.ctx}, nil
+}
+
+func (tx sqlTransaction) Commit() error {
+	return tx.tx.Commit()
+}
+
+func (tx sqlTransaction) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+type sqlStatement struct {
+	*sql.Stmt
+	ctx context.Context
+}
+
+func (s sqlStatement) Exec(args ...any) (sql.Result, error) {
+	return s.ExecContext(s.ctx, args...)
+}
+
+type sqlConn struct {
+	shared.Querier
+	conn *sql.Conn
+}
+
+func (c sqlConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c sqlConn) WithContext(ctx context.Context) shared.Conn {
+	return sqlConn{shared.SQLContext(ctx, c.conn), c.conn}
+}
+
+// queryStatement runs a query each time it's executed, for connections
+// without prepared statements.
+type queryStatement struct {
+	q     shared.Querier
+	query string
+}
+
+func (s queryStatement) Exec(args ...any) (sql.Result, error) {
+	return s.q.Exec(s.query, args...)
+}
+
+func (queryStatement) Close() error {
+	return nil
+}
 
 type helper interface {
-	init(*sql.DB) error
-	disableReferentialIntegrity(*sql.DB, loadFunction) error
+	init(shared.Querier) error
+	// schemaFingerprint, catalog and setCatalog are used to cache what init
+	// reads from the database, see FixtureSet. schemaFingerprint returns an
+	// empty string when it's not cached.
+	schemaFingerprint(shared.Querier) (string, error)
+	catalog() any
+	setCatalog(any)
+	disableReferentialIntegrity(database, loadFunction) error
+	// restoreReferentialIntegrityStatements returns the statements restoring
+	// referential integrity when it's disabled outside of a transaction, to
+	// be journaled, see Repair.
+	restoreReferentialIntegrityStatements() []string
+	resetSequences(database) error
+	// restoreSequences resets the sequences of the given tables after the
+	// values of their rows, see Backup.Restore. MySQL never sets an
+	// AUTO_INCREMENT below the greatest value of its column, so
+	// resetSequences is enough there.
+	restoreSequences(db database, tables []string) error
 	paramType() ParamType
 	getDefaultParamType() ParamType
 	setCustomParamType(ParamType)
-	databaseName(shared.Queryable) (string, error)
-	tableNames(shared.Queryable) ([]string, error)
-	isTableModified(shared.Queryable, string) (bool, error)
-	computeTablesChecksum(shared.Queryable) error
+	databaseName(shared.Querier) (string, error)
+	// databaseHost returns the host of the database server, or an empty
+	// string when connected through a Unix socket or a file.
+	databaseHost(shared.Querier) (string, error)
+	tableNames(shared.Querier) ([]string, error)
+	isTableModified(shared.Querier, string) (bool, error)
+	// computeTablesChecksum stores the checksums of the given tables, just
+	// loaded, so later changes to them are detected. Tables without a
+	// checksum are considered modified.
+	computeTablesChecksum(q shared.Querier, tables []string) error
+	getChecksum(shared.Querier, string) (string, error)
 	quoteKeyword(string) string
-	whileInsertOnTable(*sql.Tx, string, func() error) error
+	whileInsertOnTable(shared.Querier, string, func() error) error
 	cleanTableQuery(string) string
-	buildInsertSQL(q shared.Queryable, tableName string, columns, values []string) (string, error)
+	createTableIfNotExistsQuery(tableName, definition string) string
+	buildInsertSQL(q shared.Querier, tableName string, columns, values []string) (string, error)
+
+	// Used to upsert records, see UseUpsert.
+	primaryKey(q shared.Querier, tableName string) ([]string, error)
+	buildUpsertSQL(q shared.Querier, tableName string, primaryKey, columns, values []string) (string, error)
+
+	// Used to read the keys generated by the database, see
+	// CaptureGeneratedKeys.
+	buildReturningInsertSQL(q shared.Querier, tableName string, columns, values, returning []string) (string, error)
+
+	// Used to lock the database, see UseDatabaseLock.
+	lock(ctx context.Context, conn shared.Conn, key string, timeout time.Duration) error
+	unlock(conn shared.Conn, key string) error
+
+	// Used to check referential integrity, see VerifyReferentialIntegrity.
+	foreignKeys(q shared.Querier, tableName string) ([]foreignKey, error)
+
+	// Used to generate SQL scripts, see Loader.GenerateSQL.
+	disableReferentialIntegrityScript() (before, after []string)
+	whileInsertOnTableScript(tableName string) (before, after []string)
+	sqlLiteral(any) (string, error)
+
+	// classifyInsertError returns the cause of an insert error and, when
+	// known, the offending column.
+	classifyInsertError(error) (cause InsertErrorCause, column string)
 }
 
 var (
@@ -57,6 +222,24 @@ var (
 	_ helper = &sqlserver{}
 )
 
+// internalTables are used by testfixtures itself for bookkeeping, and
+// therefore must not be tracked or dumped.
+var internalTables = []string{
+	changeTrackingTable,
+	checksumsTable,
+	journalTable,
+	seedsTable,
+}
+
+func isInternalTable(tableName string) bool {
+	for _, t := range internalTables {
+		if tableName == t || strings.HasSuffix(tableName, "."+t) {
+			return true
+		}
+	}
+	return false
+}
+
 type baseHelper struct {
 	customParamType ParamType
 }
@@ -74,7 +257,25 @@ func (b *baseHelper) getDefaultParamType() ParamType {
 }
 
 // shared methods
-func (baseHelper) init(_ *sql.DB) error {
+func (baseHelper) init(_ shared.Querier) error {
+	return nil
+}
+
+func (baseHelper) schemaFingerprint(_ shared.Querier) (string, error) {
+	return "", nil
+}
+
+func (baseHelper) catalog() any {
+	return nil
+}
+
+func (baseHelper) setCatalog(_ any) {}
+
+func (baseHelper) resetSequences(_ database) error {
+	return nil
+}
+
+func (baseHelper) restoreSequences(_ database, _ []string) error {
 	return nil
 }
 
@@ -82,15 +283,19 @@ func (baseHelper) quoteKeyword(str string) string {
 	return fmt.Sprintf(`"%s"`, str)
 }
 
-func (baseHelper) whileInsertOnTable(_ *sql.Tx, _ string, fn func() error) error {
+func (baseHelper) whileInsertOnTable(_ shared.Querier, _ string, fn func() error) error {
 	return fn()
 }
 
-func (baseHelper) isTableModified(_ shared.Queryable, _ string) (bool, error) {
+func (baseHelper) databaseHost(_ shared.Querier) (string, error) {
+	return "", fmt.Errorf("testfixtures: reading the database host is not supported by this database")
+}
+
+func (baseHelper) isTableModified(_ shared.Querier, _ string) (bool, error) {
 	return true, nil
 }
 
-func (baseHelper) computeTablesChecksum(_ shared.Queryable) error {
+func (baseHelper) computeTablesChecksum(_ shared.Querier, _ []string) error {
 	return nil
 }
 
@@ -98,7 +303,102 @@ func (baseHelper) cleanTableQuery(tableName string) string {
 	return fmt.Sprintf("DELETE FROM %s", tableName)
 }
 
-func (h baseHelper) buildInsertSQL(_ shared.Queryable, tableName string, columns, values []string) (string, error) {
+func (baseHelper) createTableIfNotExistsQuery(tableName, definition string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", tableName, definition)
+}
+
+func (baseHelper) classifyInsertError(_ error) (InsertErrorCause, string) {
+	return CauseUnknown, ""
+}
+
+func (baseHelper) restoreReferentialIntegrityStatements() []string {
+	return nil
+}
+
+func (baseHelper) disableReferentialIntegrityScript() (before, after []string) {
+	return nil, nil
+}
+
+func (baseHelper) whileInsertOnTableScript(_ string) (before, after []string) {
+	return nil, nil
+}
+
+// sqlLiteral converts a fixture value to a SQL literal, so it can be inlined
+// in a SQL script instead of being passed as a param.
+func (baseHelper) sqlLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		return fmt.Sprintf("X'%x'", v), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return quoteString(v.Format("2006-01-02 15:04:05.999999999-07:00")), nil
+	default:
+		return "", fmt.Errorf("testfixtures: could not convert value of type %T to a SQL literal", value)
+	}
+}
+
+// restoreError is an error restoring referential integrity after fixtures
+// were loaded, in which case the journal is kept so the database can still be
+// repaired.
+type restoreError struct {
+	err error
+}
+
+func (e *restoreError) Error() string {
+	return e.err.Error()
+}
+
+func (e *restoreError) Unwrap() error {
+	return e.err
+}
+
+// withRestoreError adds restoreErr, an error restoring referential integrity,
+// to err, the error of the load if any.
+func withRestoreError(err, restoreErr error) error {
+	if err == nil {
+		return &restoreError{restoreErr}
+	}
+	return errors.Join(err, &restoreError{restoreErr})
+}
+
+// joinStatements joins the given statements, so they can be sent in a single
+// query.
+func joinStatements(statements []string) string {
+	var b strings.Builder
+	for _, s := range statements {
+		b.WriteString(s)
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// quoteString quotes a string as a standard SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteStringWithBackslashes quotes a string for databases where backslashes
+// are escape characters in string literals.
+func quoteStringWithBackslashes(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+	return "'" + r.Replace(s) + "'"
+}
+
+func (h baseHelper) buildInsertSQL(_ shared.Querier, tableName string, columns, values []string) (string, error) {
 	return fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES (%s)",
 		tableName,
@@ -106,3 +406,183 @@ func (h baseHelper) buildInsertSQL(_ shared.Queryable, tableName string, columns
 		strings.Join(values, ", "),
 	), nil
 }
+
+func (baseHelper) primaryKey(_ shared.Querier, _ string) ([]string, error) {
+	return nil, nil
+}
+
+func (baseHelper) buildUpsertSQL(_ shared.Querier, _ string, _, _, _ []string) (string, error) {
+	return "", fmt.Errorf("testfixtures: upsert is not supported by this database")
+}
+
+func (baseHelper) buildReturningInsertSQL(_ shared.Querier, _ string, _, _, _ []string) (string, error) {
+	return "", fmt.Errorf("testfixtures: generated keys are not supported by this database")
+}
+
+// returningClause returns the RETURNING clause of an insert statement
+// returning the given columns.
+func returningClause(quote func(string) string, returning []string) string {
+	columns := make([]string, 0, len(returning))
+	for _, column := range returning {
+		columns = append(columns, quote(column))
+	}
+	return " RETURNING " + strings.Join(columns, ", ")
+}
+
+func (baseHelper) lock(_ context.Context, _ shared.Conn, _ string, _ time.Duration) error {
+	return fmt.Errorf("testfixtures: locking is not supported by this database")
+}
+
+func (baseHelper) unlock(_ shared.Conn, _ string) error {
+	return nil
+}
+
+func (baseHelper) foreignKeys(_ shared.Querier, _ string) ([]foreignKey, error) {
+	return nil, nil
+}
+
+// queryForeignKeys returns the foreign keys from the rows returned by the
+// query, which are the name of the foreign key, a column, the referenced
+// table and the referenced column, ordered by foreign key and position.
+func queryForeignKeys(q shared.Querier, query string, args ...any) ([]foreignKey, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var foreignKeys []foreignKey
+	for rows.Next() {
+		var (
+			name, column, referencedTable string
+			referencedColumn              sql.NullString
+		)
+		if err := rows.Scan(&name, &column, &referencedTable, &referencedColumn); err != nil {
+			return nil, err
+		}
+		if n := len(foreignKeys); n == 0 || foreignKeys[n-1].name != name {
+			foreignKeys = append(foreignKeys, foreignKey{name: name, referencedTable: referencedTable})
+		}
+		fk := &foreignKeys[len(foreignKeys)-1]
+		fk.columns = append(fk.columns, column)
+		fk.referencedColumns = append(fk.referencedColumns, referencedColumn.String)
+	}
+	return foreignKeys, rows.Err()
+}
+
+// queryStrings returns the first column of the rows returned by the query.
+func queryStrings(q shared.Querier, query string, args ...any) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// checksumFunc computes a checksum of all the data of the given table.
+type checksumFunc func(q shared.Querier, tableName string) (string, error)
+
+// isChecksumModified checks if the current checksum of the table differs from
+// the one previously computed. Tables without a known checksum are always
+// considered modified.
+func isChecksumModified(q shared.Querier, checksums map[string]string, tableName string, fn checksumFunc) (bool, error) {
+	oldChecksum, found := checksums[tableName]
+	if !found {
+		return true, nil
+	}
+
+	checksum, err := fn(q, tableName)
+	if err != nil {
+		return true, err
+	}
+	return checksum != oldChecksum, nil
+}
+
+// computeChecksums computes the checksums of the given tables into checksums,
+// keeping the ones of the other tables.
+func computeChecksums(q shared.Querier, checksums map[string]string, tables []string, fn checksumFunc) (map[string]string, error) {
+	if checksums == nil {
+		checksums = make(map[string]string, len(tables))
+	}
+	for _, t := range tables {
+		checksum, err := fn(q, t)
+		if err != nil {
+			return nil, err
+		}
+		checksums[t] = checksum
+	}
+	return checksums, nil
+}
+
+// rowsChecksum computes a checksum of the rows returned by the given query.
+// It's used for databases that don't provide a way to compute a table
+// checksum on the server. The result doesn't depend on the order of the rows.
+func rowsChecksum(q shared.Querier, query string) (string, error) {
+	rows, err := q.Query(query)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		count, sum, xor uint64
+		values          = make([]any, len(columns))
+		valuePtrs       = make([]any, len(columns))
+	)
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(valuePtrs...); err != nil {
+			return "", err
+		}
+
+		h := fnv.New64a()
+		for _, v := range values {
+			_, _ = fmt.Fprintf(h, "%v\x1f", v)
+		}
+		count++
+		sum += h.Sum64()
+		xor ^= h.Sum64()
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%x:%x", count, sum, xor), nil
+}
+
+// internalTableExists returns whether the given internal table was created,
+// in any schema.
+func internalTableExists(q shared.Querier, h helper, table string) (bool, error) {
+	tables, err := h.tableNames(q)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tables {
+		if t == table || strings.HasSuffix(t, "."+table) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,108 @@
+package testfixtures
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// InsertErrorCause is the kind of database error that made an insert fail.
+type InsertErrorCause string
+
+const (
+	// CauseUnknown is used when the database error could not be classified.
+	CauseUnknown InsertErrorCause = ""
+	// CauseUniqueViolation is a duplicated primary key or unique value.
+	CauseUniqueViolation InsertErrorCause = "unique violation"
+	// CauseForeignKeyViolation is a reference to a record that does not
+	// exist.
+	CauseForeignKeyViolation InsertErrorCause = "foreign key violation"
+	// CauseNotNullViolation is a missing value for a column that does not
+	// allow nulls.
+	CauseNotNullViolation InsertErrorCause = "not-null violation"
+	// CauseTypeMismatch is a value that could not be converted to the
+	// column type.
+	CauseTypeMismatch InsertErrorCause = "type mismatch"
+	// CauseUnknownColumn is a column that does not exist in the table.
+	CauseUnknownColumn InsertErrorCause = "unknown column"
+)
+
+// InsertError will be returned if any error happens on database while
+// inserting the record. The database error can be retrieved with errors.As.
+type InsertError struct {
+	Err error
+	// File is the name of the fixture file, and Path its path.
+	File string
+	Path string
+	// Table is the table the record was inserted into.
+	Table string
+	// Index is the position of the record in the fixture file, starting at 0.
+	Index int
+	// Label is the key of the record, for fixtures given as a map.
+	Label string
+	// Line and Column are the position in the YAML file of the offending
+	// column when known, or of the record otherwise.
+	Line   int
+	Column int
+	// Cause is the classified database error.
+	Cause InsertErrorCause
+	// ColumnName is the offending column, when it can be determined from
+	// the database error.
+	ColumnName string
+	SQL        string
+	Params     []any
+}
+
+func (e *InsertError) Error() string {
+	var b strings.Builder
+	b.WriteString("testfixtures: error inserting record")
+	if e.Label != "" {
+		fmt.Fprintf(&b, " %q", e.Label)
+	}
+	fmt.Fprintf(&b, " (index %d) into table %q", e.Index, e.Table)
+	if e.Cause != CauseUnknown {
+		fmt.Fprintf(&b, ": %s", e.Cause)
+	}
+	if e.ColumnName != "" {
+		fmt.Fprintf(&b, " on column %q", e.ColumnName)
+	}
+	fmt.Fprintf(&b, "\n\tat: %s", e.Path)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d:%d", e.Line, e.Column)
+	}
+	fmt.Fprintf(&b, "\n\terror: %v", e.Err)
+	fmt.Fprintf(&b, "\n\tsql: %s", e.SQL)
+	fmt.Fprintf(&b, "\n\tparams: %v", e.Params)
+	return b.String()
+}
+
+func (e *InsertError) Unwrap() error {
+	return e.Err
+}
+
+func (l *Loader) newInsertError(err error, file *fixtureFile, insert insertSQL) *InsertError {
+	cause, column := l.helper.classifyInsertError(err)
+	position := insert.record.columnPosition(column)
+	return &InsertError{
+		Err:        err,
+		File:       file.fileName,
+		Path:       file.path,
+		Table:      file.tableName(),
+		Index:      insert.record.index,
+		Label:      insert.record.label,
+		Line:       position.line,
+		Column:     position.column,
+		Cause:      cause,
+		ColumnName: column,
+		SQL:        insert.sql,
+		Params:     insert.params,
+	}
+}
+
+// submatch returns the first group matched by re in s, if any.
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
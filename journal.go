@@ -0,0 +1,191 @@
+package testfixtures
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// journalTable is where the statements restoring the referential integrity
+// of the database are written while it's disabled, so it can be restored if
+// the process dies while loading fixtures. Each load writes its statements
+// under its own id, so loads of other processes are left alone.
+const journalTable = "testfixtures_journal"
+
+// Repair restores the referential integrity of the database if a process
+// died while loading fixtures, leaving foreign keys dropped or not checked.
+// It runs the statements that were journaled in the "testfixtures_journal"
+// table before referential integrity was disabled.
+//
+// Load only repairs the database automatically with UseDatabaseLock, once it
+// holds the lock, as no other process can be loading fixtures then. Without
+// it, the journal could be from a load still running in another process, so
+// it's left alone and the database must be repaired with Repair, which must
+// not be called while fixtures are being loaded into the same database. The
+// journal is also kept when referential integrity could not be restored after
+// a load, for instance because of rows referencing missing rows, so Repair can
+// be run once the data is fixed.
+//
+// Only valid for PostgreSQL, SQL Server and Spanner, the databases where
+// referential integrity is disabled outside of a transaction. Returns the
+// statements that were run.
+func Repair(db *sql.DB, dialect string) ([]string, error) {
+	h, err := helperForDialect(dialect)
+	if err != nil {
+		return nil, err
+	}
+	switch h.(type) {
+	case *postgreSQL, *sqlserver, *spanner:
+	default:
+		return nil, fmt.Errorf("testfixtures: Repair is only valid for PostgreSQL, SQL Server and Spanner databases")
+	}
+	return repairJournal(newSQLDatabase(db), h)
+}
+
+// usesJournal returns whether the Loader disables referential integrity
+// outside of a transaction, in which case how to restore it is journaled.
+// Disabling triggers on PostgreSQL is transactional, so it's rolled back if
+// the process dies.
+func (l *Loader) usesJournal() bool {
+	switch h := l.helper.(type) {
+	case *postgreSQL:
+		return h.useDropConstraint || h.useAlterConstraint
+	case *sqlserver, *spanner:
+		return true
+	}
+	return false
+}
+
+// disableReferentialIntegrity runs loadFn with referential integrity
+// disabled, journaling how to restore it until it's restored.
+func (l *Loader) disableReferentialIntegrity(loadFn loadFunction) (err error) {
+	if !l.usesJournal() {
+		return l.helper.disableReferentialIntegrity(l.conn, loadFn)
+	}
+
+	loadID, err := newLoadID()
+	if err != nil {
+		return err
+	}
+	if err := writeJournal(l.conn, l.helper, loadID, l.helper.restoreReferentialIntegrityStatements()); err != nil {
+		return err
+	}
+	defer func() {
+		// Referential integrity is restored by the helper even when loading
+		// fails, so the journal is only left behind if restoring it failed
+		// or the process died.
+		var restoreErr *restoreError
+		if errors.As(err, &restoreErr) {
+			return
+		}
+		if _, err2 := l.conn.Exec(deleteJournalQuery(l.helper), loadID); err2 != nil && err == nil {
+			err = fmt.Errorf("testfixtures: could not clear journal: %w", err2)
+		}
+	}()
+	return l.helper.disableReferentialIntegrity(l.conn, loadFn)
+}
+
+func createJournalQuery(h helper) string {
+	table := h.quoteKeyword(journalTable)
+	switch h.(type) {
+	case *spanner:
+		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (load_id STRING(32) NOT NULL, position INT64 NOT NULL, statement STRING(MAX) NOT NULL) PRIMARY KEY (load_id, position)", table)
+	case *sqlserver:
+		return h.createTableIfNotExistsQuery(table, "load_id NVARCHAR(32) NOT NULL, position INTEGER NOT NULL, statement NVARCHAR(MAX) NOT NULL, PRIMARY KEY (load_id, position)")
+	}
+	return h.createTableIfNotExistsQuery(table, "load_id VARCHAR(32) NOT NULL, position INTEGER NOT NULL, statement TEXT NOT NULL, PRIMARY KEY (load_id, position)")
+}
+
+// deleteJournalQuery deletes the statements of a load from the journal.
+func deleteJournalQuery(h helper) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE load_id = %s", h.quoteKeyword(journalTable), h.paramType().placeholder(1))
+}
+
+// newLoadID returns a random id identifying the statements of a load in the
+// journal.
+func newLoadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("testfixtures: could not generate journal id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func writeJournal(db database, h helper, loadID string, statements []string) error {
+	if _, err := db.Exec(createJournalQuery(h)); err != nil {
+		return fmt.Errorf("testfixtures: could not create journal table: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	insertQuery := fmt.Sprintf(
+		"INSERT INTO %s (load_id, position, statement) VALUES (%s, %s, %s)",
+		h.quoteKeyword(journalTable),
+		h.paramType().placeholder(1),
+		h.paramType().placeholder(2),
+		h.paramType().placeholder(3),
+	)
+	for i, statement := range statements {
+		if _, err := tx.Exec(insertQuery, loadID, int64(i), statement); err != nil {
+			return fmt.Errorf("testfixtures: could not write journal: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// repairJournal runs the statements left in the journal by every load,
+// removing each one once it was run, and returns them. Nothing is written if
+// there is no journal.
+func repairJournal(db database, h helper) ([]string, error) {
+	exists, err := internalTableExists(db, h, journalTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	type entry struct {
+		loadID    string
+		position  int64
+		statement string
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT load_id, position, statement FROM %s ORDER BY load_id, position", h.quoteKeyword(journalTable)))
+	if err != nil {
+		return nil, fmt.Errorf("testfixtures: could not read journal: %w", err)
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.loadID, &e.position, &e.statement); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deleteQuery := fmt.Sprintf(
+		"DELETE FROM %s WHERE load_id = %s AND position = %s",
+		h.quoteKeyword(journalTable),
+		h.paramType().placeholder(1),
+		h.paramType().placeholder(2),
+	)
+	statements := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if _, err := db.Exec(e.statement); err != nil {
+			return statements, fmt.Errorf("testfixtures: could not repair the database after an interrupted load, running %q: %w", e.statement, err)
+		}
+		if _, err := db.Exec(deleteQuery, e.loadID, e.position); err != nil {
+			return statements, fmt.Errorf("testfixtures: could not clear journal: %w", err)
+		}
+		statements = append(statements, e.statement)
+	}
+	return statements, nil
+}
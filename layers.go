@@ -0,0 +1,183 @@
+package testfixtures
+
+import (
+	"fmt"
+	"strings"
+)
+
+// deleteKey is the key of the records of a layer deleting the record they
+// match in the lower layers, see Layers. It's not inserted as a column.
+const deleteKey = "_delete"
+
+// Layers informs Loader to load the YAML files and directories of the given
+// paths as layers, each one overriding the fixtures of the previous ones, like
+// a base set of fixtures and a variation for some environment or feature:
+//
+//	testfixtures.Layers("fixtures/base", "fixtures/staging")
+//
+// The records of a table in a layer replace the records of the same table in
+// the lower layers with the same label, or the same primary key for records
+// without a label. Other records are added. A record with "_delete: true"
+// deletes the record it matches in the lower layers:
+//
+//	# fixtures/staging/users.yml
+//	john:
+//	  _delete: true
+//
+// A label defined twice in the same layer is an error.
+//
+// The primary keys are read from the database, unless given with PrimaryKey.
+// Paths are read from the FS option, if given.
+func Layers(paths ...string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.pendingSources = append(l.pendingSources, pendingSource{
+			kind:  sourceLayers,
+			paths: paths,
+		})
+		return nil
+	}
+}
+
+// fixturesFromLayers reads the fixtures of each path like Paths, numbering
+// their layer after the ones already read.
+func (l *Loader) fixturesFromLayers(paths ...string) ([]*fixtureFile, error) {
+	layer := 0
+	for _, file := range l.fixturesFiles {
+		layer = max(layer, file.layer)
+	}
+
+	var files []*fixtureFile
+	for _, p := range paths {
+		fixtures, err := l.fixturesFromPaths(p)
+		if err != nil {
+			return nil, err
+		}
+		layer++
+		for _, file := range fixtures {
+			file.layer = layer
+		}
+		files = append(files, fixtures...)
+	}
+	return files, nil
+}
+
+// layeredRecord is where a record of a layer is.
+type layeredRecord struct {
+	file  *fixtureFile
+	index int
+}
+
+// mergeLayers removes the records of the layers overridden or deleted by the
+// upper layers. Records shared with a FixtureSet are copied, never modified.
+func (l *Loader) mergeLayers() error {
+	for _, table := range groupByTable(l.fixturesFiles) {
+		var (
+			files  []*fixtureFile
+			layers = make(map[int]bool)
+		)
+		for _, file := range table.files {
+			if file.layer == 0 {
+				continue
+			}
+			if file.records == nil {
+				records, err := file.parseRecords()
+				if err != nil {
+					return err
+				}
+				file.records = records
+			}
+			files = append(files, file)
+			layers[file.layer] = true
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		var (
+			owners  = make(map[string]layeredRecord)
+			removed = make(map[layeredRecord]bool)
+		)
+		for _, file := range files {
+			for i, record := range file.records {
+				current := layeredRecord{file, i}
+				// Labels are checked to be unique in each layer, even when
+				// there is a single one.
+				if len(layers) == 1 && !record.tombstone && record.label == "" {
+					continue
+				}
+				key, err := l.layerKey(table.name, file, record)
+				if err != nil {
+					return err
+				}
+
+				owner, ok := owners[key]
+				if ok && owner.file.layer == file.layer && record.label != "" {
+					return fmt.Errorf(`testfixtures: record "%s" of table "%s" is defined twice in the same layer, in files "%s" and "%s"`, record.label, table.name, owner.file.path, file.path)
+				}
+				overrides := ok && owner.file.layer < file.layer
+				if overrides {
+					removed[owner] = true
+					delete(owners, key)
+				}
+				if record.tombstone {
+					if !overrides {
+						return fmt.Errorf(`testfixtures: file "%s": no record %s in the lower layers to delete`, file.path, key)
+					}
+					removed[current] = true
+					continue
+				}
+				owners[key] = current
+			}
+		}
+
+		for _, file := range files {
+			records := make([]fixtureRecord, 0, len(file.records))
+			for i, record := range file.records {
+				if !removed[layeredRecord{file, i}] {
+					records = append(records, record)
+				}
+			}
+			file.records = records
+		}
+	}
+	return nil
+}
+
+// layerKey returns what identifies a record across layers: its label, or the
+// values of the primary key of its table.
+func (l *Loader) layerKey(tableName string, file *fixtureFile, record fixtureRecord) (string, error) {
+	if record.label != "" {
+		return fmt.Sprintf("%q", record.label), nil
+	}
+
+	primaryKey, err := l.primaryKey(tableName)
+	if err != nil {
+		return "", err
+	}
+	if len(primaryKey) == 0 {
+		return "", fmt.Errorf(`testfixtures: file "%s": the primary key of table "%s" is unknown, give it with PrimaryKey or label the records`, file.path, tableName)
+	}
+
+	values := make([]string, 0, len(primaryKey))
+	for _, column := range primaryKey {
+		value, ok := record.value(column)
+		if !ok {
+			return "", fmt.Errorf(`testfixtures: file "%s": record %d has no value for column "%s" of the primary key`, file.path, record.index, column)
+		}
+		values = append(values, fmt.Sprintf("%s=%v", column, value))
+	}
+	return "with " + strings.Join(values, ", "), nil
+}
+
+// value returns the value of the given column, whatever its case.
+func (r *fixtureRecord) value(column string) (any, bool) {
+	if value, ok := r.values[column]; ok {
+		return value, true
+	}
+	for name, value := range r.values {
+		if strings.EqualFold(name, column) {
+			return value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package testfixtures
+
+import (
+	"time"
+)
+
+// LoadResult describes what was done by Loader.LoadWithResult.
+type LoadResult struct {
+	// Tables holds the result of each fixture table, in the order they
+	// were given.
+	Tables []TableResult
+
+	// InitDuration is the time spent reading the database metadata when the
+	// Loader was created, and checking it is a test database.
+	InitDuration time.Duration
+	// ConstraintsDuration is the time spent disabling and enabling back
+	// referential integrity.
+	ConstraintsDuration time.Duration
+	// CleanupDuration is the time spent deleting the data of the fixture
+	// tables.
+	CleanupDuration time.Duration
+	// InsertDuration is the time spent inserting the fixture records.
+	InsertDuration time.Duration
+	// VerifyDuration is the time spent checking the referential integrity
+	// of the loaded tables, see VerifyReferentialIntegrity.
+	VerifyDuration time.Duration
+	// ResetSequencesDuration is the time spent resetting sequences.
+	ResetSequencesDuration time.Duration
+	// ChecksumDuration is the time spent checking which tables were
+	// modified and computing their checksums after loading fixtures.
+	ChecksumDuration time.Duration
+	// TotalDuration is the time spent in Loader.LoadWithResult.
+	TotalDuration time.Duration
+}
+
+// TableResult describes what was done on a fixture table.
+type TableResult struct {
+	Name string
+	// Skipped is true if the table was not reloaded, because it was not
+	// modified since fixtures were last loaded.
+	Skipped bool
+	// RowsDeleted is the number of rows deleted from the table before
+	// loading fixtures. It's always zero for databases that don't report
+	// the number of affected rows, like when cleaning with TRUNCATE.
+	RowsDeleted  int64
+	RowsInserted int64
+}
+
+// RowsInserted returns the total number of rows inserted.
+func (r *LoadResult) RowsInserted() int64 {
+	var n int64
+	for _, t := range r.Tables {
+		n += t.RowsInserted
+	}
+	return n
+}
+
+// SkippedTables returns the name of the tables that were not reloaded.
+func (r *LoadResult) SkippedTables() []string {
+	var tables []string
+	for _, t := range r.Tables {
+		if t.Skipped {
+			tables = append(tables, t.Name)
+		}
+	}
+	return tables
+}
+
+func (r *LoadResult) table(name string) *TableResult {
+	for i := range r.Tables {
+		if r.Tables[i].Name == name {
+			return &r.Tables[i]
+		}
+	}
+	r.Tables = append(r.Tables, TableResult{Name: name})
+	return &r.Tables[len(r.Tables)-1]
+}
@@ -0,0 +1,172 @@
+package testfixtures
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// CaptureGeneratedKeys makes Loader read the primary keys generated by the
+// database for the records omitting them, so they are returned by
+// Loader.Record and Loader.Records.
+//
+// These records are inserted one at a time, with INSERT ... RETURNING on
+// PostgreSQL and SQLite, INSERT ... OUTPUT INSERTED on SQL Server, and by
+// reading LAST_INSERT_ID() on MySQL. The primary keys are read from the
+// database, unless given with PrimaryKey.
+//
+// Only valid for PostgreSQL, MySQL, SQLite and SQL Server. Returns an error
+// otherwise.
+func CaptureGeneratedKeys() func(*Loader) error {
+	return func(l *Loader) error {
+		switch l.helper.(type) {
+		case *postgreSQL, *mySQL, *sqlite, *sqlserver:
+			l.captureGeneratedKeys = true
+		default:
+			return fmt.Errorf("testfixtures: CaptureGeneratedKeys is only valid for PostgreSQL, MySQL, SQLite and SQL Server databases")
+		}
+		return nil
+	}
+}
+
+// Record returns the values of the record labeled label in the fixtures of
+// table, as they are inserted: after templates, merge keys and conversions,
+// like dates converted to time.Time and objects to JSON. Values given as raw
+// SQL are left out.
+//
+// With CaptureGeneratedKeys, the primary key generated by the database is
+// returned once the record was inserted by this Loader.
+func (l *Loader) Record(table, label string) (map[string]any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, file := range l.fixturesFiles {
+		if file.tableName() != table {
+			continue
+		}
+		for _, insert := range file.insertSQLs {
+			if insert.record.label == label {
+				return l.loadedValues(file, insert), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf(`testfixtures: no record "%s" in the fixtures of table "%s"`, label, table)
+}
+
+// Records returns the values of the records in the fixtures of table, in the
+// order they are inserted, like Record does.
+func (l *Loader) Records(table string) ([]map[string]any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		records []map[string]any
+		found   bool
+	)
+	for _, file := range l.fixturesFiles {
+		if file.tableName() != table {
+			continue
+		}
+		found = true
+		for _, insert := range file.insertSQLs {
+			records = append(records, l.loadedValues(file, insert))
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf(`testfixtures: no fixtures for table "%s"`, table)
+	}
+	return records, nil
+}
+
+// recordID identifies a record of the fixtures, whatever the copy of its
+// file, see Loader.selectFiles.
+type recordID struct {
+	table string
+	path  string
+	index int
+}
+
+func newRecordID(file *fixtureFile, record fixtureRecord) recordID {
+	return recordID{table: file.tableName(), path: file.path, index: record.index}
+}
+
+// loadedValues returns the values of a record as inserted, with the keys
+// generated by the database.
+func (l *Loader) loadedValues(file *fixtureFile, insert insertSQL) map[string]any {
+	values := make(map[string]any, len(insert.values)+len(insert.returning))
+	for i, column := range insert.record.columnNames() {
+		if _, isRawSQL := insert.values[i].(rawSQL); !isRawSQL {
+			values[column] = insert.values[i]
+		}
+	}
+
+	l.generatedKeysMu.Lock()
+	defer l.generatedKeysMu.Unlock()
+	maps.Copy(values, l.generatedKeys[newRecordID(file, insert.record)])
+	return values
+}
+
+// missingPrimaryKey returns the columns of the primary key of the table the
+// record has no value for, to be generated by the database.
+func (l *Loader) missingPrimaryKey(tableName string, record fixtureRecord) ([]string, error) {
+	primaryKey, err := l.primaryKey(tableName)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, column := range primaryKey {
+		if _, ok := record.value(column); !ok {
+			missing = append(missing, column)
+		}
+	}
+	return missing, nil
+}
+
+// insertReturning inserts a record omitting its primary key, storing the
+// generated key.
+func (l *Loader) insertReturning(tx shared.Querier, file *fixtureFile, insert insertSQL) error {
+	generated := make(map[string]any, len(insert.returning))
+	if _, isMySQL := l.helper.(*mySQL); isMySQL {
+		result, err := tx.Exec(insert.sql, insert.params...)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		generated[insert.returning[0]] = id
+	} else {
+		values := make([]any, len(insert.returning))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := tx.QueryRow(insert.sql, insert.params...).Scan(dest...); err != nil {
+			return err
+		}
+		for i, column := range insert.returning {
+			generated[column] = values[i]
+		}
+	}
+
+	l.generatedKeysMu.Lock()
+	defer l.generatedKeysMu.Unlock()
+	if l.generatedKeys == nil {
+		l.generatedKeys = make(map[recordID]map[string]any)
+	}
+	l.generatedKeys[newRecordID(file, insert.record)] = generated
+	return nil
+}
+
+// hasGeneratedKeys returns whether some records of the file omit their
+// primary key, to be read once inserted.
+func (f *fixtureFile) hasGeneratedKeys() bool {
+	for _, insert := range f.insertSQLs {
+		if len(insert.returning) > 0 {
+			return true
+		}
+	}
+	return false
+}
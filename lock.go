@@ -0,0 +1,250 @@
+package testfixtures
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// defaultLockKey is the key of the lock of UseDatabaseLock when none is
+// given.
+const defaultLockKey = "testfixtures"
+
+// errLockTimeout is returned when the lock of UseDatabaseLock could not be
+// acquired in time.
+var errLockTimeout = errors.New("testfixtures: timed out waiting for the database lock")
+
+// UseDatabaseLock makes Loader hold a database lock while loading fixtures,
+// so processes loading fixtures into the same database, like test packages
+// run in parallel by "go test ./...", wait on each other instead of mixing
+// their data. Processes using the same key share the same lock, and wait for
+// it up to timeout before failing. The key defaults to "testfixtures".
+//
+// The lock is an advisory lock on PostgreSQL, GET_LOCK on MySQL,
+// sp_getapplock on SQL Server and a write transaction on SQLite, which locks
+// the whole database regardless of the key. Other connections can't write to
+// a SQLite database while it's locked, see Loader.Lock.
+//
+// To keep the data stable while a test runs, hold the lock with Loader.Lock.
+// It's also required to repair the database automatically after a process
+// died while loading fixtures, see Repair.
+//
+// Only valid for PostgreSQL, MySQL, SQLite and SQL Server. Returns an error
+// otherwise.
+func UseDatabaseLock(key string, timeout time.Duration) func(*Loader) error {
+	return func(l *Loader) error {
+		switch l.helper.(type) {
+		case *postgreSQL, *mySQL, *sqlite, *sqlserver:
+		default:
+			return fmt.Errorf("testfixtures: UseDatabaseLock is only valid for PostgreSQL, MySQL, SQLite and SQL Server databases")
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("testfixtures: lock timeout must be positive, got %s", timeout)
+		}
+		if key == "" {
+			key = defaultLockKey
+		}
+		l.lockKey = key
+		l.lockTimeout = timeout
+		return nil
+	}
+}
+
+// databaseLock is the lock of UseDatabaseLock held by a Loader.
+type databaseLock struct {
+	conn shared.Conn
+	// previousConn is what the Loader used to run statements before the
+	// lock was acquired, when they must run on the locked connection.
+	previousConn database
+}
+
+// Lock acquires the lock of UseDatabaseLock and holds it until the returned
+// function is called, so other processes can't load fixtures in between.
+// Load doesn't acquire the lock again while it's held:
+//
+//	unlock, err := fixtures.Lock(ctx)
+//	if err != nil {
+//	        ...
+//	}
+//	t.Cleanup(func() { _ = unlock() })
+//	if err := fixtures.Load(); err != nil {
+//	        ...
+//	}
+//
+// On SQLite, the lock is a write transaction, and the fixtures loaded while
+// it's held are part of it: other connections, including the ones used by
+// the test, only see them once the lock is released, and can't write to the
+// database until then.
+func (l *Loader) Lock(ctx context.Context) (unlock func() error, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, err := l.acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.releaseLock(lock)
+	}, nil
+}
+
+// acquireLock acquires the lock of UseDatabaseLock. The caller must hold l.mu.
+func (l *Loader) acquireLock(ctx context.Context) (*databaseLock, error) {
+	if l.lockKey == "" {
+		return nil, fmt.Errorf("testfixtures: Lock requires the UseDatabaseLock option")
+	}
+	if l.lock != nil {
+		return nil, fmt.Errorf("testfixtures: database lock is already held")
+	}
+
+	conn, err := l.database.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.helper.lock(ctx, conn, l.lockKey, l.lockTimeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	lock := &databaseLock{conn: conn}
+	if _, ok := l.helper.(*sqlite); ok {
+		// The lock is a transaction, so the statements of the Loader must
+		// run in it, or they would wait for the lock to be released.
+		lock.previousConn = l.conn
+		l.conn = l.traced(newLockedDatabase(conn))
+	}
+	l.lock = lock
+	return lock, nil
+}
+
+// withDatabaseLock runs fn holding the lock of UseDatabaseLock, unless it's
+// not used or already held. The caller must hold l.mu.
+func (l *Loader) withDatabaseLock(ctx context.Context, fn func() error) (err error) {
+	if l.lockKey == "" || l.lock != nil {
+		return fn()
+	}
+
+	lock, err := l.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := l.releaseLock(lock); err2 != nil && err == nil {
+			err = fmt.Errorf("testfixtures: could not release the database lock: %w", err2)
+		}
+	}()
+	return fn()
+}
+
+// releaseLock releases the lock of UseDatabaseLock, unless it was already
+// released. The caller must hold l.mu.
+func (l *Loader) releaseLock(lock *databaseLock) error {
+	if l.lock != lock {
+		return nil
+	}
+	l.lock = nil
+	if lock.previousConn != nil {
+		l.conn = lock.previousConn
+	}
+	err := l.helper.unlock(lock.conn, l.lockKey)
+	if closeErr := lock.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// lockID returns the numeric id of the lock with the given key, for
+// databases where locks are identified by a number.
+func lockID(key string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+// pollLock calls tryLock until it acquires the lock, or fails after timeout.
+func pollLock(ctx context.Context, timeout time.Duration, tryLock func() (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		locked, err := tryLock()
+		if err != nil || locked {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errLockTimeout
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// lockedDatabase runs statements on the connection holding the lock, which
+// is already in a transaction on SQLite. Transactions are savepoints.
+type lockedDatabase struct {
+	shared.Querier
+	// conn is the connection not bound to the context of the load, so
+	// savepoints are still rolled back once it's canceled.
+	conn shared.Conn
+}
+
+func newLockedDatabase(conn shared.Conn) lockedDatabase {
+	return lockedDatabase{conn, conn}
+}
+
+func (d lockedDatabase) Begin() (transaction, error) {
+	if _, err := d.Exec("SAVEPOINT testfixtures"); err != nil {
+		return nil, err
+	}
+	return &savepointTransaction{lockedDatabase: d}, nil
+}
+
+func (d lockedDatabase) Conn(_ context.Context) (shared.Conn, error) {
+	return nil, fmt.Errorf("testfixtures: database lock is already held")
+}
+
+func (d lockedDatabase) WithContext(ctx context.Context) database {
+	return lockedDatabase{d.conn.WithContext(ctx), d.conn}
+}
+
+type savepointTransaction struct {
+	lockedDatabase
+	done bool
+}
+
+func (tx *savepointTransaction) Prepare(query string) (statement, error) {
+	return queryStatement{tx.Querier, query}, nil
+}
+
+func (tx *savepointTransaction) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	_, err := tx.Exec("RELEASE testfixtures")
+	return err
+}
+
+func (tx *savepointTransaction) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	if _, err := tx.conn.Exec("ROLLBACK TO testfixtures"); err != nil {
+		return err
+	}
+	_, err := tx.conn.Exec("RELEASE testfixtures")
+	return err
+}
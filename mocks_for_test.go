@@ -1,19 +1,41 @@
 package testfixtures
 
 import (
-	"database/sql"
+	"context"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
 
 type MockHelper struct {
 	dbName string
+	host   string
+	tables []string
 }
 
-func (*MockHelper) init(*sql.DB) error {
+func (*MockHelper) init(shared.Querier) error {
 	return nil
 }
-func (*MockHelper) disableReferentialIntegrity(*sql.DB, loadFunction) error {
+func (*MockHelper) schemaFingerprint(shared.Querier) (string, error) {
+	return "", nil
+}
+func (*MockHelper) catalog() any {
+	return nil
+}
+func (*MockHelper) setCatalog(any) {}
+func (*MockHelper) disableReferentialIntegrity(database, loadFunction) error {
+	return nil
+}
+func (*MockHelper) restoreReferentialIntegrityStatements() []string {
+	return nil
+}
+func (*MockHelper) foreignKeys(shared.Querier, string) ([]foreignKey, error) {
+	return nil, nil
+}
+func (*MockHelper) lock(context.Context, shared.Conn, string, time.Duration) error {
+	return nil
+}
+func (*MockHelper) unlock(shared.Conn, string) error {
 	return nil
 }
 func (*MockHelper) paramType() ParamType {
@@ -23,30 +45,76 @@ func (*MockHelper) setCustomParamType(_ ParamType) {}
 func (*MockHelper) getDefaultParamType() ParamType {
 	return ""
 }
-func (*MockHelper) tableNames(shared.Queryable) ([]string, error) {
-	return nil, nil
+func (h *MockHelper) tableNames(shared.Querier) ([]string, error) {
+	return h.tables, nil
 }
-func (*MockHelper) isTableModified(shared.Queryable, string) (bool, error) {
+func (*MockHelper) isTableModified(shared.Querier, string) (bool, error) {
 	return false, nil
 }
-func (*MockHelper) computeTablesChecksum(shared.Queryable) error {
+func (*MockHelper) computeTablesChecksum(shared.Querier, []string) error {
 	return nil
 }
+func (*MockHelper) getChecksum(shared.Querier, string) (string, error) {
+	return "", nil
+}
 func (*MockHelper) quoteKeyword(string) string {
 	return ""
 }
-func (*MockHelper) whileInsertOnTable(*sql.Tx, string, func() error) error {
+func (*MockHelper) resetSequences(database) error {
 	return nil
 }
-func (h *MockHelper) databaseName(shared.Queryable) (string, error) {
+func (*MockHelper) restoreSequences(database, []string) error {
+	return nil
+}
+
+func (*MockHelper) whileInsertOnTable(shared.Querier, string, func() error) error {
+	return nil
+}
+func (h *MockHelper) databaseName(shared.Querier) (string, error) {
 	return h.dbName, nil
 }
 
+func (h *MockHelper) databaseHost(shared.Querier) (string, error) {
+	return h.host, nil
+}
+
 func (h *MockHelper) cleanTableQuery(string) string {
 	return ""
 }
 
-func (h *MockHelper) buildInsertSQL(shared.Queryable, string, []string, []string) (string, error) {
+func (h *MockHelper) createTableIfNotExistsQuery(string, string) string {
+	return ""
+}
+
+func (h *MockHelper) buildInsertSQL(shared.Querier, string, []string, []string) (string, error) {
+	return "", nil
+}
+
+func (h *MockHelper) disableReferentialIntegrityScript() ([]string, []string) {
+	return nil, nil
+}
+
+func (h *MockHelper) whileInsertOnTableScript(string) ([]string, []string) {
+	return nil, nil
+}
+
+func (h *MockHelper) sqlLiteral(any) (string, error) {
+	return "", nil
+}
+
+func (h *MockHelper) classifyInsertError(error) (InsertErrorCause, string) {
+	return CauseUnknown, ""
+}
+
+func (h *MockHelper) primaryKey(shared.Querier, string) ([]string, error) {
+	return nil, nil
+}
+
+func (h *MockHelper) buildUpsertSQL(shared.Querier, string, []string, []string, []string) (string, error) {
+	return "", nil
+}
+
+func (h *MockHelper) buildReturningInsertSQL(shared.Querier, string, []string, []string, []string) (string, error) {
 	return "", nil
 }
 
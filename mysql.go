@@ -1,25 +1,35 @@
 package testfixtures
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
 
 type mySQL struct {
 	baseHelper
+	changeTracking
 
 	skipResetSequences                bool
 	resetSequencesTo                  int64
 	allowMultipleStatementsInOneQuery bool
 
 	tables         []string
-	tablesChecksum map[string]int64
+	tablesChecksum map[string]string
+	// rowCounts are the row counts of the tables when their version was
+	// stored, as TRUNCATE TABLE doesn't fire the change tracking triggers.
+	rowCounts map[string]int64
 }
 
-func (h *mySQL) init(db *sql.DB) error {
+func (h *mySQL) init(db shared.Querier) error {
 	var err error
 	h.tables, err = h.tableNames(db)
 	if err != nil {
@@ -37,13 +47,19 @@ func (*mySQL) quoteKeyword(str string) string {
 	return fmt.Sprintf("`%s`", str)
 }
 
-func (*mySQL) databaseName(q shared.Queryable) (string, error) {
+func (*mySQL) databaseName(q shared.Querier) (string, error) {
 	var dbName string
 	err := q.QueryRow("SELECT DATABASE()").Scan(&dbName)
 	return dbName, err
 }
 
-func (h *mySQL) tableNames(q shared.Queryable) ([]string, error) {
+func (*mySQL) databaseHost(q shared.Querier) (string, error) {
+	var host string
+	err := q.QueryRow("SELECT @@hostname").Scan(&host)
+	return host, err
+}
+
+func (h *mySQL) tableNames(q shared.Querier) ([]string, error) {
 	const query = `
 		SELECT table_name
 		FROM information_schema.tables
@@ -78,14 +94,7 @@ func (h *mySQL) tableNames(q shared.Queryable) ([]string, error) {
 
 }
 
-func (h *mySQL) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
-	if !h.skipResetSequences {
-		defer func() {
-			if err2 := h.resetSequences(db); err2 != nil && err == nil {
-				err = err2
-			}
-		}()
-	}
+func (h *mySQL) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -108,35 +117,26 @@ func (h *mySQL) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (er
 	return tx.Commit()
 }
 
-func (h *mySQL) resetSequences(db *sql.DB) error {
-	if len(h.tables) == 0 {
+func (h *mySQL) resetSequences(db database) error {
+	if h.skipResetSequences || len(h.tables) == 0 {
 		return nil
 	}
 
-	resetSequencesTo := h.resetSequencesTo
-	if resetSequencesTo == 0 {
-		resetSequencesTo = 10000
-	}
-
 	if h.allowMultipleStatementsInOneQuery {
-		return h.resetSequencesInOneQuery(db, resetSequencesTo)
+		return h.resetSequencesInOneQuery(db)
 	}
-	return h.resetSequencesInMultipleQueries(db, resetSequencesTo)
+	return h.resetSequencesInMultipleQueries(db)
 
 }
 
-func (h *mySQL) resetSequencesInOneQuery(db *sql.DB, resetSequencesTo int64) error {
-	b := strings.Builder{}
-	for _, t := range h.tables {
-		b.WriteString(h.makeResetSequenceQuery(t, resetSequencesTo))
-	}
-	_, err := db.Exec(b.String())
+func (h *mySQL) resetSequencesInOneQuery(db database) error {
+	_, err := db.Exec(joinStatements(h.resetSequencesStatements()))
 	return err
 }
 
-func (h *mySQL) resetSequencesInMultipleQueries(db *sql.DB, resetSequencesTo int64) error {
-	for _, t := range h.tables {
-		_, err := db.Exec(h.makeResetSequenceQuery(t, resetSequencesTo))
+func (h *mySQL) resetSequencesInMultipleQueries(db database) error {
+	for _, query := range h.resetSequencesStatements() {
+		_, err := db.Exec(query)
 		if err != nil {
 			return err
 		}
@@ -144,50 +144,250 @@ func (h *mySQL) resetSequencesInMultipleQueries(db *sql.DB, resetSequencesTo int
 	return nil
 }
 
-func (h *mySQL) makeResetSequenceQuery(tableName string, resetSequencesTo int64) string {
-	return fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d;", h.quoteKeyword(tableName), resetSequencesTo)
-}
+func (h *mySQL) resetSequencesStatements() []string {
+	resetSequencesTo := h.resetSequencesTo
+	if resetSequencesTo == 0 {
+		resetSequencesTo = 10000
+	}
 
-func (h *mySQL) isTableModified(q shared.Queryable, tableName string) (bool, error) {
-	oldChecksum, found := h.tablesChecksum[tableName]
-	if !found {
-		return true, nil
+	statements := make([]string, 0, len(h.tables))
+	for _, t := range h.tables {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", h.quoteKeyword(t), resetSequencesTo))
 	}
+	return statements
+}
 
-	checksum, err := h.getChecksum(q, tableName)
-	if err != nil {
-		return true, err
+func (h *mySQL) disableReferentialIntegrityScript() (before, after []string) {
+	before = []string{"SET FOREIGN_KEY_CHECKS = 0", "START TRANSACTION"}
+	after = []string{"SET FOREIGN_KEY_CHECKS = 1", "COMMIT"}
+	if !h.skipResetSequences {
+		after = append(after, h.resetSequencesStatements()...)
 	}
-	return checksum != oldChecksum, nil
+	return before, after
 }
 
-func (h *mySQL) computeTablesChecksum(q shared.Queryable) error {
-	if h.tablesChecksum != nil {
-		return nil
+func (h *mySQL) sqlLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		// Backslashes are escape characters in MySQL string literals,
+		// unless the NO_BACKSLASH_ESCAPES mode is enabled.
+		return quoteStringWithBackslashes(v), nil
+	case time.Time:
+		// Same as the MySQL driver does with its default location.
+		return quoteString(v.UTC().Format("2006-01-02 15:04:05.999999")), nil
 	}
+	return h.baseHelper.sqlLiteral(value)
+}
 
-	h.tablesChecksum = make(map[string]int64, len(h.tables))
-	for _, t := range h.tables {
-		checksum, err := h.getChecksum(q, t)
+func (h *mySQL) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	if h.trackChanges {
+		modified, err := h.changeTracking.isTableModified(q, tableName, h.tableVersion)
+		if err != nil || modified {
+			return modified, err
+		}
+		count, err := h.rowCount(q, tableName)
 		if err != nil {
+			return true, err
+		}
+		return count != h.rowCounts[tableName], nil
+	}
+	return isChecksumModified(q, h.tablesChecksum, tableName, h.getChecksum)
+}
+
+func (h *mySQL) computeTablesChecksum(q shared.Querier, tables []string) error {
+	if h.trackChanges {
+		if err := h.snapshotVersions(q, h.createChangeTrackingTriggers, tables, h.tableVersion); err != nil {
 			return err
 		}
-		h.tablesChecksum[t] = checksum
+		if h.rowCounts == nil {
+			h.rowCounts = make(map[string]int64, len(tables))
+		}
+		for _, table := range tables {
+			count, err := h.rowCount(q, table)
+			if err != nil {
+				return err
+			}
+			h.rowCounts[table] = count
+		}
+		return nil
 	}
-	return nil
+
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, tables, h.getChecksum)
+	return err
 }
 
-func (h *mySQL) getChecksum(q shared.Queryable, tableName string) (int64, error) {
+func (h *mySQL) getChecksum(q shared.Querier, tableName string) (string, error) {
 	query := fmt.Sprintf("CHECKSUM TABLE %s", h.quoteKeyword(tableName))
 	var (
 		table    string
 		checksum sql.NullInt64
 	)
 	if err := q.QueryRow(query).Scan(&table, &checksum); err != nil {
-		return 0, err
+		return "", err
 	}
 	if !checksum.Valid {
-		return 0, fmt.Errorf("testfixtures: table %s does not exist", tableName)
+		return "", fmt.Errorf("testfixtures: table %s does not exist", tableName)
+	}
+	return strconv.FormatInt(checksum.Int64, 10), nil
+}
+
+func (h *mySQL) createChangeTrackingTriggers(q shared.Querier) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name VARCHAR(255) NOT NULL PRIMARY KEY,
+			version BIGINT NOT NULL DEFAULT 0
+		)
+	`, h.quoteKeyword(changeTrackingTable))
+	if _, err := q.Exec(query); err != nil {
+		return err
+	}
+
+	// MySQL only supports row level triggers, and they are not fired
+	// by TRUNCATE TABLE, which is detected by comparing row counts.
+	for _, table := range h.tables {
+		if isInternalTable(table) {
+			continue
+		}
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			triggerName := h.quoteKeyword(mySQLTriggerName(table, strings.ToLower(event)))
+			if _, err := q.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s", triggerName)); err != nil {
+				return err
+			}
+			query := fmt.Sprintf(
+				"CREATE TRIGGER %s AFTER %s ON %s FOR EACH ROW INSERT INTO %s (table_name, version) VALUES (%s, 1) ON DUPLICATE KEY UPDATE version = version + 1",
+				triggerName,
+				event,
+				h.quoteKeyword(table),
+				h.quoteKeyword(changeTrackingTable),
+				quoteStringWithBackslashes(table),
+			)
+			if _, err := q.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mySQLMaxIdentifierLength is the maximum length of the name of a trigger.
+const mySQLMaxIdentifierLength = 64
+
+// mySQLTriggerName returns the name of the change tracking trigger of a table
+// for an event. Names too long for MySQL are truncated, and suffixed with a
+// hash of the full name to stay unique.
+func mySQLTriggerName(table, event string) string {
+	name := []rune("testfixtures_" + table + "_" + event)
+	if len(name) <= mySQLMaxIdentifierLength {
+		return string(name)
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(string(name)))
+	suffix := fmt.Sprintf("_%08x", hash.Sum32())
+	return string(name[:mySQLMaxIdentifierLength-len(suffix)]) + suffix
+}
+
+func (h *mySQL) rowCount(q shared.Querier, tableName string) (int64, error) {
+	var count int64
+	if err := q.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", h.quoteKeyword(tableName))).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (h *mySQL) tableVersion(q shared.Querier, tableName string) (string, int64, error) {
+	query := fmt.Sprintf("SELECT COALESCE((SELECT version FROM %s WHERE table_name = ?), 0)", h.quoteKeyword(changeTrackingTable))
+	var version int64
+	if err := q.QueryRow(query, tableName).Scan(&version); err != nil {
+		return "", 0, err
+	}
+	return tableName, version, nil
+}
+
+var (
+	mySQLErrorNumberRegexp = regexp.MustCompile(`^Error (\d+)`)
+	mySQLColumnRegexp      = regexp.MustCompile("(?:[Cc]olumn|Field) '([^']+)'")
+	mySQLForeignKeyRegexp  = regexp.MustCompile("FOREIGN KEY \\(`([^`]+)`")
+)
+
+func (*mySQL) classifyInsertError(err error) (InsertErrorCause, string) {
+	message := err.Error()
+	switch submatch(mySQLErrorNumberRegexp, message) {
+	case "1062":
+		return CauseUniqueViolation, ""
+	case "1216", "1452":
+		return CauseForeignKeyViolation, submatch(mySQLForeignKeyRegexp, message)
+	case "1048", "1364":
+		return CauseNotNullViolation, submatch(mySQLColumnRegexp, message)
+	case "1054":
+		return CauseUnknownColumn, submatch(mySQLColumnRegexp, message)
+	case "1264", "1265", "1292", "1366":
+		return CauseTypeMismatch, submatch(mySQLColumnRegexp, message)
+	}
+	return CauseUnknown, ""
+}
+
+func (*mySQL) lock(_ context.Context, conn shared.Conn, key string, timeout time.Duration) error {
+	var locked sql.NullInt64
+	seconds := int64(math.Ceil(timeout.Seconds()))
+	if err := conn.QueryRow("SELECT GET_LOCK(?, ?)", key, seconds).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return errLockTimeout
+	}
+	return nil
+}
+
+func (*mySQL) unlock(conn shared.Conn, key string) error {
+	_, err := conn.Exec("DO RELEASE_LOCK(?)", key)
+	return err
+}
+
+func (*mySQL) foreignKeys(q shared.Querier, tableName string) ([]foreignKey, error) {
+	const query = `
+		SELECT constraint_name, column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE()
+		  AND table_name = ?
+		  AND referenced_table_name IS NOT NULL
+		ORDER BY constraint_name, ordinal_position
+	`
+	return queryForeignKeys(q, query, tableName)
+}
+
+func (*mySQL) primaryKey(q shared.Querier, tableName string) ([]string, error) {
+	const query = `
+		SELECT column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE()
+		  AND table_name = ?
+		  AND constraint_name = 'PRIMARY'
+		ORDER BY ordinal_position
+	`
+	return queryStrings(q, query, tableName)
+}
+
+// buildReturningInsertSQL returns the usual insert statement, as MySQL gives
+// the generated key with LAST_INSERT_ID(), read by Loader from the result of
+// the statement. It's only given for AUTO_INCREMENT columns, so a single one.
+func (h *mySQL) buildReturningInsertSQL(q shared.Querier, tableName string, columns, values, returning []string) (string, error) {
+	if len(returning) > 1 {
+		return "", fmt.Errorf("testfixtures: MySQL only gives a single generated key, but the records of %s omit %s", tableName, strings.Join(returning, ", "))
+	}
+	return h.buildInsertSQL(q, tableName, columns, values)
+}
+
+// buildUpsertSQL doesn't need the primary key, as ON DUPLICATE KEY UPDATE
+// applies to any unique key.
+func (h *mySQL) buildUpsertSQL(q shared.Querier, tableName string, _, columns, values []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	sets := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", column, column))
 	}
-	return checksum.Int64, nil
+	return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", "), nil
 }
@@ -0,0 +1,174 @@
+package testfixtures
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+type recordChangeKind int
+
+const (
+	recordOverride recordChangeKind = iota + 1
+	recordExclude
+	recordExtra
+)
+
+// recordChange is a change to the parsed fixtures given by Override, Exclude
+// or Extra.
+type recordChange struct {
+	kind    recordChangeKind
+	table   string
+	label   string
+	records []map[string]any
+}
+
+// Override replaces the values of the given columns of the record labeled
+// label in the fixtures of table, leaving its other columns as they are. A
+// nil value inserts NULL. It's meant for tests needing a variation of the
+// shared fixtures, without a new fixture file:
+//
+//	fixtures, err := testfixtures.New(
+//	        testfixtures.Database(db),
+//	        testfixtures.Dialect("postgres"),
+//	        testfixtures.Directory("testdata/fixtures"),
+//	        testfixtures.Override("users", "john", map[string]any{"banned": true}),
+//	)
+//
+// Only records of fixtures given as a map have a label. Loader returns an
+// error if no record of the table has it.
+func Override(table, label string, values map[string]any) func(*Loader) error {
+	return func(l *Loader) error {
+		l.recordChanges = append(l.recordChanges, recordChange{
+			kind:    recordOverride,
+			table:   table,
+			label:   label,
+			records: []map[string]any{maps.Clone(values)},
+		})
+		return nil
+	}
+}
+
+// Exclude leaves out the record labeled label in the fixtures of table.
+// Loader returns an error if no record of the table has it.
+func Exclude(table, label string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.recordChanges = append(l.recordChanges, recordChange{
+			kind:  recordExclude,
+			table: table,
+			label: label,
+		})
+		return nil
+	}
+}
+
+// Extra adds records to the fixtures of table, inserted after the records of
+// its files. Values are given as they would be in a fixture file. Loader
+// returns an error if there are no fixtures for the table.
+func Extra(table string, records ...map[string]any) func(*Loader) error {
+	return func(l *Loader) error {
+		change := recordChange{kind: recordExtra, table: table}
+		for _, record := range records {
+			change.records = append(change.records, maps.Clone(record))
+		}
+		l.recordChanges = append(l.recordChanges, change)
+		return nil
+	}
+}
+
+// applyRecordChanges applies the changes given by Override, Exclude and
+// Extra to the records of the fixture files, in the order they were given.
+// Records shared with a FixtureSet are copied, never modified.
+func (l *Loader) applyRecordChanges() error {
+	for _, change := range l.recordChanges {
+		var files []*fixtureFile
+		for _, file := range l.fixturesFiles {
+			if file.tableName() == change.table {
+				files = append(files, file)
+			}
+		}
+		if len(files) == 0 {
+			return fmt.Errorf(`testfixtures: no fixtures for table "%s"`, change.table)
+		}
+
+		for _, file := range files {
+			if file.records == nil {
+				records, err := file.parseRecords()
+				if err != nil {
+					return err
+				}
+				file.records = records
+			}
+		}
+
+		var err error
+		switch change.kind {
+		case recordOverride, recordExclude:
+			err = applyLabeledChange(files, change)
+		case recordExtra:
+			err = applyExtra(files[len(files)-1], change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyLabeledChange(files []*fixtureFile, change recordChange) error {
+	found := false
+	for _, file := range files {
+		i := slices.IndexFunc(file.records, func(record fixtureRecord) bool {
+			return record.label == change.label
+		})
+		if i < 0 {
+			continue
+		}
+		found = true
+
+		records := slices.Clone(file.records)
+		if change.kind == recordExclude {
+			records = slices.Delete(records, i, i+1)
+		} else {
+			values := maps.Clone(records[i].values)
+			maps.Copy(values, change.records[0])
+			records[i].values = values
+		}
+		file.records = records
+		file.changes = append(file.changes, change)
+	}
+	if !found {
+		return fmt.Errorf(`testfixtures: no record "%s" in the fixtures of table "%s"`, change.label, change.table)
+	}
+	return nil
+}
+
+func applyExtra(file *fixtureFile, change recordChange) error {
+	index := 0
+	for _, record := range file.records {
+		index = max(index, record.index+1)
+	}
+
+	records := slices.Clone(file.records)
+	for _, values := range change.records {
+		record, err := newFixtureRecord(maps.Clone(values))
+		if err != nil {
+			return err
+		}
+		record.index = index
+		index++
+		records = append(records, record)
+	}
+	file.records = records
+	file.changes = append(file.changes, change)
+	return nil
+}
+
+// writeChanges writes the changes applied to the records of the file to w,
+// so they change its hash like a change of its content.
+func (f *fixtureFile) writeChanges(w io.Writer) {
+	for _, change := range f.changes {
+		fmt.Fprintf(w, "%d:%q:%#v;", change.kind, change.label, change.records)
+	}
+}
@@ -0,0 +1,97 @@
+package testfixtures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Parallelism makes Loader clean and load up to n tables concurrently, each
+// one on its own connection and transaction. It can make loading a lot of
+// tables faster, but fixtures are not loaded atomically anymore: if a table
+// fails to load, the others may have been loaded already.
+//
+// Tables can only be loaded independently when referential integrity is not
+// enforced by the load transaction, so it is only valid for ClickHouse,
+// Spanner and PostgreSQL with UseDropConstraint. Returns an error otherwise.
+// On Spanner, interleaved tables still require their parent rows to exist, so
+// they can't be loaded in parallel with their parent table.
+func Parallelism(n int) func(*Loader) error {
+	return func(l *Loader) error {
+		if n < 1 {
+			return fmt.Errorf("testfixtures: parallelism must be at least 1, got %d", n)
+		}
+		l.parallelism = n
+		return nil
+	}
+}
+
+// checkParallelism checks the dialect supports Parallelism. It is done once
+// all options are processed, as UseDropConstraint may be given after it.
+func (l *Loader) checkParallelism() error {
+	if l.parallelism <= 1 {
+		return nil
+	}
+	switch helper := l.helper.(type) {
+	case *clickhouse, *spanner:
+		return nil
+	case *postgreSQL:
+		if helper.useDropConstraint {
+			return nil
+		}
+	}
+	return fmt.Errorf("testfixtures: Parallelism is only valid for ClickHouse, Spanner and PostgreSQL with UseDropConstraint")
+}
+
+// forEachTable calls fn for each table, on up to l.parallelism goroutines,
+// until ctx is canceled. Unlike errgroup, the errors of all tables are
+// returned, in the order of the tables.
+func (l *Loader) forEachTable(ctx context.Context, tables []*fixtureTable, fn func(*fixtureTable) error) error {
+	if l.parallelism <= 1 {
+		for _, table := range tables {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, len(tables))
+	var g errgroup.Group
+	g.SetLimit(l.parallelism)
+	for i, table := range tables {
+		g.Go(func() error {
+			if errs[i] = ctx.Err(); errs[i] == nil {
+				errs[i] = fn(table)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errors.Join(errs...)
+}
+
+// insertTableInTransaction inserts the records of a table on its own
+// transaction, for parallel loading.
+func (l *Loader) insertTableInTransaction(table *fixtureTable) (rowsInserted int64, err error) {
+	tx, err := l.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	statements := l.newStatementCache(tx)
+	rowsInserted, err = l.insertTable(tx, statements, table)
+	if closeErr := statements.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return rowsInserted, err
+	}
+	return rowsInserted, tx.Commit()
+}
@@ -0,0 +1,19 @@
+module github.com/go-testfixtures/testfixtures/pgxfixtures/v3
+
+go 1.25.9
+
+require (
+	github.com/go-testfixtures/testfixtures/v3 v3.0.0
+	github.com/jackc/pgx/v5 v5.10.0
+)
+
+require (
+	github.com/goccy/go-yaml v1.19.2 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
+)
+
+replace github.com/go-testfixtures/testfixtures/v3 => ../
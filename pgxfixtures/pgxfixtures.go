@@ -0,0 +1,346 @@
+// Package pgxfixtures loads fixtures with testfixtures into PostgreSQL
+// through a pgx connection or pool, without going through database/sql:
+//
+//	pool, err := pgxpool.New(ctx, "dbname=myapp_test")
+//	if err != nil {
+//	        ...
+//	}
+//
+//	fixtures, err := testfixtures.New(
+//	        pgxfixtures.Database(pool),
+//	        testfixtures.Dialect("pgx"),
+//	        testfixtures.Directory("testdata/fixtures"),
+//	)
+//
+// The records of each fixture file are sent in a single batch, and can be
+// copied with the copy protocol instead of inserted, see UseCopyFrom.
+package pgxfixtures
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-testfixtures/testfixtures/v3"
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DB is a pgx connection or pool, like *pgx.Conn or *pgxpool.Pool.
+type DB interface {
+	querier
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type options struct {
+	copyFrom bool
+}
+
+// Option configures how fixtures are loaded through pgx.
+type Option func(*options)
+
+// UseCopyFrom makes Loader copy the records of fixture files with the copy
+// protocol, which is faster for large files. It's not used for tables with
+// identity columns, with upserts, or when the records of a file have
+// different columns or raw SQL values, which are inserted as usual.
+//
+// Values are sent in the binary format, so they must have a type pgx can
+// encode for their column: types like enums must be registered on the
+// connection, and strings are not converted to numbers.
+func UseCopyFrom() Option {
+	return func(o *options) {
+		o.copyFrom = true
+	}
+}
+
+// Database sets the pgx connection or pool to Loader. The "pgx" or
+// "postgres" dialect must still be given with testfixtures.Dialect.
+//
+// A *pgx.Conn can't run statements concurrently, so a *pgxpool.Pool must be
+// used with testfixtures.Parallelism.
+func Database(db DB, opts ...Option) func(*testfixtures.Loader) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return testfixtures.Connection(&database{queryable{db, context.Background()}, db, o})
+}
+
+// DumpDatabase sets the pgx connection or pool to be dumped by Dumper.
+func DumpDatabase(db DB) func(*testfixtures.Dumper) error {
+	return testfixtures.DumpConnection(queryable{db, context.Background()})
+}
+
+type database struct {
+	queryable
+	db      DB
+	options options
+}
+
+func (d *database) Begin() (shared.Transaction, error) {
+	tx, err := d.db.Begin(d.ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &transaction{queryable{tx, d.ctx}, tx}
+	if d.options.copyFrom {
+		return &copyTransaction{t}, nil
+	}
+	return t, nil
+}
+
+func (d *database) Conn(ctx context.Context) (shared.Conn, error) {
+	switch db := d.db.(type) {
+	case *pgxpool.Pool:
+		c, err := db.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &conn{queryable{c, context.Background()}, c.Release}, nil
+	case *pgx.Conn:
+		return &conn{queryable{db, context.Background()}, func() {}}, nil
+	default:
+		return nil, fmt.Errorf("testfixtures: a single connection can't be taken from %T, use a *pgx.Conn or *pgxpool.Pool", d.db)
+	}
+}
+
+func (d *database) WithContext(ctx context.Context) shared.Database {
+	return &database{queryable{d.db, ctx}, d.db, d.options}
+}
+
+type conn struct {
+	queryable
+	release func()
+}
+
+func (c *conn) Close() error {
+	c.release()
+	return nil
+}
+
+func (c *conn) WithContext(ctx context.Context) shared.Conn {
+	return &conn{queryable{c.q, ctx}, c.release}
+}
+
+type transaction struct {
+	queryable
+	tx pgx.Tx
+}
+
+// Prepare doesn't prepare the statement, as pgx already prepares and caches
+// the statements it runs.
+func (t *transaction) Prepare(query string) (shared.Statement, error) {
+	return statement{t.queryable, query}, nil
+}
+
+func (t *transaction) Commit() error {
+	return t.tx.Commit(t.ctx)
+}
+
+func (t *transaction) Rollback() error {
+	return t.tx.Rollback(t.ctx)
+}
+
+func (t *transaction) ExecBatch(queries []string, args [][]any) (succeeded int, err error) {
+	batch := &pgx.Batch{}
+	for i, query := range queries {
+		batch.Queue(query, args[i]...)
+	}
+
+	results := t.tx.SendBatch(t.ctx, batch)
+	for range queries {
+		if _, err = results.Exec(); err != nil {
+			break
+		}
+		succeeded++
+	}
+	if closeErr := results.Close(); err == nil {
+		err = closeErr
+	}
+	return succeeded, err
+}
+
+type copyTransaction struct {
+	*transaction
+}
+
+func (t *copyTransaction) CopyFrom(tableName string, columns []string, rows [][]any) (int64, error) {
+	return t.tx.CopyFrom(
+		t.ctx,
+		pgx.Identifier(strings.Split(tableName, ".")),
+		columns,
+		pgx.CopyFromRows(rows),
+	)
+}
+
+type statement struct {
+	q     queryable
+	query string
+}
+
+func (s statement) Exec(args ...any) (sql.Result, error) {
+	return s.q.Exec(s.query, args...)
+}
+
+func (statement) Close() error {
+	return nil
+}
+
+// queryable runs statements like database/sql does, so the helpers of
+// testfixtures can use pgx.
+type queryable struct {
+	q   querier
+	ctx context.Context
+}
+
+func (q queryable) Exec(query string, args ...any) (sql.Result, error) {
+	tag, err := q.q.Exec(q.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result(tag.RowsAffected()), nil
+}
+
+func (q queryable) Query(query string, args ...any) (shared.Rows, error) {
+	r, err := q.q.Query(q.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r}, nil
+}
+
+func (q queryable) QueryRow(query string, args ...any) shared.Row {
+	r, err := q.Query(query, args...)
+	return &row{r, err}
+}
+
+type result int64
+
+func (result) LastInsertId() (int64, error) {
+	return 0, errors.New("testfixtures: LastInsertId is not supported by pgx")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+type rows struct {
+	pgx.Rows
+}
+
+func (r *rows) Columns() ([]string, error) {
+	fields := r.FieldDescriptions()
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, field.Name)
+	}
+	return columns, nil
+}
+
+// Scan is like pgx.Rows.Scan, but values scanned into *any are the ones
+// database/sql drivers give, so they can be inserted back or dumped.
+func (r *rows) Scan(dest ...any) error {
+	var anys map[int]*any
+	for i, d := range dest {
+		if p, ok := d.(*any); ok {
+			if anys == nil {
+				anys = make(map[int]*any)
+				dest = append([]any(nil), dest...)
+			}
+			anys[i] = p
+			dest[i] = nil
+		}
+	}
+
+	if err := r.Rows.Scan(dest...); err != nil {
+		return err
+	}
+	if anys == nil {
+		return nil
+	}
+
+	values, err := r.Values()
+	if err != nil {
+		return err
+	}
+	fields := r.FieldDescriptions()
+	for i, p := range anys {
+		if *p, err = convertValue(values[i], fields[i].DataTypeOID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rows) Close() error {
+	r.Rows.Close()
+	return r.Err()
+}
+
+type row struct {
+	rows shared.Rows
+	err  error
+}
+
+func (r *row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer func() {
+		_ = r.rows.Close()
+	}()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	return r.rows.Close()
+}
+
+// Err returns the error of the query, so hooks are given it.
+func (r *row) Err() error {
+	return r.err
+}
+
+// convertValue converts a value decoded by pgx into what database/sql
+// drivers give: JSON as text, UUIDs as strings, and types like numeric as
+// their driver value.
+func convertValue(value any, oid uint32) (any, error) {
+	switch oid {
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		if value == nil {
+			return nil, nil
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	switch v := value.(type) {
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16]), nil
+	case driver.Valuer:
+		return v.Value()
+	}
+	return value, nil
+}
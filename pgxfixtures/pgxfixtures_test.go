@@ -0,0 +1,46 @@
+package pgxfixtures
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestConvertValue(t *testing.T) {
+	date := time.Date(2016, 1, 1, 12, 30, 12, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value any
+		oid   uint32
+		want  any
+	}{
+		{"nil", nil, pgtype.Int4OID, nil},
+		{"int", int32(1), pgtype.Int4OID, int32(1)},
+		{"time", date, pgtype.TimestampOID, date},
+		{"json object", map[string]any{"name": "John"}, pgtype.JSONBOID, `{"name":"John"}`},
+		{"json array", []any{1.0, "a"}, pgtype.JSONOID, `[1,"a"]`},
+		{"json null", nil, pgtype.JSONBOID, nil},
+		{
+			"uuid",
+			[16]byte{0xa0, 0xee, 0xbc, 0x99, 0x9c, 0x0b, 0x4e, 0xf8, 0xbb, 0x6d, 0x6b, 0xb9, 0xbd, 0x38, 0x0a, 0x11},
+			pgtype.UUIDOID,
+			"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		},
+		{"numeric", pgtype.Numeric{Int: big.NewInt(12345), Exp: -2, Valid: true}, pgtype.NumericOID, "123.45"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := convertValue(test.value, test.oid)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
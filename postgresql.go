@@ -1,11 +1,15 @@
 package testfixtures
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
@@ -14,6 +18,7 @@ import (
 
 type postgreSQL struct {
 	baseHelper
+	changeTracking
 
 	useAlterConstraint bool
 	useDropConstraint  bool
@@ -26,6 +31,10 @@ type postgreSQL struct {
 	constraints              []pgConstraint
 	tablesChecksum           map[string]string
 
+	// droppedForeignKeys are the columns of constraints, by table, as they
+	// can't be read from the database while they are dropped.
+	droppedForeignKeys map[string][]foreignKey
+
 	version                 int
 	tablesHasIdentityColumn map[string]bool
 }
@@ -36,7 +45,7 @@ type pgConstraint struct {
 	definition     string
 }
 
-func (h *postgreSQL) init(db *sql.DB) error {
+func (h *postgreSQL) init(db shared.Querier) error {
 	var grp errgroup.Group
 	grp.Go(func() error {
 		var err error
@@ -58,6 +67,11 @@ func (h *postgreSQL) init(db *sql.DB) error {
 		h.constraints, err = h.getConstraints(db)
 		return err
 	})
+	grp.Go(func() error {
+		var err error
+		h.droppedForeignKeys, err = h.getForeignKeys(db)
+		return err
+	})
 	grp.Go(func() error {
 		var err error
 		h.version, err = h.getMajorVersion(db)
@@ -75,17 +89,94 @@ func (h *postgreSQL) init(db *sql.DB) error {
 	return nil
 }
 
+// pgCatalog is what init reads from the database.
+type pgCatalog struct {
+	tables                   []string
+	sequences                []string
+	nonDeferrableConstraints []pgConstraint
+	constraints              []pgConstraint
+	droppedForeignKeys       map[string][]foreignKey
+	version                  int
+	tablesHasIdentityColumn  map[string]bool
+}
+
+// schemaFingerprint hashes the relations, foreign keys and identity columns
+// of the database, along with the server version, which is what init reads.
+func (*postgreSQL) schemaFingerprint(q shared.Querier) (string, error) {
+	const query = `
+		SELECT md5(current_setting('server_version_num') || COALESCE(string_agg(definition, ';' ORDER BY definition), ''))
+		FROM (
+			SELECT 'relation ' || c.relkind::text || ' ' || n.nspname || '.' || c.relname AS definition
+			FROM pg_class c
+			INNER JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p', 'S')
+			  AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'crdb_internal')
+			  AND n.nspname NOT LIKE 'pg_toast%'
+			  AND n.nspname NOT LIKE '\_timescaledb%'
+			UNION ALL
+			SELECT 'constraint ' || conrelid::regclass::text || ' ' || conname || ' '
+				|| CASE WHEN condeferrable THEN 'deferrable ' ELSE '' END
+				|| pg_get_constraintdef(pg_constraint.oid)
+			FROM pg_constraint
+			INNER JOIN pg_namespace ON pg_namespace.oid = pg_constraint.connamespace
+			WHERE contype = 'f'
+			  AND pg_namespace.nspname NOT IN ('pg_catalog', 'information_schema', 'crdb_internal')
+			  AND pg_namespace.nspname NOT LIKE 'pg_toast%'
+			  AND pg_namespace.nspname NOT LIKE '\_timescaledb%'
+			UNION ALL
+			SELECT 'identity ' || table_schema || '.' || table_name || '.' || column_name
+			FROM information_schema.columns
+			WHERE is_identity = 'YES'
+			  AND table_schema NOT IN ('pg_catalog', 'information_schema', 'crdb_internal')
+			  AND table_schema NOT LIKE 'pg_toast%'
+			  AND table_schema NOT LIKE '\_timescaledb%'
+		) AS s
+	`
+	var fingerprint string
+	err := q.QueryRow(query).Scan(&fingerprint)
+	return fingerprint, err
+}
+
+func (h *postgreSQL) catalog() any {
+	return pgCatalog{
+		tables:                   h.tables,
+		sequences:                h.sequences,
+		nonDeferrableConstraints: h.nonDeferrableConstraints,
+		constraints:              h.constraints,
+		droppedForeignKeys:       h.droppedForeignKeys,
+		version:                  h.version,
+		tablesHasIdentityColumn:  h.tablesHasIdentityColumn,
+	}
+}
+
+func (h *postgreSQL) setCatalog(c any) {
+	catalog := c.(pgCatalog)
+	h.tables = catalog.tables
+	h.sequences = catalog.sequences
+	h.nonDeferrableConstraints = catalog.nonDeferrableConstraints
+	h.constraints = catalog.constraints
+	h.droppedForeignKeys = catalog.droppedForeignKeys
+	h.version = catalog.version
+	h.tablesHasIdentityColumn = catalog.tablesHasIdentityColumn
+}
+
 func (*postgreSQL) paramType() ParamType {
 	return ParamTypeDollar
 }
 
-func (*postgreSQL) databaseName(q shared.Queryable) (string, error) {
+func (*postgreSQL) databaseName(q shared.Querier) (string, error) {
 	var dbName string
 	err := q.QueryRow("SELECT current_database()").Scan(&dbName)
 	return dbName, err
 }
 
-func (h *postgreSQL) tableNames(q shared.Queryable) ([]string, error) {
+func (*postgreSQL) databaseHost(q shared.Querier) (string, error) {
+	var host string
+	err := q.QueryRow("SELECT COALESCE(host(inet_server_addr()), '')").Scan(&host)
+	return host, err
+}
+
+func (h *postgreSQL) tableNames(q shared.Querier) ([]string, error) {
 	var tables []string
 
 	const sql = `
@@ -118,7 +209,7 @@ func (h *postgreSQL) tableNames(q shared.Queryable) ([]string, error) {
 	return tables, nil
 }
 
-func (h *postgreSQL) getSequences(q shared.Queryable) ([]string, error) {
+func (h *postgreSQL) getSequences(q shared.Querier) ([]string, error) {
 	const sql = `
 		SELECT pg_namespace.nspname || '.' || pg_class.relname AS sequence_name
 		FROM pg_class
@@ -149,7 +240,7 @@ func (h *postgreSQL) getSequences(q shared.Queryable) ([]string, error) {
 	return sequences, nil
 }
 
-func (*postgreSQL) getNonDeferrableConstraints(q shared.Queryable) ([]pgConstraint, error) {
+func (*postgreSQL) getNonDeferrableConstraints(q shared.Querier) ([]pgConstraint, error) {
 	var constraints []pgConstraint
 
 	const sql = `
@@ -181,7 +272,7 @@ func (*postgreSQL) getNonDeferrableConstraints(q shared.Queryable) ([]pgConstrai
 	return constraints, nil
 }
 
-func (h *postgreSQL) getConstraints(q shared.Queryable) ([]pgConstraint, error) {
+func (h *postgreSQL) getConstraints(q shared.Querier) ([]pgConstraint, error) {
 	var constraints []pgConstraint
 
 	const sql = `
@@ -219,32 +310,67 @@ func (h *postgreSQL) getConstraints(q shared.Queryable) ([]pgConstraint, error)
 	return constraints, nil
 }
 
-func (h *postgreSQL) dropAndRecreateConstraints(db *sql.DB, loadFn loadFunction) (err error) {
+// getForeignKeys returns the foreign keys of constraints, by table qualified
+// by its schema.
+func (h *postgreSQL) getForeignKeys(q shared.Querier) (map[string][]foreignKey, error) {
+	const sql = `
+		SELECT namespace.nspname || '.' || class.relname, pg_constraint.conname, columns.attname,
+		       referenced_namespace.nspname || '.' || referenced_table.relname, referenced_columns.attname
+		FROM pg_constraint
+		CROSS JOIN LATERAL unnest(pg_constraint.conkey, pg_constraint.confkey)
+			WITH ORDINALITY AS keys(attnum, referenced_attnum, position)
+		INNER JOIN pg_class class ON class.oid = pg_constraint.conrelid
+		INNER JOIN pg_namespace namespace ON namespace.oid = class.relnamespace
+		INNER JOIN pg_attribute columns ON columns.attrelid = pg_constraint.conrelid
+			AND columns.attnum = keys.attnum
+		INNER JOIN pg_attribute referenced_columns ON referenced_columns.attrelid = pg_constraint.confrelid
+			AND referenced_columns.attnum = keys.referenced_attnum
+		INNER JOIN pg_class referenced_table ON referenced_table.oid = pg_constraint.confrelid
+		INNER JOIN pg_namespace referenced_namespace ON referenced_namespace.oid = referenced_table.relnamespace
+		WHERE pg_constraint.contype = 'f'
+		  AND namespace.nspname NOT IN ('pg_catalog', 'information_schema', 'crdb_internal')
+		  AND namespace.nspname NOT LIKE 'pg_toast%'
+		  AND namespace.nspname NOT LIKE '\_timescaledb%'
+		ORDER BY 1, pg_constraint.conname, keys.position
+	`
+	rows, err := q.Query(sql)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
-		// Re-create constraints again after load
-		var b strings.Builder
-		for _, constraint := range h.constraints {
-			b.WriteString(fmt.Sprintf(
-				"ALTER TABLE %s ADD CONSTRAINT %s %s;",
-				h.quoteKeyword(constraint.tableName),
-				h.quoteKeyword(constraint.constraintName),
-				constraint.definition,
-			))
+		_ = rows.Close()
+	}()
+
+	foreignKeys := make(map[string][]foreignKey)
+	for rows.Next() {
+		var tableName, name, column, referencedTable, referencedColumn string
+		if err = rows.Scan(&tableName, &name, &column, &referencedTable, &referencedColumn); err != nil {
+			return nil, err
 		}
-		if _, err2 := db.Exec(b.String()); err2 != nil && err == nil {
-			err = err2
+		tableForeignKeys := foreignKeys[tableName]
+		if n := len(tableForeignKeys); n == 0 || tableForeignKeys[n-1].name != name {
+			tableForeignKeys = append(tableForeignKeys, foreignKey{name: name, referencedTable: referencedTable})
+		}
+		fk := &tableForeignKeys[len(tableForeignKeys)-1]
+		fk.columns = append(fk.columns, column)
+		fk.referencedColumns = append(fk.referencedColumns, referencedColumn)
+		foreignKeys[tableName] = tableForeignKeys
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return foreignKeys, nil
+}
+
+func (h *postgreSQL) dropAndRecreateConstraints(db database, loadFn loadFunction) (err error) {
+	defer func() {
+		// Re-create constraints again after load
+		if _, err2 := db.Exec(joinStatements(h.addConstraintsStatements())); err2 != nil {
+			err = withRestoreError(err, err2)
 		}
 	}()
 
-	var b strings.Builder
-	for _, constraint := range h.constraints {
-		b.WriteString(fmt.Sprintf(
-			"ALTER TABLE %s DROP CONSTRAINT %s;",
-			h.quoteKeyword(constraint.tableName),
-			h.quoteKeyword(constraint.constraintName),
-		))
-	}
-	if _, err := db.Exec(b.String()); err != nil {
+	if _, err := db.Exec(joinStatements(h.dropConstraintsStatements())); err != nil {
 		return err
 	}
 
@@ -261,14 +387,10 @@ func (h *postgreSQL) dropAndRecreateConstraints(db *sql.DB, loadFn loadFunction)
 	return tx.Commit()
 }
 
-func (h *postgreSQL) disableTriggers(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *postgreSQL) disableTriggers(db database, loadFn loadFunction) (err error) {
 	defer func() {
-		var b strings.Builder
-		for _, table := range h.tables {
-			b.WriteString(fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER ALL;", h.quoteKeyword(table)))
-		}
-		if _, err2 := db.Exec(b.String()); err2 != nil && err == nil {
-			err = err2
+		if _, err2 := db.Exec(joinStatements(h.triggersStatements("ENABLE"))); err2 != nil {
+			err = withRestoreError(err, err2)
 		}
 	}()
 
@@ -277,11 +399,7 @@ func (h *postgreSQL) disableTriggers(db *sql.DB, loadFn loadFunction) (err error
 		return err
 	}
 
-	var b strings.Builder
-	for _, table := range h.tables {
-		b.WriteString(fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER ALL;", h.quoteKeyword(table)))
-	}
-	if _, err = tx.Exec(b.String()); err != nil {
+	if _, err = tx.Exec(joinStatements(h.triggersStatements("DISABLE"))); err != nil {
 		return err
 	}
 
@@ -293,23 +411,15 @@ func (h *postgreSQL) disableTriggers(db *sql.DB, loadFn loadFunction) (err error
 	return tx.Commit()
 }
 
-func (h *postgreSQL) makeConstraintsDeferrable(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *postgreSQL) makeConstraintsDeferrable(db database, loadFn loadFunction) (err error) {
 	defer func() {
 		// ensure constraint being not deferrable again after load
-		var b strings.Builder
-		for _, constraint := range h.nonDeferrableConstraints {
-			b.WriteString(fmt.Sprintf("ALTER TABLE %s ALTER CONSTRAINT %s NOT DEFERRABLE;", h.quoteKeyword(constraint.tableName), h.quoteKeyword(constraint.constraintName)))
-		}
-		if _, err2 := db.Exec(b.String()); err2 != nil && err == nil {
-			err = err2
+		if _, err2 := db.Exec(joinStatements(h.alterConstraintsStatements("NOT DEFERRABLE"))); err2 != nil {
+			err = withRestoreError(err, err2)
 		}
 	}()
 
-	var b strings.Builder
-	for _, constraint := range h.nonDeferrableConstraints {
-		b.WriteString(fmt.Sprintf("ALTER TABLE %s ALTER CONSTRAINT %s DEFERRABLE;", h.quoteKeyword(constraint.tableName), h.quoteKeyword(constraint.constraintName)))
-	}
-	if _, err := db.Exec(b.String()); err != nil {
+	if _, err := db.Exec(joinStatements(h.alterConstraintsStatements("DEFERRABLE"))); err != nil {
 		return err
 	}
 
@@ -330,16 +440,7 @@ func (h *postgreSQL) makeConstraintsDeferrable(db *sql.DB, loadFn loadFunction)
 	return tx.Commit()
 }
 
-func (h *postgreSQL) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
-	// ensure sequences being reset after load
-	if !h.skipResetSequences {
-		defer func() {
-			if err2 := h.resetSequences(db); err2 != nil && err == nil {
-				err = err2
-			}
-		}()
-	}
-
+func (h *postgreSQL) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	if h.useDropConstraint {
 		return h.dropAndRecreateConstraints(db, loadFn)
 	}
@@ -349,55 +450,204 @@ func (h *postgreSQL) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction
 	return h.disableTriggers(db, loadFn)
 }
 
-func (h *postgreSQL) resetSequences(db *sql.DB) error {
-	if len(h.sequences) == 0 {
+func (h *postgreSQL) restoreReferentialIntegrityStatements() []string {
+	switch {
+	case h.useDropConstraint:
+		return h.replaceConstraintsStatements()
+	case h.useAlterConstraint:
+		return h.alterConstraintsStatements("NOT DEFERRABLE")
+	}
+	return nil
+}
+
+func (h *postgreSQL) resetSequences(db database) error {
+	if h.skipResetSequences || len(h.sequences) == 0 {
 		return nil
 	}
 
+	_, err := db.Exec(joinStatements(h.resetSequencesStatements()))
+	return err
+}
+
+func (h *postgreSQL) resetSequencesStatements() []string {
 	resetSequencesTo := h.resetSequencesTo
 	if resetSequencesTo == 0 {
 		resetSequencesTo = 10000
 	}
 
-	b := strings.Builder{}
+	statements := make([]string, 0, len(h.sequences))
 	for _, sequence := range h.sequences {
-		b.WriteString(fmt.Sprintf("SELECT SETVAL('%s', %d);", sequence, resetSequencesTo))
+		statements = append(statements, fmt.Sprintf("SELECT SETVAL('%s', %d)", sequence, resetSequencesTo))
 	}
+	return statements
+}
 
-	_, err := db.Exec(b.String())
+// restoreSequences sets the sequences owned by the columns of the given
+// tables to the greatest value of their column plus one, or to the value
+// given with ResetSequencesTo if it's higher, so the restored rows don't
+// conflict with the next generated values.
+func (h *postgreSQL) restoreSequences(db database, tables []string) error {
+	if h.skipResetSequences {
+		return nil
+	}
+	resetSequencesTo := h.resetSequencesTo
+	if resetSequencesTo == 0 {
+		resetSequencesTo = 10000
+	}
+
+	query := fmt.Sprintf(`
+		SELECT pg_depend.objid::regclass::text, pg_attribute.attname
+		FROM pg_depend
+		INNER JOIN pg_class ON pg_class.oid = pg_depend.objid AND pg_class.relkind = 'S'
+		INNER JOIN pg_attribute ON pg_attribute.attrelid = pg_depend.refobjid
+			AND pg_attribute.attnum = pg_depend.refobjsubid
+		WHERE pg_depend.refobjid = %s::regclass
+		  AND pg_depend.deptype IN ('a', 'i')
+	`, h.paramType().placeholder(1))
+
+	var statements []string
+	for _, table := range tables {
+		rows, err := db.Query(query, h.quoteKeyword(table))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var sequence, column string
+			if err := rows.Scan(&sequence, &column); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			statements = append(statements, fmt.Sprintf(
+				"SELECT SETVAL(%s, GREATEST((SELECT COALESCE(MAX(%s), 0) + 1 FROM %s), %d))",
+				quoteString(sequence),
+				h.quoteKeyword(column),
+				h.quoteKeyword(table),
+				resetSequencesTo,
+			))
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if len(statements) == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(joinStatements(statements))
 	return err
 }
 
-func (h *postgreSQL) isTableModified(q shared.Queryable, tableName string) (bool, error) {
-	oldChecksum, found := h.tablesChecksum[tableName]
-	if !found {
-		return true, nil
+func (h *postgreSQL) dropConstraintsStatements() []string {
+	statements := make([]string, 0, len(h.constraints))
+	for _, constraint := range h.constraints {
+		statements = append(statements, fmt.Sprintf(
+			"ALTER TABLE %s DROP CONSTRAINT %s",
+			h.quoteKeyword(constraint.tableName),
+			h.quoteKeyword(constraint.constraintName),
+		))
+	}
+	return statements
+}
+
+func (h *postgreSQL) addConstraintsStatements() []string {
+	statements := make([]string, 0, len(h.constraints))
+	for _, constraint := range h.constraints {
+		statements = append(statements, fmt.Sprintf(
+			"ALTER TABLE %s ADD CONSTRAINT %s %s",
+			h.quoteKeyword(constraint.tableName),
+			h.quoteKeyword(constraint.constraintName),
+			constraint.definition,
+		))
 	}
+	return statements
+}
 
-	checksum, err := h.getChecksum(q, tableName)
-	if err != nil {
-		return true, err
+// replaceConstraintsStatements returns the statements adding the constraints
+// back even if some of them already exist, as the process may have died after
+// they were added but before the journal was cleared.
+func (h *postgreSQL) replaceConstraintsStatements() []string {
+	statements := make([]string, 0, len(h.constraints))
+	for _, constraint := range h.constraints {
+		statements = append(statements, fmt.Sprintf(
+			"ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s, ADD CONSTRAINT %s %s",
+			h.quoteKeyword(constraint.tableName),
+			h.quoteKeyword(constraint.constraintName),
+			h.quoteKeyword(constraint.constraintName),
+			constraint.definition,
+		))
 	}
-	return checksum != oldChecksum, nil
+	return statements
 }
 
-func (h *postgreSQL) computeTablesChecksum(q shared.Queryable) error {
-	if h.tablesChecksum != nil {
-		return nil
+// triggersStatements returns the statements to either "ENABLE" or "DISABLE"
+// all triggers.
+func (h *postgreSQL) triggersStatements(action string) []string {
+	statements := make([]string, 0, len(h.tables))
+	for _, table := range h.tables {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s %s TRIGGER ALL", h.quoteKeyword(table), action))
 	}
+	return statements
+}
 
-	h.tablesChecksum = make(map[string]string, len(h.tables))
-	for _, t := range h.tables {
-		checksum, err := h.getChecksum(q, t)
-		if err != nil {
-			return err
-		}
-		h.tablesChecksum[t] = checksum
+// alterConstraintsStatements returns the statements to make non deferrable
+// constraints either "DEFERRABLE" or "NOT DEFERRABLE".
+func (h *postgreSQL) alterConstraintsStatements(deferrable string) []string {
+	statements := make([]string, 0, len(h.nonDeferrableConstraints))
+	for _, constraint := range h.nonDeferrableConstraints {
+		statements = append(statements, fmt.Sprintf(
+			"ALTER TABLE %s ALTER CONSTRAINT %s %s",
+			h.quoteKeyword(constraint.tableName),
+			h.quoteKeyword(constraint.constraintName),
+			deferrable,
+		))
 	}
-	return nil
+	return statements
+}
+
+func (h *postgreSQL) disableReferentialIntegrityScript() (before, after []string) {
+	switch {
+	case h.useDropConstraint:
+		before = append(h.dropConstraintsStatements(), "BEGIN")
+		after = append([]string{"COMMIT"}, h.addConstraintsStatements()...)
+	case h.useAlterConstraint:
+		before = append(h.alterConstraintsStatements("DEFERRABLE"), "BEGIN", "SET CONSTRAINTS ALL DEFERRED")
+		after = append([]string{"COMMIT"}, h.alterConstraintsStatements("NOT DEFERRABLE")...)
+	default:
+		before = append([]string{"BEGIN"}, h.triggersStatements("DISABLE")...)
+		after = append([]string{"COMMIT"}, h.triggersStatements("ENABLE")...)
+	}
+	if !h.skipResetSequences {
+		after = append(after, h.resetSequencesStatements()...)
+	}
+	return before, after
+}
+
+func (h *postgreSQL) sqlLiteral(value any) (string, error) {
+	if v, ok := value.([]byte); ok {
+		return fmt.Sprintf(`'\x%x'::bytea`, v), nil
+	}
+	return h.baseHelper.sqlLiteral(value)
 }
 
-func (h *postgreSQL) getChecksum(q shared.Queryable, tableName string) (string, error) {
+func (h *postgreSQL) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	if h.trackChanges {
+		return h.changeTracking.isTableModified(q, tableName, h.tableVersion)
+	}
+	return isChecksumModified(q, h.tablesChecksum, tableName, h.getChecksum)
+}
+
+func (h *postgreSQL) computeTablesChecksum(q shared.Querier, tables []string) error {
+	if h.trackChanges {
+		return h.snapshotVersions(q, h.createChangeTrackingTriggers, tables, h.tableVersion)
+	}
+
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, tables, h.getChecksum)
+	return err
+}
+
+func (h *postgreSQL) getChecksum(q shared.Querier, tableName string) (string, error) {
 	sqlStr := fmt.Sprintf(`
 			SELECT md5(CAST((json_agg(t.*)) AS TEXT))
 			FROM %s AS t
@@ -412,6 +662,53 @@ func (h *postgreSQL) getChecksum(q shared.Queryable, tableName string) (string,
 	return checksum.String, nil
 }
 
+func (h *postgreSQL) createChangeTrackingTriggers(q shared.Querier) error {
+	// Tables are identified by their OID, so they can be looked up by any
+	// name resolvable through the search path.
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			table_oid OID PRIMARY KEY,
+			version BIGINT NOT NULL DEFAULT 0
+		);
+		CREATE OR REPLACE FUNCTION testfixtures_track_changes() RETURNS TRIGGER AS $$
+		BEGIN
+			INSERT INTO %[1]s (table_oid, version) VALUES (TG_RELID, 1)
+			ON CONFLICT (table_oid) DO UPDATE SET version = %[1]s.version + 1;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+	`, changeTrackingTable))
+	for _, table := range h.tables {
+		if isInternalTable(table) {
+			continue
+		}
+		b.WriteString(fmt.Sprintf(
+			"DROP TRIGGER IF EXISTS testfixtures_track_changes ON %[1]s;"+
+				"CREATE TRIGGER testfixtures_track_changes AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON %[1]s "+
+				"FOR EACH STATEMENT EXECUTE PROCEDURE testfixtures_track_changes();",
+			h.quoteKeyword(table),
+		))
+	}
+	_, err := q.Exec(b.String())
+	return err
+}
+
+func (h *postgreSQL) tableVersion(q shared.Querier, tableName string) (string, int64, error) {
+	query := fmt.Sprintf(`
+		SELECT CAST($1::text::regclass AS oid)::text,
+		       COALESCE((SELECT version FROM %s WHERE table_oid = $1::text::regclass), 0)
+	`, changeTrackingTable)
+	var (
+		key     string
+		version int64
+	)
+	if err := q.QueryRow(query, h.quoteKeyword(tableName)).Scan(&key, &version); err != nil {
+		return "", 0, err
+	}
+	return key, version, nil
+}
+
 func (*postgreSQL) quoteKeyword(s string) string {
 	isQuotedColumn := strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`)
 	if isQuotedColumn {
@@ -425,7 +722,7 @@ func (*postgreSQL) quoteKeyword(s string) string {
 	return strings.Join(parts, ".")
 }
 
-func (h *postgreSQL) buildInsertSQL(q shared.Queryable, tableName string, columns, values []string) (string, error) {
+func (h *postgreSQL) buildInsertSQL(q shared.Querier, tableName string, columns, values []string) (string, error) {
 	if h.version >= 10 {
 		if h.tableHasIdentityColumn(tableName) {
 			return fmt.Sprintf(
@@ -457,7 +754,7 @@ func (h *postgreSQL) tableHasIdentityColumn(tableName string) bool {
 	return h.tablesHasIdentityColumn[tableName]
 }
 
-func (h *postgreSQL) buildTableHasIdentityColumn(q shared.Queryable) (map[string]bool, error) {
+func (h *postgreSQL) buildTableHasIdentityColumn(q shared.Querier) (map[string]bool, error) {
 	const query = `SELECT table_name, COUNT(*) AS count
     FROM information_schema.columns
     WHERE
@@ -489,7 +786,7 @@ func (h *postgreSQL) buildTableHasIdentityColumn(q shared.Queryable) (map[string
 	return tablesHasIdentityColumn, rows.Err()
 }
 
-func (h *postgreSQL) getMajorVersion(q shared.Queryable) (int, error) {
+func (h *postgreSQL) getMajorVersion(q shared.Querier) (int, error) {
 	var version string
 	err := q.QueryRow("SELECT VERSION()").Scan(&version)
 	if err != nil {
@@ -512,3 +809,127 @@ func (*postgreSQL) parseMajorVersion(version string) (int, error) {
 
 	return 0, fmt.Errorf("testfixtures: could not parse major version from: %s", version)
 }
+
+var (
+	postgresColumnRegexp    = regexp.MustCompile(`column "([^"]+)"`)
+	postgresKeyDetailRegexp = regexp.MustCompile(`^Key \(([^,)]+)`)
+)
+
+func (*postgreSQL) classifyInsertError(err error) (InsertErrorCause, string) {
+	var pgErr interface {
+		error
+		SQLState() string
+	}
+	if !errors.As(err, &pgErr) {
+		return CauseUnknown, ""
+	}
+
+	code := pgErr.SQLState()
+	switch {
+	case code == "23505":
+		return CauseUniqueViolation, submatch(postgresKeyDetailRegexp, postgresErrorDetail(pgErr))
+	case code == "23503":
+		return CauseForeignKeyViolation, submatch(postgresKeyDetailRegexp, postgresErrorDetail(pgErr))
+	case code == "23502":
+		return CauseNotNullViolation, submatch(postgresColumnRegexp, pgErr.Error())
+	case code == "42703":
+		return CauseUnknownColumn, submatch(postgresColumnRegexp, pgErr.Error())
+	case code == "42804" || strings.HasPrefix(code, "22"):
+		return CauseTypeMismatch, submatch(postgresColumnRegexp, pgErr.Error())
+	}
+	return CauseUnknown, ""
+}
+
+// postgresErrorDetail returns the detail of the error, which holds the key of
+// unique and foreign key violations. Neither lib/pq nor pgx expose it through
+// a method, but both have a Detail field.
+func postgresErrorDetail(err error) string {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if detail := v.FieldByName("Detail"); detail.Kind() == reflect.String {
+		return detail.String()
+	}
+	return ""
+}
+
+func (h *postgreSQL) primaryKey(q shared.Querier, tableName string) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT pg_attribute.attname
+		FROM pg_index
+		INNER JOIN pg_attribute ON pg_attribute.attrelid = pg_index.indrelid
+			AND pg_attribute.attnum = ANY(pg_index.indkey)
+		WHERE pg_index.indrelid = %s::regclass
+		  AND pg_index.indisprimary
+	`, h.paramType().placeholder(1))
+	return queryStrings(q, query, h.quoteKeyword(tableName))
+}
+
+func (*postgreSQL) lock(ctx context.Context, conn shared.Conn, key string, timeout time.Duration) error {
+	return pollLock(ctx, timeout, func() (bool, error) {
+		var locked bool
+		err := conn.QueryRow("SELECT pg_try_advisory_lock($1)", lockID(key)).Scan(&locked)
+		return locked, err
+	})
+}
+
+func (*postgreSQL) unlock(conn shared.Conn, key string) error {
+	_, err := conn.Exec("SELECT pg_advisory_unlock($1)", lockID(key))
+	return err
+}
+
+func (h *postgreSQL) foreignKeys(q shared.Querier, tableName string) ([]foreignKey, error) {
+	if h.useDropConstraint {
+		// The constraints are dropped while loading fixtures, so the foreign
+		// keys are the ones read by init.
+		if foreignKeys, ok := h.droppedForeignKeys[tableName]; ok {
+			return foreignKeys, nil
+		}
+		for name, foreignKeys := range h.droppedForeignKeys {
+			if sameTable(name, tableName) {
+				return foreignKeys, nil
+			}
+		}
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT pg_constraint.conname, columns.attname,
+		       referenced_namespace.nspname || '.' || referenced_table.relname, referenced_columns.attname
+		FROM pg_constraint
+		CROSS JOIN LATERAL unnest(pg_constraint.conkey, pg_constraint.confkey)
+			WITH ORDINALITY AS keys(attnum, referenced_attnum, position)
+		INNER JOIN pg_attribute columns ON columns.attrelid = pg_constraint.conrelid
+			AND columns.attnum = keys.attnum
+		INNER JOIN pg_attribute referenced_columns ON referenced_columns.attrelid = pg_constraint.confrelid
+			AND referenced_columns.attnum = keys.referenced_attnum
+		INNER JOIN pg_class referenced_table ON referenced_table.oid = pg_constraint.confrelid
+		INNER JOIN pg_namespace referenced_namespace ON referenced_namespace.oid = referenced_table.relnamespace
+		WHERE pg_constraint.contype = 'f'
+		  AND pg_constraint.conrelid = %s::regclass
+		ORDER BY pg_constraint.conname, keys.position
+	`, h.paramType().placeholder(1))
+	return queryForeignKeys(q, query, h.quoteKeyword(tableName))
+}
+
+func (h *postgreSQL) buildReturningInsertSQL(q shared.Querier, tableName string, columns, values, returning []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	return insert + returningClause(h.quoteKeyword, returning), nil
+}
+
+func (h *postgreSQL) buildUpsertSQL(q shared.Querier, tableName string, primaryKey, columns, values []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	keyColumns, updateColumns := upsertColumns(h.quoteKeyword, primaryKey, columns)
+	onConflict, err := onConflictDoUpdate(tableName, keyColumns, updateColumns)
+	if err != nil {
+		return "", err
+	}
+	return insert + onConflict, nil
+}
@@ -0,0 +1,257 @@
+package testfixtures
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// fixtureRecord is a record of a fixture file, alongside where it was
+// declared in the YAML file.
+type fixtureRecord struct {
+	// label is the key of the record, for fixtures given as a map.
+	label string
+	// index is the position of the record in the file.
+	index    int
+	position yamlPosition
+	values   map[string]any
+	// tags are the groups the record belongs to, given by its "_tags" key.
+	tags []string
+	// tombstone is true when the record deletes the record it matches in
+	// the lower layers, see Layers.
+	tombstone bool
+
+	// columns stores the position of each column of the record, and order
+	// the columns in the order they were declared.
+	columns map[string]yamlPosition
+	order   []string
+}
+
+type yamlPosition struct {
+	line   int
+	column int
+}
+
+// columnPosition returns the position of the given column, or of the record
+// if unknown.
+func (r *fixtureRecord) columnPosition(column string) yamlPosition {
+	for name, position := range r.columns {
+		if strings.EqualFold(name, column) {
+			return position
+		}
+	}
+	return r.position
+}
+
+// columnNames returns the columns of the record in the order they were
+// declared, so the generated SQL is the same on every run. Columns not found
+// in the YAML document, like the ones coming from merge keys, come last in
+// alphabetical order.
+func (r *fixtureRecord) columnNames() []string {
+	names := make([]string, 0, len(r.values))
+	seen := make(map[string]bool, len(r.values))
+	for _, name := range r.order {
+		if _, ok := r.values[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+
+	var others []string
+	for name := range r.values {
+		if !seen[name] {
+			others = append(others, name)
+		}
+	}
+	slices.Sort(others)
+	return append(names, others...)
+}
+
+// parseRecords parses the records of a fixture file, in the order they were
+// declared.
+func (f *fixtureFile) parseRecords() ([]fixtureRecord, error) {
+	var records any
+	if err := yaml.Unmarshal(f.content, &records); err != nil {
+		return nil, fmt.Errorf("testfixtures: could not unmarshal YAML: %w", err)
+	}
+
+	node := f.recordsNode
+	if node == nil {
+		file, err := parser.ParseBytes(f.content, 0)
+		if err != nil {
+			return nil, fmt.Errorf("testfixtures: could not parse YAML: %w", err)
+		}
+		if len(file.Docs) > 0 {
+			node = file.Docs[0].Body
+		}
+	}
+
+	result, err := buildRecords(records, node)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].index = i
+	}
+	return result, nil
+}
+
+// buildRecords builds the records from the decoded YAML, which is either a
+// slice of records or a map of labeled records, and its AST node.
+func buildRecords(records any, node ast.Node) ([]fixtureRecord, error) {
+	node = unwrapNode(node)
+
+	switch records := records.(type) {
+	case []any:
+		var nodes []ast.Node
+		if seq, ok := node.(*ast.SequenceNode); ok && len(seq.Values) == len(records) {
+			nodes = seq.Values
+		}
+
+		result := make([]fixtureRecord, 0, len(records))
+		for i, values := range records {
+			record, err := newFixtureRecord(values)
+			if err != nil {
+				return nil, err
+			}
+			if nodes != nil {
+				record.setPositions(nodes[i])
+			}
+			result = append(result, record)
+		}
+		return result, nil
+
+	case map[string]any:
+		result := make([]fixtureRecord, 0, len(records))
+		seen := make(map[string]bool, len(records))
+		for _, kv := range mappingValues(node) {
+			label := kv.Key.GetToken().Value
+			values, ok := records[label]
+			if !ok || seen[label] || label == tableKey {
+				continue
+			}
+			record, err := newFixtureRecord(values)
+			if err != nil {
+				return nil, err
+			}
+			record.label = label
+			record.setPositions(kv.Value)
+			record.position = nodePosition(kv.Key)
+			result = append(result, record)
+			seen[label] = true
+		}
+
+		// Should not happen, but don't lose records not found in the AST.
+		for _, label := range slices.Sorted(maps.Keys(records)) {
+			if seen[label] || label == tableKey {
+				continue
+			}
+			record, err := newFixtureRecord(records[label])
+			if err != nil {
+				return nil, err
+			}
+			record.label = label
+			result = append(result, record)
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("testfixtures: fixture is not a slice or map")
+}
+
+// tagsKey is the key of records used to add them to groups, see
+// Loader.LoadGroups. It's not inserted as a column.
+const tagsKey = "_tags"
+
+func newFixtureRecord(values any) (fixtureRecord, error) {
+	recordMap, ok := values.(map[string]any)
+	if !ok {
+		return fixtureRecord{}, fmt.Errorf("testfixtures: could not cast record: not a map[interface{}]interface{}")
+	}
+
+	record := fixtureRecord{values: recordMap}
+	// The reserved keys are not inserted, so values not expected for them
+	// are reported, as they are likely meant for a column of the same name.
+	if tombstone, ok := recordMap[deleteKey]; ok {
+		if tombstone != true {
+			return fixtureRecord{}, fmt.Errorf("testfixtures: %s is reserved to delete records in Layers and must be true", deleteKey)
+		}
+		record.tombstone = true
+		delete(recordMap, deleteKey)
+	}
+
+	tags, ok := recordMap[tagsKey]
+	if !ok {
+		return record, nil
+	}
+	delete(recordMap, tagsKey)
+
+	switch tags := tags.(type) {
+	case string:
+		record.tags = []string{tags}
+	case []any:
+		for _, tag := range tags {
+			s, ok := tag.(string)
+			if !ok {
+				return fixtureRecord{}, fmt.Errorf("testfixtures: %s is reserved for the groups of records and must be a string or a list of strings", tagsKey)
+			}
+			record.tags = append(record.tags, s)
+		}
+	default:
+		return fixtureRecord{}, fmt.Errorf("testfixtures: %s is reserved for the groups of records and must be a string or a list of strings", tagsKey)
+	}
+	return record, nil
+}
+
+func (r *fixtureRecord) setPositions(node ast.Node) {
+	node = unwrapNode(node)
+	r.position = nodePosition(node)
+
+	values := mappingValues(node)
+	if len(values) == 0 {
+		return
+	}
+	r.position = nodePosition(values[0].Key)
+	r.columns = make(map[string]yamlPosition, len(values))
+	for _, kv := range values {
+		name := kv.Key.GetToken().Value
+		r.columns[name] = nodePosition(kv.Key)
+		r.order = append(r.order, name)
+	}
+}
+
+func unwrapNode(node ast.Node) ast.Node {
+	for {
+		switch n := node.(type) {
+		case *ast.AnchorNode:
+			node = n.Value
+		case *ast.TagNode:
+			node = n.Value
+		default:
+			return node
+		}
+	}
+}
+
+func mappingValues(node ast.Node) []*ast.MappingValueNode {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		return n.Values
+	case *ast.MappingValueNode:
+		return []*ast.MappingValueNode{n}
+	}
+	return nil
+}
+
+func nodePosition(node ast.Node) yamlPosition {
+	if node == nil || node.GetToken() == nil {
+		return yamlPosition{}
+	}
+	position := node.GetToken().Position
+	return yamlPosition{line: position.Line, column: position.Column}
+}
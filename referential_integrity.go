@@ -0,0 +1,300 @@
+package testfixtures
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// VerifyReferentialIntegrity makes Loader check, after inserting the
+// fixtures and before committing them, that the foreign keys of the fixture
+// tables, and of the other tables referencing the reloaded ones, reference
+// existing rows. As referential integrity is disabled while loading fixtures,
+// dangling references would otherwise only cause failures later in tests.
+// Load returns a *ReferentialIntegrityError listing every orphan row.
+//
+// Only valid for PostgreSQL, MySQL, SQLite and SQL Server. Returns an error
+// otherwise.
+func VerifyReferentialIntegrity() func(*Loader) error {
+	return func(l *Loader) error {
+		switch l.helper.(type) {
+		case *postgreSQL, *mySQL, *sqlite, *sqlserver:
+			l.verifyReferentialIntegrity = true
+		default:
+			return fmt.Errorf("testfixtures: VerifyReferentialIntegrity is only valid for PostgreSQL, MySQL, SQLite and SQL Server databases")
+		}
+		return nil
+	}
+}
+
+// ReferentialIntegrityError is returned by Loader.Load when fixtures
+// reference rows that don't exist, see VerifyReferentialIntegrity.
+type ReferentialIntegrityError struct {
+	Violations []ForeignKeyViolation
+}
+
+// ForeignKeyViolation is a row referencing a row that doesn't exist.
+type ForeignKeyViolation struct {
+	// Table is the table of the orphan row.
+	Table string
+	// Constraint is the name of the foreign key.
+	Constraint string
+	// Columns are the columns of the foreign key, and Values the values of
+	// the orphan row for them.
+	Columns []string
+	Values  []any
+	// ReferencedTable and ReferencedColumns are what the foreign key
+	// references.
+	ReferencedTable   string
+	ReferencedColumns []string
+
+	// Path, Index, Label and Line identify the fixture record of the orphan
+	// row. Path is empty if the row is not a fixture record, like rows kept
+	// with UseUpsert.
+	Path  string
+	Index int
+	Label string
+	Line  int
+}
+
+func (e *ReferentialIntegrityError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "testfixtures: %d rows reference missing rows", len(e.Violations))
+	for _, v := range e.Violations {
+		b.WriteString("\n\t")
+		if v.Path != "" {
+			fmt.Fprintf(&b, "%s:%d: ", v.Path, v.Line)
+		}
+		b.WriteString("record")
+		if v.Label != "" {
+			fmt.Fprintf(&b, " %q", v.Label)
+		} else if v.Path != "" {
+			fmt.Fprintf(&b, " (index %d)", v.Index)
+		}
+		fmt.Fprintf(
+			&b,
+			" of table %q: (%s) = (%s) not found in %s (%s)",
+			v.Table,
+			strings.Join(v.Columns, ", "),
+			formatValues(v.Values),
+			v.ReferencedTable,
+			strings.Join(v.ReferencedColumns, ", "),
+		)
+	}
+	return b.String()
+}
+
+// foreignKey is a foreign key of a table, with its columns in order.
+type foreignKey struct {
+	name              string
+	columns           []string
+	referencedTable   string
+	referencedColumns []string
+}
+
+// checkReferentialIntegrity checks the foreign keys of the tables of the
+// given files, and the foreign keys of the other tables referencing the
+// reloaded ones, as their rows may reference deleted rows. It returns a
+// *ReferentialIntegrityError for orphan rows.
+func (l *Loader) checkReferentialIntegrity(q shared.Querier, files []*fixtureFile, reloaded []string) error {
+	var (
+		violations []ForeignKeyViolation
+		// found are the records already matched with an orphan row, as
+		// many rows may have the same foreign key values.
+		found = make(map[recordKey]bool)
+	)
+	tables, err := l.referencingTables(q, files, reloaded)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		tableName := table.name
+		for _, fk := range table.foreignKeys {
+			orphans, err := l.orphanRows(q, tableName, fk)
+			if err != nil {
+				return err
+			}
+			for _, values := range orphans {
+				violation := ForeignKeyViolation{
+					Table:             tableName,
+					Constraint:        fk.name,
+					Columns:           fk.columns,
+					Values:            values,
+					ReferencedTable:   fk.referencedTable,
+					ReferencedColumns: fk.referencedColumns,
+				}
+				findOrphanRecord(&violation, files, found)
+				violations = append(violations, violation)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ReferentialIntegrityError{Violations: violations}
+	}
+	return nil
+}
+
+// tableForeignKeys are foreign keys of a table to check.
+type tableForeignKeys struct {
+	name        string
+	foreignKeys []foreignKey
+}
+
+// referencingTables returns the foreign keys to check: all the foreign keys
+// of the tables of the files, and the foreign keys of the other tables of
+// the database referencing a reloaded table.
+func (l *Loader) referencingTables(q shared.Querier, files []*fixtureFile, reloaded []string) ([]tableForeignKeys, error) {
+	var tables []tableForeignKeys
+	fixtureTables := groupByTable(files)
+	for _, table := range fixtureTables {
+		foreignKeys, err := l.helper.foreignKeys(q, table.name)
+		if err != nil {
+			return nil, fmt.Errorf("testfixtures: could not read the foreign keys of table %s: %w", table.name, err)
+		}
+		tables = append(tables, tableForeignKeys{table.name, foreignKeys})
+	}
+
+	names, err := l.helper.tableNames(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, tableName := range names {
+		isFixtureTable := slices.ContainsFunc(fixtureTables, func(table *fixtureTable) bool {
+			return sameTable(table.name, tableName)
+		})
+		if isFixtureTable || isInternalTable(tableName) {
+			continue
+		}
+		foreignKeys, err := l.helper.foreignKeys(q, tableName)
+		if err != nil {
+			return nil, fmt.Errorf("testfixtures: could not read the foreign keys of table %s: %w", tableName, err)
+		}
+		foreignKeys = slices.DeleteFunc(foreignKeys, func(fk foreignKey) bool {
+			return !slices.ContainsFunc(reloaded, func(table string) bool {
+				return sameTable(table, fk.referencedTable)
+			})
+		})
+		if len(foreignKeys) > 0 {
+			tables = append(tables, tableForeignKeys{tableName, foreignKeys})
+		}
+	}
+	return tables, nil
+}
+
+// sameTable returns whether two names may be the same table, one of them
+// being qualified by its schema and the other not.
+func sameTable(a, b string) bool {
+	return strings.EqualFold(a, b) ||
+		strings.HasSuffix(strings.ToLower(a), "."+strings.ToLower(b)) ||
+		strings.HasSuffix(strings.ToLower(b), "."+strings.ToLower(a))
+}
+
+// orphanRows returns the values of the foreign key columns of the rows
+// referencing a missing row. Rows with a null column are not checked, like
+// databases do.
+func (l *Loader) orphanRows(q shared.Querier, tableName string, fk foreignKey) ([][]any, error) {
+	var (
+		columns    = make([]string, 0, len(fk.columns))
+		conditions = make([]string, 0, len(fk.columns))
+		joins      = make([]string, 0, len(fk.columns))
+	)
+	for i, column := range fk.columns {
+		column = "c." + l.helper.quoteKeyword(column)
+		columns = append(columns, column)
+		conditions = append(conditions, column+" IS NOT NULL")
+		joins = append(joins, fmt.Sprintf("p.%s = %s", l.helper.quoteKeyword(fk.referencedColumns[i]), column))
+	}
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s c WHERE %s AND NOT EXISTS (SELECT 1 FROM %s p WHERE %s)",
+		strings.Join(columns, ", "),
+		l.helper.quoteKeyword(tableName),
+		strings.Join(conditions, " AND "),
+		l.helper.quoteKeyword(fk.referencedTable),
+		strings.Join(joins, " AND "),
+	)
+
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("testfixtures: could not check foreign key %s of table %s: %w", fk.name, tableName, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var orphans [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		orphans = append(orphans, values)
+	}
+	return orphans, rows.Err()
+}
+
+type recordKey struct {
+	constraint string
+	path       string
+	index      int
+}
+
+// findOrphanRecord fills the position of the fixture record of an orphan
+// row, found by the values of its foreign key columns.
+func findOrphanRecord(v *ForeignKeyViolation, files []*fixtureFile, found map[recordKey]bool) {
+	for _, file := range files {
+		if file.tableName() != v.Table {
+			continue
+		}
+		for _, insert := range file.insertSQLs {
+			key := recordKey{v.Constraint, file.path, insert.record.index}
+			if found[key] || !recordHasValues(insert.record, v.Columns, v.Values) {
+				continue
+			}
+			found[key] = true
+			v.Path = file.path
+			v.Index = insert.record.index
+			v.Label = insert.record.label
+			v.Line = insert.record.columnPosition(v.Columns[0]).line
+			return
+		}
+	}
+}
+
+func recordHasValues(record fixtureRecord, columns []string, values []any) bool {
+	for i, column := range columns {
+		value, ok := recordValue(record, column)
+		if !ok || fmt.Sprint(value) != fmt.Sprint(values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func recordValue(record fixtureRecord, column string) (any, bool) {
+	for name, value := range record.values {
+		if strings.EqualFold(name, column) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func formatValues(values []any) string {
+	s := make([]string, 0, len(values))
+	for _, value := range values {
+		s = append(s, fmt.Sprint(value))
+	}
+	return strings.Join(s, ", ")
+}
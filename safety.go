@@ -0,0 +1,188 @@
+package testfixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// safetyPolicy holds the checks run by Loader.EnsureTestDatabase, on top of
+// the name of the database.
+type safetyPolicy struct {
+	// namePattern and names are the allowed database names. When both are
+	// empty, the name must match testDatabaseRegexp.
+	namePattern *regexp.Regexp
+	names       []string
+
+	requiredEnv      string
+	requiredEnvValue string
+
+	requireLocalHost bool
+	allowedHosts     []string
+
+	markerTable string
+	maxTables   int
+
+	checks []func(*sql.DB) error
+}
+
+// TestDatabaseNamePattern sets the pattern the database name must match to
+// be considered a test database, instead of containing "test".
+func TestDatabaseNamePattern(pattern *regexp.Regexp) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.namePattern = pattern
+		return nil
+	}
+}
+
+// TestDatabaseNames sets the names of the databases considered test
+// databases, instead of the ones containing "test". It can be combined with
+// TestDatabaseNamePattern, in which case either must match.
+func TestDatabaseNames(names ...string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.names = append(l.safety.names, names...)
+		return nil
+	}
+}
+
+// RequireEnv refuses to load fixtures unless the given environment variable
+// is set to value, like TESTFIXTURES_ALLOW=1. Useful to make sure fixtures
+// are only loaded by the test suite, on machines where it's enabled.
+func RequireEnv(name, value string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.requiredEnv = name
+		l.safety.requiredEnvValue = value
+		return nil
+	}
+}
+
+// RequireLocalDatabase refuses to load fixtures into a database that is not
+// running on this machine, unless its host is one of allowedHosts. The host
+// is read from the database: the address the client is connected to on
+// PostgreSQL, and the name of the machine on MySQL and SQL Server. SQLite
+// databases are always local.
+//
+// Databases running in containers are seen as running on another host, so
+// their address or container name must be given in allowedHosts.
+//
+// Only supported by PostgreSQL, MySQL, SQLite and SQL Server. Loading
+// fixtures into other databases fails.
+func RequireLocalDatabase(allowedHosts ...string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.requireLocalHost = true
+		l.safety.allowedHosts = append(l.safety.allowedHosts, allowedHosts...)
+		return nil
+	}
+}
+
+// RequireMarkerTable refuses to load fixtures unless the database has the
+// given table, created on purpose in test databases only.
+func RequireMarkerTable(tableName string) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.markerTable = tableName
+		return nil
+	}
+}
+
+// MaxTables refuses to load fixtures into a database with more than n
+// tables, as test databases are expected to be small. The tables created by
+// testfixtures, like "testfixtures_checksums", are not counted.
+func MaxTables(n int) func(*Loader) error {
+	return func(l *Loader) error {
+		if n < 1 {
+			return fmt.Errorf("testfixtures: max tables must be at least 1, got %d", n)
+		}
+		l.safety.maxTables = n
+		return nil
+	}
+}
+
+// TestDatabaseCheck adds a check run by EnsureTestDatabase, which refuses to
+// load fixtures when it returns an error. It can be given multiple times.
+//
+// Only valid with databases given with Database.
+func TestDatabaseCheck(check func(*sql.DB) error) func(*Loader) error {
+	return func(l *Loader) error {
+		l.safety.checks = append(l.safety.checks, check)
+		return nil
+	}
+}
+
+func (p *safetyPolicy) isTestDatabaseName(dbName string) bool {
+	if p.namePattern == nil && len(p.names) == 0 {
+		return testDatabaseRegexp.MatchString(dbName)
+	}
+	if p.namePattern != nil && p.namePattern.MatchString(dbName) {
+		return true
+	}
+	return slices.Contains(p.names, dbName)
+}
+
+// isLocalHost returns whether host, as read from the database, is this
+// machine or one of the allowed hosts. An empty host is a connection through
+// a Unix socket or a file.
+func (p *safetyPolicy) isLocalHost(host string) bool {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	if hostname, err := os.Hostname(); err == nil && strings.EqualFold(host, hostname) {
+		return true
+	}
+	return slices.ContainsFunc(p.allowedHosts, func(allowed string) bool {
+		return strings.EqualFold(host, allowed)
+	})
+}
+
+// checkSafetyPolicy runs the checks of the safety policy other than the name
+// of the database.
+func (l *Loader) checkSafetyPolicy() error {
+	p := &l.safety
+
+	if p.requiredEnv != "" && os.Getenv(p.requiredEnv) != p.requiredEnvValue {
+		return fmt.Errorf("testfixtures: environment variable %s must be set to %q to load fixtures", p.requiredEnv, p.requiredEnvValue)
+	}
+
+	if p.requireLocalHost {
+		host, err := l.helper.databaseHost(l.conn)
+		if err != nil {
+			return fmt.Errorf("testfixtures: could not read the database host: %w", err)
+		}
+		if !p.isLocalHost(host) {
+			return fmt.Errorf(`testfixtures: database host "%s" is not local, allow it with RequireLocalDatabase`, host)
+		}
+	}
+
+	if p.markerTable != "" || p.maxTables > 0 {
+		tables, err := l.helper.tableNames(l.conn)
+		if err != nil {
+			return err
+		}
+		if p.markerTable != "" && !slices.ContainsFunc(tables, func(table string) bool {
+			return table == p.markerTable || strings.HasSuffix(table, "."+p.markerTable)
+		}) {
+			return fmt.Errorf(`testfixtures: marker table "%s" not found in the database`, p.markerTable)
+		}
+		// The tables created by testfixtures itself are not counted.
+		tables = slices.DeleteFunc(tables, isInternalTable)
+		if p.maxTables > 0 && len(tables) > p.maxTables {
+			return fmt.Errorf("testfixtures: database has %d tables, more than the %d allowed", len(tables), p.maxTables)
+		}
+	}
+
+	if len(p.checks) > 0 && l.database != nil && l.db == nil {
+		return fmt.Errorf("testfixtures: TestDatabaseCheck requires a database given with Database")
+	}
+	for _, check := range p.checks {
+		if err := check(l.db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
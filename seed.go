@@ -0,0 +1,380 @@
+package testfixtures
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// seedsTable is where the fixture files applied by Loader.Seed are recorded.
+const seedsTable = "testfixtures_seeds"
+
+// SeedFile is the seed status of a fixture file.
+type SeedFile struct {
+	Path string
+	// Hash is a hash of the content of the file.
+	Hash string
+	// AppliedAt is when the file was last applied. It's zero if the file
+	// was never applied.
+	AppliedAt time.Time
+	// Pending is true if the file was never applied, or changed since.
+	Pending bool
+}
+
+// seedKeys are the conditions matching the rows of the records of an
+// applied file by primary key, by table, so they can be deleted once the
+// file changed.
+type seedKeys map[string][]string
+
+// appliedSeed is a file recorded in the seeds table.
+type appliedSeed struct {
+	SeedFile
+	keys seedKeys
+}
+
+// DangerousSkipSeedDatabaseCheck makes Loader.Seed not check if the database
+// is a test database, as seeding is meant for development, staging and demo
+// databases. Unlike with DangerousSkipTestDatabaseCheck, Load still checks
+// it. Use with caution!
+func DangerousSkipSeedDatabaseCheck() func(*Loader) error {
+	return func(l *Loader) error {
+		l.skipSeedDatabaseCheck = true
+		return nil
+	}
+}
+
+// Seed applies the fixture files that were not applied yet, or changed since
+// they were, like migrations. It's meant for staging and demo environments,
+// where the data must be kept between runs.
+//
+// Applied files are recorded by path and hash in the "testfixtures_seeds"
+// table, along with the primary keys of their records. Tables are never
+// cleaned: the records of the pending files are inserted. The rows of the
+// previous version of a changed file are first deleted by primary key,
+// unless UseUpsert is given, in which case its records are updated and only
+// the rows of the records no longer in the file are deleted. The primary
+// keys are read from the database, unless given with PrimaryKey.
+//
+// The database must pass the test database check, unless it's skipped with
+// DangerousSkipSeedDatabaseCheck, or DangerousSkipTestDatabaseCheck which
+// also skips it when loading fixtures. Only valid for PostgreSQL, MySQL,
+// SQLite and SQL Server.
+//
+// Returns the files that were applied.
+func (l *Loader) Seed() ([]SeedFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.skipTestDatabaseCheck && !l.skipSeedDatabaseCheck {
+		if err := l.ensureTestDatabase(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.checkSeedSupported(); err != nil {
+		return nil, err
+	}
+	applied, err := l.readSeeds()
+	if err != nil {
+		return nil, err
+	}
+	var pending []SeedFile
+	for _, file := range l.seedStatus(applied) {
+		if file.Pending {
+			pending = append(pending, file)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	if err := l.createSeedsTable(); err != nil {
+		return nil, err
+	}
+
+	var (
+		table       = l.helper.quoteKeyword(seedsTable)
+		paramType   = l.helper.paramType()
+		deleteQuery = fmt.Sprintf("DELETE FROM %s WHERE file_path = %s", table, paramType.placeholder(1))
+		insertQuery = fmt.Sprintf(
+			"INSERT INTO %s (file_path, content_hash, applied_at, record_keys) VALUES (%s, %s, %s, %s)",
+			table,
+			paramType.placeholder(1),
+			paramType.placeholder(2),
+			paramType.placeholder(3),
+			paramType.placeholder(4),
+		)
+		appliedAt = time.Now().UTC().Truncate(time.Second)
+		elapsed   time.Duration
+	)
+	err = l.tracer.runStep(StepConstraints, &elapsed, func() error {
+		return l.disableReferentialIntegrity(func(tx shared.Querier) error {
+			return l.tracer.runStep(StepInsert, &elapsed, func() error {
+				statements := l.newStatementCache(tx)
+				keys, err := l.insertSeedFiles(tx, statements, pending, applied)
+				if closeErr := statements.close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					return err
+				}
+
+				for _, file := range pending {
+					recordKeys, err := json.Marshal(keys[file.Path])
+					if err != nil {
+						return err
+					}
+					if _, err := tx.Exec(deleteQuery, file.Path); err != nil {
+						return fmt.Errorf("testfixtures: could not record seed %s: %w", file.Path, err)
+					}
+					if _, err := tx.Exec(insertQuery, file.Path, file.Hash, appliedAt.Format(time.RFC3339), string(recordKeys)); err != nil {
+						return fmt.Errorf("testfixtures: could not record seed %s: %w", file.Path, err)
+					}
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := l.tracer.runStep(StepResetSequences, &elapsed, func() error {
+		return l.helper.resetSequences(l.conn)
+	}); err != nil {
+		return nil, err
+	}
+
+	for i := range pending {
+		pending[i].AppliedAt = appliedAt
+		pending[i].Pending = false
+	}
+	return pending, nil
+}
+
+// insertSeedFiles inserts the records of the pending files, after deleting
+// the rows of the previous version of the changed ones. Returns the keys of
+// the records of each file.
+func (l *Loader) insertSeedFiles(tx shared.Querier, statements *statementCache, pending []SeedFile, applied map[string]appliedSeed) (map[string]seedKeys, error) {
+	keys := make(map[string]seedKeys, len(pending))
+	for _, seed := range pending {
+		var files []*fixtureFile
+		for _, file := range l.fixturesFiles {
+			if file.path == seed.Path {
+				files = append(files, file)
+			}
+		}
+
+		keys[seed.Path] = make(seedKeys)
+		for _, file := range files {
+			tableKeys, hasPrimaryKey, err := l.seedRecordKeys(file)
+			if err != nil {
+				return nil, err
+			}
+			if _, changed := applied[seed.Path]; changed && !hasPrimaryKey && !l.upsert {
+				return nil, fmt.Errorf("testfixtures: table %s has no primary key to delete the records of the changed file %s, set it with the PrimaryKey option or use UseUpsert", file.tableName(), file.path)
+			}
+			keys[seed.Path][file.tableName()] = append(keys[seed.Path][file.tableName()], tableKeys...)
+		}
+
+		if previous, changed := applied[seed.Path]; changed {
+			if err := l.deleteSeedRows(tx, seed.Path, previous.keys, keys[seed.Path]); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			if _, err := l.insertFile(tx, statements, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// deleteSeedRows deletes the rows of the previous version of a changed file,
+// so its records can be inserted again. With UseUpsert, the rows of the
+// records still in the file are updated instead.
+func (l *Loader) deleteSeedRows(tx shared.Querier, path string, previous, current seedKeys) error {
+	for _, tableName := range slices.Sorted(maps.Keys(previous)) {
+		kept := make(map[string]bool)
+		if l.upsert {
+			for _, condition := range current[tableName] {
+				kept[condition] = true
+			}
+		}
+		for _, condition := range previous[tableName] {
+			if kept[condition] {
+				continue
+			}
+			query := fmt.Sprintf("DELETE FROM %s WHERE %s", l.helper.quoteKeyword(tableName), condition)
+			if _, err := tx.Exec(query); err != nil {
+				return fmt.Errorf("testfixtures: could not delete the records of the changed file %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// seedRecordKeys returns the conditions matching the rows of the records of
+// a file by primary key, and whether its table has one. Records without a
+// value for the primary key are left out, as their key is generated by the
+// database.
+func (l *Loader) seedRecordKeys(file *fixtureFile) ([]string, bool, error) {
+	primaryKey, err := l.primaryKey(file.tableName())
+	if err != nil || len(primaryKey) == 0 {
+		return nil, false, err
+	}
+
+	var keys []string
+	for _, insert := range file.insertSQLs {
+		conditions := make([]string, 0, len(primaryKey))
+		for _, column := range primaryKey {
+			i := slices.IndexFunc(insert.columns, func(c string) bool {
+				return strings.EqualFold(c, l.helper.quoteKeyword(column))
+			})
+			if i < 0 {
+				break
+			}
+			literal, ok := insert.values[i].(rawSQL)
+			if !ok {
+				value, err := l.helper.sqlLiteral(insert.values[i])
+				if err != nil {
+					return nil, true, err
+				}
+				literal = rawSQL(value)
+			}
+			conditions = append(conditions, fmt.Sprintf("%s = %s", insert.columns[i], literal))
+		}
+		if len(conditions) == len(primaryKey) {
+			keys = append(keys, strings.Join(conditions, " AND "))
+		}
+	}
+	return keys, true, nil
+}
+
+// SeedStatus returns the seed status of each fixture file, in the order they
+// were given. See Loader.Seed.
+func (l *Loader) SeedStatus() ([]SeedFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.checkSeedSupported(); err != nil {
+		return nil, err
+	}
+	applied, err := l.readSeeds()
+	if err != nil {
+		return nil, err
+	}
+	return l.seedStatus(applied), nil
+}
+
+func (l *Loader) checkSeedSupported() error {
+	switch l.helper.(type) {
+	case *postgreSQL, *mySQL, *sqlite, *sqlserver:
+		return nil
+	}
+	return fmt.Errorf("testfixtures: Seed is only valid for PostgreSQL, MySQL, SQLite and SQL Server databases")
+}
+
+// seedStatus returns the seed status of each fixture file, given the applied
+// ones.
+func (l *Loader) seedStatus(applied map[string]appliedSeed) []SeedFile {
+	var files []SeedFile
+	for _, file := range l.seedFiles() {
+		if seed, ok := applied[file.Path]; ok {
+			file.AppliedAt = seed.AppliedAt
+			file.Pending = seed.Hash != file.Hash
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// seedFiles returns the fixture files by path, as a file with multiple tables
+// is split in a fixture file per table.
+func (l *Loader) seedFiles() []SeedFile {
+	var (
+		files  []SeedFile
+		hashes = make(map[string][]byte)
+	)
+	for _, file := range l.fixturesFiles {
+		if _, ok := hashes[file.path]; !ok {
+			files = append(files, SeedFile{Path: file.path, Pending: true})
+		}
+		h := sha256.New()
+		h.Write(hashes[file.path])
+		h.Write(file.content)
+		file.writeChanges(h)
+		hashes[file.path] = h.Sum(nil)
+	}
+	for i := range files {
+		files[i].Hash = hex.EncodeToString(hashes[files[i].Path])
+	}
+	return files
+}
+
+func (l *Loader) createSeedsTable() error {
+	// The keys of a file may not fit in a TEXT column on MySQL.
+	keysType := "TEXT"
+	switch l.helper.(type) {
+	case *mySQL:
+		keysType = "LONGTEXT"
+	case *sqlserver:
+		keysType = "NVARCHAR(MAX)"
+	}
+	createQuery := l.helper.createTableIfNotExistsQuery(
+		l.helper.quoteKeyword(seedsTable),
+		"file_path VARCHAR(255) NOT NULL PRIMARY KEY, content_hash VARCHAR(64) NOT NULL, applied_at VARCHAR(64) NOT NULL, record_keys "+keysType+" NOT NULL",
+	)
+	if _, err := l.conn.Exec(createQuery); err != nil {
+		return fmt.Errorf("testfixtures: could not create seeds table: %w", err)
+	}
+	return nil
+}
+
+// readSeeds returns the applied files by path. None were applied if the
+// seeds table doesn't exist.
+func (l *Loader) readSeeds() (map[string]appliedSeed, error) {
+	exists, err := internalTableExists(l.conn, l.helper, seedsTable)
+	if err != nil {
+		return nil, err
+	}
+	seeds := make(map[string]appliedSeed)
+	if !exists {
+		return seeds, nil
+	}
+
+	rows, err := l.conn.Query(fmt.Sprintf("SELECT file_path, content_hash, applied_at, record_keys FROM %s", l.helper.quoteKeyword(seedsTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var (
+			seed                  appliedSeed
+			appliedAt, recordKeys string
+		)
+		if err = rows.Scan(&seed.Path, &seed.Hash, &appliedAt, &recordKeys); err != nil {
+			return nil, err
+		}
+		if seed.AppliedAt, err = time.Parse(time.RFC3339, appliedAt); err != nil {
+			return nil, fmt.Errorf("testfixtures: invalid seed date %q: %w", appliedAt, err)
+		}
+		if err = json.Unmarshal([]byte(recordKeys), &seed.keys); err != nil {
+			return nil, fmt.Errorf("testfixtures: invalid record keys of seed %s: %w", seed.Path, err)
+		}
+		seeds[seed.Path] = seed
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
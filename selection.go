@@ -0,0 +1,133 @@
+package testfixtures
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LoadTables loads the fixtures of the given tables only, leaving the other
+// tables untouched. It allows tests to share a Loader, and the database
+// metadata it read when it was created, while only paying for the tables
+// they need.
+//
+// Tables referencing the loaded ones are not reloaded, even if their records
+// were deleted by ON DELETE CASCADE.
+//
+// The statements are run with ctx, so the load fails and is rolled back once
+// it's canceled. The same goes for LoadGroups.
+func (l *Loader) LoadTables(ctx context.Context, tables ...string) error {
+	if len(tables) == 0 {
+		return errNothingToLoad
+	}
+	files := l.selectFiles(func(file *fixtureFile, _ fixtureRecord) bool {
+		return slices.Contains(tables, file.tableName())
+	})
+	for _, table := range tables {
+		if !slices.ContainsFunc(files, func(file *fixtureFile) bool { return file.tableName() == table }) {
+			return fmt.Errorf(`testfixtures: no fixtures for table "%s"`, table)
+		}
+	}
+
+	_, err := l.load(ctx, files)
+	return err
+}
+
+// LoadGroups loads the fixture records belonging to any of the given groups,
+// leaving the other tables untouched.
+//
+// A fixture file belongs to the group named after its directory, so all the
+// files of "fixtures/billing" are in the "billing" group. A record can also be
+// added to groups with the "_tags" key, which is not inserted:
+//
+//	john:
+//	  name: John
+//	  _tags: [billing, admin]
+//
+// Tables loaded with only some of their records are reloaded entirely by the
+// next call to Loader.Load.
+func (l *Loader) LoadGroups(ctx context.Context, groups ...string) error {
+	if len(groups) == 0 {
+		return errNothingToLoad
+	}
+	found := make(map[string]bool, len(groups))
+	files := l.selectFiles(func(file *fixtureFile, record fixtureRecord) bool {
+		selected := false
+		for _, group := range groups {
+			if group == file.group() || slices.Contains(record.tags, group) {
+				found[group] = true
+				selected = true
+			}
+		}
+		return selected
+	})
+	for _, group := range groups {
+		if !found[group] {
+			return fmt.Errorf(`testfixtures: no fixtures in group "%s"`, group)
+		}
+	}
+
+	_, err := l.load(ctx, files)
+	return err
+}
+
+// group returns the group of the file, which is the name of its directory.
+func (f *fixtureFile) group() string {
+	return filepath.Base(filepath.Dir(f.path))
+}
+
+// selectFiles returns copies of the fixture files holding only the records
+// for which selected returns true. Files without any selected record are
+// left out.
+func (l *Loader) selectFiles(selected func(*fixtureFile, fixtureRecord) bool) []*fixtureFile {
+	var (
+		files   []*fixtureFile
+		partial = make(map[string]bool)
+		keys    = make(map[string]*strings.Builder)
+	)
+	for _, file := range l.fixturesFiles {
+		tableName := file.tableName()
+		if keys[tableName] == nil {
+			keys[tableName] = &strings.Builder{}
+		}
+		key := keys[tableName]
+		fmt.Fprintf(key, "%s:", file.path)
+
+		var insertSQLs []insertSQL
+		for _, insert := range file.insertSQLs {
+			if selected(file, insert.record) {
+				insertSQLs = append(insertSQLs, insert)
+				key.WriteString(strconv.Itoa(insert.record.index))
+				key.WriteByte(',')
+			}
+		}
+		key.WriteByte(';')
+
+		if len(insertSQLs) != len(file.insertSQLs) {
+			partial[tableName] = true
+		}
+		if len(insertSQLs) == 0 {
+			continue
+		}
+
+		selectedFile := *file
+		selectedFile.insertSQLs = insertSQLs
+		files = append(files, &selectedFile)
+	}
+
+	// The selection identifies the records loaded on each table, so a table
+	// loaded with other records is reloaded.
+	for _, file := range files {
+		tableName := file.tableName()
+		if partial[tableName] {
+			hash := sha256.Sum256([]byte(keys[tableName].String()))
+			file.selection = hex.EncodeToString(hash[:])
+		}
+	}
+	return files
+}
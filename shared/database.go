@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Database is a database accessed without database/sql, like a pgx pool,
+// given to Loader with testfixtures.Connection.
+type Database interface {
+	Querier
+	Begin() (Transaction, error)
+	// Conn returns a single connection of the database, used to hold locks.
+	Conn(ctx context.Context) (Conn, error)
+	// WithContext returns the database running its statements, and the
+	// ones of its transactions, with ctx.
+	WithContext(ctx context.Context) Database
+}
+
+// Transaction is a transaction of a Database.
+type Transaction interface {
+	Querier
+	Prepare(query string) (Statement, error)
+	Commit() error
+	Rollback() error
+}
+
+// Statement is a prepared statement of a Transaction.
+type Statement interface {
+	Exec(args ...any) (sql.Result, error)
+	Close() error
+}
+
+// Conn is a single connection of a Database.
+type Conn interface {
+	Querier
+	Close() error
+	// WithContext returns the connection running its statements with ctx.
+	WithContext(ctx context.Context) Conn
+}
+
+// Batcher is implemented by transactions able to run many statements in a
+// single round trip. ExecBatch runs the statements in order, stopping at the
+// first error, and returns the number of statements that succeeded.
+type Batcher interface {
+	ExecBatch(queries []string, args [][]any) (succeeded int, err error)
+}
+
+// Copier is implemented by transactions able to bulk insert rows, like with
+// COPY on PostgreSQL. CopyFrom returns the number of rows inserted.
+type Copier interface {
+	CopyFrom(tableName string, columns []string, rows [][]any) (int64, error)
+}
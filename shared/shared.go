@@ -2,6 +2,7 @@
 package shared
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -11,6 +12,66 @@ type Queryable interface {
 	QueryRow(string, ...any) *sql.Row
 }
 
+// Querier is like Queryable, but doesn't depend on database/sql, so it can be
+// implemented by other drivers, like pgx. Use SQL to adapt a Queryable to it.
+type Querier interface {
+	Exec(string, ...any) (sql.Result, error)
+	Query(string, ...any) (Rows, error)
+	QueryRow(string, ...any) Row
+}
+
+// Rows are the rows returned by Querier.Query. Implemented by *sql.Rows.
+type Rows interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(...any) error
+	Err() error
+	Close() error
+}
+
+// Row is the row returned by Querier.QueryRow. Implemented by *sql.Row.
+type Row interface {
+	Scan(...any) error
+}
+
+// SQL adapts a *sql.DB, *sql.Tx or *sql.Conn to Querier.
+func SQL(q SQLQueryable) Querier {
+	return SQLContext(context.Background(), q)
+}
+
+// SQLContext is like SQL, but runs the statements with ctx.
+func SQLContext(ctx context.Context, q SQLQueryable) Querier {
+	return sqlQueryable{q, ctx}
+}
+
+// SQLQueryable is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type SQLQueryable interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+type sqlQueryable struct {
+	q   SQLQueryable
+	ctx context.Context
+}
+
+func (q sqlQueryable) Exec(query string, args ...any) (sql.Result, error) {
+	return q.q.ExecContext(q.ctx, query, args...)
+}
+
+func (q sqlQueryable) Query(query string, args ...any) (Rows, error) {
+	rows, err := q.q.QueryContext(q.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (q sqlQueryable) QueryRow(query string, args ...any) Row {
+	return q.q.QueryRowContext(q.ctx, query, args...)
+}
+
 type SpannerConstraint struct {
 	TableName        string
 	ConstraintName   string
@@ -21,19 +82,32 @@ type SpannerConstraint struct {
 }
 
 func GetConstraints(q Queryable) (map[string][]SpannerConstraint, error) {
-	var constraints = make(map[string][]SpannerConstraint)
+	rows, err := q.Query(SpannerConstraintsQuery)
+	if err != nil {
+		return nil, err
+	}
+	return scanConstraints(rows)
+}
 
+// QueryConstraints is like GetConstraints, for a Querier.
+func QueryConstraints(q Querier) (map[string][]SpannerConstraint, error) {
 	rows, err := q.Query(SpannerConstraintsQuery)
 	if err != nil {
 		return nil, err
 	}
+	return scanConstraints(rows)
+}
+
+func scanConstraints(rows Rows) (map[string][]SpannerConstraint, error) {
+	var constraints = make(map[string][]SpannerConstraint)
+
 	defer func() {
 		_ = rows.Close()
 	}()
 
 	for rows.Next() {
 		var constraint SpannerConstraint
-		if err = rows.Scan(
+		if err := rows.Scan(
 			&constraint.TableName,
 			&constraint.ConstraintName,
 			&constraint.ColumnName,
@@ -49,7 +123,7 @@ func GetConstraints(q Queryable) (map[string][]SpannerConstraint, error) {
 		}
 		constraints[constraint.ConstraintName] = append(constraints[constraint.ConstraintName], constraint)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return constraints, nil
@@ -1,10 +1,13 @@
 package testfixtures
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
+	"maps"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 	"golang.org/x/sync/errgroup"
@@ -16,9 +19,10 @@ type spanner struct {
 	cleanTableFn          func(string) string
 	constraints           map[string][]shared.SpannerConstraint
 	tablesWithJSONColumns map[string]map[string]bool
+	tablesChecksum        map[string]string
 }
 
-func (h *spanner) init(db *sql.DB) error {
+func (h *spanner) init(db shared.Querier) error {
 	if h.cleanTableFn == nil {
 		h.cleanTableFn = func(tableName string) string {
 			return fmt.Sprintf("DELETE FROM %s WHERE true;", tableName)
@@ -28,7 +32,7 @@ func (h *spanner) init(db *sql.DB) error {
 	var grp errgroup.Group
 	grp.Go(func() error {
 		var err error
-		h.constraints, err = shared.GetConstraints(db)
+		h.constraints, err = shared.QueryConstraints(db)
 		return err
 	})
 	grp.Go(func() error {
@@ -50,11 +54,11 @@ func (*spanner) quoteKeyword(str string) string {
 	return str
 }
 
-func (*spanner) databaseName(q shared.Queryable) (string, error) {
+func (*spanner) databaseName(q shared.Querier) (string, error) {
 	return "", errors.New("could not determine database name. Please skip the test database check")
 }
 
-func (h *spanner) tableNames(q shared.Queryable) ([]string, error) {
+func (h *spanner) tableNames(q shared.Querier) ([]string, error) {
 	query := `
 		SELECT TABLE_NAME
 		FROM INFORMATION_SCHEMA.TABLES
@@ -83,10 +87,14 @@ func (h *spanner) tableNames(q shared.Queryable) ([]string, error) {
 	return tables, nil
 }
 
-func (h *spanner) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *spanner) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	return h.dropAndRecreateConstraints(db, loadFn)
 }
 
+func (h *spanner) restoreReferentialIntegrityStatements() []string {
+	return h.addConstraintsStatements()
+}
+
 func (h *spanner) cleanTableQuery(tableName string) string {
 	if h.cleanTableFn == nil {
 		return h.baseHelper.cleanTableQuery(tableName)
@@ -95,49 +103,17 @@ func (h *spanner) cleanTableQuery(tableName string) string {
 	return h.cleanTableFn(tableName)
 }
 
-func (h *spanner) dropAndRecreateConstraints(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *spanner) dropAndRecreateConstraints(db database, loadFn loadFunction) (err error) {
 	defer func() {
 		// Re-create constraints again after load
-		for key := range h.constraints {
-			var lengthConstraints = len(h.constraints[key])
-			var orderedConstraints = make([]shared.SpannerConstraint, lengthConstraints)
-
-			for _, constraint := range h.constraints[key] {
-				orderedConstraints[constraint.Position-1] = constraint
-			}
-
-			var columnName = orderedConstraints[0].ColumnName
-			for i := 1; i < lengthConstraints; i++ {
-				columnName = strings.Join([]string{columnName, orderedConstraints[i].ColumnName}, ", ")
-			}
-
-			var referencedColumn = orderedConstraints[0].ReferencedColumn
-			for i := 1; i < lengthConstraints; i++ {
-				referencedColumn = strings.Join([]string{referencedColumn, orderedConstraints[i].ReferencedColumn}, ", ")
-			}
-
-			cmd := fmt.Sprintf(
-				`ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)`,
-				orderedConstraints[0].TableName,
-				orderedConstraints[0].ConstraintName,
-				columnName,
-				orderedConstraints[0].ReferencedTable,
-				referencedColumn,
-			)
-
-			if _, err2 := db.Exec(cmd); err2 != nil && err == nil {
-				err = err2
+		for _, cmd := range h.addConstraintsStatements() {
+			if _, err2 := db.Exec(cmd); err2 != nil {
+				err = withRestoreError(err, err2)
 			}
 		}
 	}()
 
-	for key := range h.constraints {
-		constraints := h.constraints[key]
-		cmd := fmt.Sprintf(
-			`ALTER TABLE %s DROP CONSTRAINT %s`,
-			constraints[0].TableName,
-			constraints[0].ConstraintName,
-		)
+	for _, cmd := range h.dropConstraintsStatements() {
 		if _, err := db.Exec(cmd); err != nil {
 			fmt.Println("error dropping constraint", err)
 			return err
@@ -157,7 +133,68 @@ func (h *spanner) dropAndRecreateConstraints(db *sql.DB, loadFn loadFunction) (e
 	return tx.Commit()
 }
 
-func (h *spanner) buildTableJSONColumns(q shared.Queryable) (map[string]map[string]bool, error) {
+func (h *spanner) dropConstraintsStatements() []string {
+	statements := make([]string, 0, len(h.constraints))
+	for _, key := range slices.Sorted(maps.Keys(h.constraints)) {
+		constraints := h.constraints[key]
+		statements = append(statements, fmt.Sprintf(
+			`ALTER TABLE %s DROP CONSTRAINT %s`,
+			constraints[0].TableName,
+			constraints[0].ConstraintName,
+		))
+	}
+	return statements
+}
+
+func (h *spanner) addConstraintsStatements() []string {
+	statements := make([]string, 0, len(h.constraints))
+	for _, key := range slices.Sorted(maps.Keys(h.constraints)) {
+		var lengthConstraints = len(h.constraints[key])
+		var orderedConstraints = make([]shared.SpannerConstraint, lengthConstraints)
+
+		for _, constraint := range h.constraints[key] {
+			orderedConstraints[constraint.Position-1] = constraint
+		}
+
+		var columnName = orderedConstraints[0].ColumnName
+		for i := 1; i < lengthConstraints; i++ {
+			columnName = strings.Join([]string{columnName, orderedConstraints[i].ColumnName}, ", ")
+		}
+
+		var referencedColumn = orderedConstraints[0].ReferencedColumn
+		for i := 1; i < lengthConstraints; i++ {
+			referencedColumn = strings.Join([]string{referencedColumn, orderedConstraints[i].ReferencedColumn}, ", ")
+		}
+
+		statements = append(statements, fmt.Sprintf(
+			`ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)`,
+			orderedConstraints[0].TableName,
+			orderedConstraints[0].ConstraintName,
+			columnName,
+			orderedConstraints[0].ReferencedTable,
+			referencedColumn,
+		))
+	}
+	return statements
+}
+
+func (h *spanner) disableReferentialIntegrityScript() (before, after []string) {
+	return h.dropConstraintsStatements(), h.addConstraintsStatements()
+}
+
+func (h *spanner) sqlLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteStringWithBackslashes(v), nil
+	case []byte:
+		return fmt.Sprintf("FROM_HEX('%x')", v), nil
+	case time.Time:
+		return fmt.Sprintf("TIMESTAMP '%s'", v.Format(time.RFC3339Nano)), nil
+	}
+	return h.baseHelper.sqlLiteral(value)
+}
+
+func (h *spanner) buildTableJSONColumns(q shared.Querier) (map[string]map[string]bool, error) {
 	const query = `
 		SELECT table_name, column_name, spanner_type
 		FROM INFORMATION_SCHEMA.COLUMNS
@@ -189,7 +226,7 @@ func (h *spanner) buildTableJSONColumns(q shared.Queryable) (map[string]map[stri
 	return tablesWithJSONColumns, rows.Err()
 }
 
-func (h *spanner) buildInsertSQL(q shared.Queryable, tableName string, columns, values []string) (string, error) {
+func (h *spanner) buildInsertSQL(q shared.Querier, tableName string, columns, values []string) (string, error) {
 	if jsonColumns, tableExists := h.tablesWithJSONColumns[tableName]; tableExists {
 		for i, column := range columns {
 			if jsonColumns[column] {
@@ -207,3 +244,52 @@ func (h *spanner) buildInsertSQL(q shared.Queryable, tableName string, columns,
 
 	return h.baseHelper.buildInsertSQL(q, tableName, columns, values)
 }
+
+func (h *spanner) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	return isChecksumModified(q, h.tablesChecksum, tableName, h.getChecksum)
+}
+
+func (h *spanner) computeTablesChecksum(q shared.Querier, tables []string) error {
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, tables, h.getChecksum)
+	return err
+}
+
+func (h *spanner) getChecksum(q shared.Querier, tableName string) (string, error) {
+	return rowsChecksum(q, fmt.Sprintf("SELECT * FROM %s", h.quoteKeyword(tableName)))
+}
+
+var (
+	spannerNotNullColumnRegexp = regexp.MustCompile(`(?:NOT NULL column|null value for column): (?:\w+\.)?(\w+)`)
+	spannerUnknownColumnRegexp = regexp.MustCompile(`Column (\w+) is not present in table`)
+	spannerTypeColumnRegexp    = regexp.MustCompile(`cannot be (?:inserted|assigned) (?:into|to) column (\w+)`)
+)
+
+// classifyInsertError relies on the error messages, as the gRPC codes are
+// shared by many kinds of errors.
+func (*spanner) classifyInsertError(err error) (InsertErrorCause, string) {
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "already exists"):
+		return CauseUniqueViolation, ""
+	case strings.Contains(message, "Foreign key constraint"):
+		return CauseForeignKeyViolation, ""
+	case spannerNotNullColumnRegexp.MatchString(message):
+		return CauseNotNullViolation, submatch(spannerNotNullColumnRegexp, message)
+	case spannerUnknownColumnRegexp.MatchString(message):
+		return CauseUnknownColumn, submatch(spannerUnknownColumnRegexp, message)
+	case spannerTypeColumnRegexp.MatchString(message):
+		return CauseTypeMismatch, submatch(spannerTypeColumnRegexp, message)
+	}
+	return CauseUnknown, ""
+}
+
+// buildUpsertSQL doesn't need the primary key, as INSERT OR UPDATE always
+// applies to it.
+func (h *spanner) buildUpsertSQL(q shared.Querier, tableName string, _, columns, values []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	return "INSERT OR UPDATE " + strings.TrimPrefix(insert, "INSERT "), nil
+}
@@ -1,21 +1,28 @@
 package testfixtures
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
 
 type sqlite struct {
 	baseHelper
+	changeTracking
+
+	tablesChecksum map[string]string
 }
 
 func (*sqlite) paramType() ParamType {
 	return ParamTypeQuestion
 }
 
-func (*sqlite) databaseName(q shared.Queryable) (string, error) {
+func (*sqlite) databaseName(q shared.Querier) (string, error) {
 	var seq int
 	var main, dbName string
 	err := q.QueryRow("PRAGMA database_list").Scan(&seq, &main, &dbName)
@@ -26,7 +33,11 @@ func (*sqlite) databaseName(q shared.Queryable) (string, error) {
 	return dbName, nil
 }
 
-func (*sqlite) tableNames(q shared.Queryable) ([]string, error) {
+func (*sqlite) databaseHost(_ shared.Querier) (string, error) {
+	return "", nil
+}
+
+func (*sqlite) tableNames(q shared.Querier) ([]string, error) {
 	query := `
 		SELECT name
 		FROM sqlite_master
@@ -54,7 +65,7 @@ func (*sqlite) tableNames(q shared.Queryable) ([]string, error) {
 	return tables, nil
 }
 
-func (*sqlite) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
+func (*sqlite) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	defer func() {
 		if _, err2 := db.Exec("PRAGMA defer_foreign_keys = OFF"); err2 != nil && err == nil {
 			err = err2
@@ -77,3 +88,174 @@ func (*sqlite) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err
 
 	return tx.Commit()
 }
+
+func (*sqlite) disableReferentialIntegrityScript() (before, after []string) {
+	return []string{"BEGIN TRANSACTION", "PRAGMA defer_foreign_keys = ON"}, []string{"COMMIT"}
+}
+
+func (h *sqlite) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	if h.trackChanges {
+		return h.changeTracking.isTableModified(q, tableName, h.tableVersion)
+	}
+	return isChecksumModified(q, h.tablesChecksum, tableName, h.getChecksum)
+}
+
+func (h *sqlite) computeTablesChecksum(q shared.Querier, tables []string) error {
+	if h.trackChanges {
+		return h.snapshotVersions(q, h.createChangeTrackingTriggers, tables, h.tableVersion)
+	}
+
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, tables, h.getChecksum)
+	return err
+}
+
+func (h *sqlite) getChecksum(q shared.Querier, tableName string) (string, error) {
+	return rowsChecksum(q, fmt.Sprintf("SELECT * FROM %s", h.quoteKeyword(tableName)))
+}
+
+func (h *sqlite) createChangeTrackingTriggers(q shared.Querier) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name TEXT PRIMARY KEY,
+			version INTEGER NOT NULL DEFAULT 0
+		)
+	`, changeTrackingTable)
+	if _, err := q.Exec(query); err != nil {
+		return err
+	}
+
+	tables, err := h.tableNames(q)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if isInternalTable(table) || strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			query := fmt.Sprintf(`
+				CREATE TRIGGER IF NOT EXISTS %s AFTER %s ON %s
+				BEGIN
+					INSERT OR IGNORE INTO %s (table_name) VALUES (%s);
+					UPDATE %s SET version = version + 1 WHERE table_name = %s;
+				END
+			`,
+				h.quoteKeyword("testfixtures_"+table+"_"+strings.ToLower(event)),
+				event,
+				h.quoteKeyword(table),
+				changeTrackingTable, quoteString(table),
+				changeTrackingTable, quoteString(table),
+			)
+			if _, err := q.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *sqlite) tableVersion(q shared.Querier, tableName string) (string, int64, error) {
+	query := fmt.Sprintf("SELECT COALESCE((SELECT version FROM %s WHERE table_name = ?), 0)", changeTrackingTable)
+	var version int64
+	if err := q.QueryRow(query, tableName).Scan(&version); err != nil {
+		return "", 0, err
+	}
+	return tableName, version, nil
+}
+
+var (
+	sqliteConstraintColumnRegexp = regexp.MustCompile(`constraint failed: [^.\s]+\.([^,\s]+)`)
+	sqliteUnknownColumnRegexp    = regexp.MustCompile(`has no column named (\S+)`)
+)
+
+// classifyInsertError relies on the error messages, which are the same for
+// all SQLite drivers.
+func (*sqlite) classifyInsertError(err error) (InsertErrorCause, string) {
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "UNIQUE constraint failed"):
+		return CauseUniqueViolation, submatch(sqliteConstraintColumnRegexp, message)
+	case strings.Contains(message, "FOREIGN KEY constraint failed"):
+		return CauseForeignKeyViolation, ""
+	case strings.Contains(message, "NOT NULL constraint failed"):
+		return CauseNotNullViolation, submatch(sqliteConstraintColumnRegexp, message)
+	case strings.Contains(message, "has no column named"):
+		return CauseUnknownColumn, submatch(sqliteUnknownColumnRegexp, message)
+	case strings.Contains(message, "datatype mismatch"):
+		return CauseTypeMismatch, ""
+	}
+	return CauseUnknown, ""
+}
+
+func (h *sqlite) primaryKey(q shared.Querier, tableName string) ([]string, error) {
+	return queryStrings(q, "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", tableName)
+}
+
+// lock starts a write transaction, which other connections wait for up to
+// the busy timeout.
+func (*sqlite) lock(_ context.Context, conn shared.Conn, _ string, timeout time.Duration) error {
+	var busyTimeout int64
+	if err := conn.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", timeout.Milliseconds())); err != nil {
+		return err
+	}
+	_, err := conn.Exec("BEGIN IMMEDIATE")
+	if _, err2 := conn.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout)); err2 != nil && err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("testfixtures: could not acquire the database lock: %w", err)
+	}
+	return nil
+}
+
+func (*sqlite) unlock(conn shared.Conn, _ string) error {
+	_, err := conn.Exec("COMMIT")
+	return err
+}
+
+func (h *sqlite) foreignKeys(q shared.Querier, tableName string) ([]foreignKey, error) {
+	foreignKeys, err := queryForeignKeys(
+		q,
+		`SELECT CAST(id AS TEXT), "from", "table", "to" FROM pragma_foreign_key_list(?) ORDER BY id, seq`,
+		tableName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The referenced columns are not given when referencing the primary key.
+	for i, fk := range foreignKeys {
+		if fk.referencedColumns[0] != "" {
+			continue
+		}
+		if foreignKeys[i].referencedColumns, err = h.primaryKey(q, fk.referencedTable); err != nil {
+			return nil, err
+		}
+	}
+	return foreignKeys, nil
+}
+
+func (h *sqlite) buildReturningInsertSQL(q shared.Querier, tableName string, columns, values, returning []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	return insert + returningClause(h.quoteKeyword, returning), nil
+}
+
+func (h *sqlite) buildUpsertSQL(q shared.Querier, tableName string, primaryKey, columns, values []string) (string, error) {
+	insert, err := h.buildInsertSQL(q, tableName, columns, values)
+	if err != nil {
+		return "", err
+	}
+	keyColumns, updateColumns := upsertColumns(h.quoteKeyword, primaryKey, columns)
+	onConflict, err := onConflictDoUpdate(tableName, keyColumns, updateColumns)
+	if err != nil {
+		return "", err
+	}
+	return insert + onConflict, nil
+}
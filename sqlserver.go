@@ -1,9 +1,13 @@
 package testfixtures
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
@@ -13,9 +17,11 @@ type sqlserver struct {
 
 	paramTypeCache ParamType
 	tables         []string
+	defaultSchema  string
+	tablesChecksum map[string]string
 }
 
-func (h *sqlserver) init(db *sql.DB) error {
+func (h *sqlserver) init(db shared.Querier) error {
 	var err error
 
 	// NOTE(@andreynering): The SQL Server lib (github.com/denisenkom/go-mssqldb)
@@ -36,6 +42,10 @@ func (h *sqlserver) init(db *sql.DB) error {
 		return err
 	}
 
+	if err = db.QueryRow("SELECT SCHEMA_NAME()").Scan(&h.defaultSchema); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -51,13 +61,19 @@ func (*sqlserver) quoteKeyword(s string) string {
 	return strings.Join(parts, ".")
 }
 
-func (*sqlserver) databaseName(q shared.Queryable) (string, error) {
+func (*sqlserver) databaseName(q shared.Querier) (string, error) {
 	var dbName string
 	err := q.QueryRow("SELECT DB_NAME()").Scan(&dbName)
 	return dbName, err
 }
 
-func (*sqlserver) tableNames(q shared.Queryable) ([]string, error) {
+func (*sqlserver) databaseHost(q shared.Querier) (string, error) {
+	var host string
+	err := q.QueryRow("SELECT CAST(SERVERPROPERTY('MachineName') AS NVARCHAR(128))").Scan(&host)
+	return host, err
+}
+
+func (*sqlserver) tableNames(q shared.Querier) ([]string, error) {
 	rows, err := q.Query("SELECT table_schema + '.' + table_name FROM INFORMATION_SCHEMA.TABLES WHERE table_name <> 'spt_values' AND table_type = 'BASE TABLE'")
 	if err != nil {
 		return nil, err
@@ -80,7 +96,11 @@ func (*sqlserver) tableNames(q shared.Queryable) ([]string, error) {
 	return tables, nil
 }
 
-func (h *sqlserver) tableHasIdentityColumn(q shared.Queryable, tableName string) (bool, error) {
+func (*sqlserver) createTableIfNotExistsQuery(tableName, definition string) string {
+	return fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (%s)", tableName, tableName, definition)
+}
+
+func (h *sqlserver) tableHasIdentityColumn(q shared.Querier, tableName string) (bool, error) {
 	sql := fmt.Sprintf(`
 		SELECT COUNT(*)
 		FROM sys.identity_columns
@@ -94,7 +114,7 @@ func (h *sqlserver) tableHasIdentityColumn(q shared.Queryable, tableName string)
 
 }
 
-func (h *sqlserver) whileInsertOnTable(tx *sql.Tx, tableName string, fn func() error) (err error) {
+func (h *sqlserver) whileInsertOnTable(tx shared.Querier, tableName string, fn func() error) (err error) {
 	hasIdentityColumn, err := h.tableHasIdentityColumn(tx, tableName)
 	if err != nil {
 		return err
@@ -115,23 +135,15 @@ func (h *sqlserver) whileInsertOnTable(tx *sql.Tx, tableName string, fn func() e
 	return fn()
 }
 
-func (h *sqlserver) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction) (err error) {
+func (h *sqlserver) disableReferentialIntegrity(db database, loadFn loadFunction) (err error) {
 	// ensure the triggers are re-enable after all
 	defer func() {
-		var b strings.Builder
-		for _, table := range h.tables {
-			b.WriteString(fmt.Sprintf("ALTER TABLE %s WITH CHECK CHECK CONSTRAINT ALL;", h.quoteKeyword(table)))
-		}
-		if _, err2 := db.Exec(b.String()); err2 != nil && err == nil {
-			err = err2
+		if _, err2 := db.Exec(joinStatements(h.constraintsStatements("WITH CHECK CHECK"))); err2 != nil {
+			err = withRestoreError(err, err2)
 		}
 	}()
 
-	var b strings.Builder
-	for _, table := range h.tables {
-		b.WriteString(fmt.Sprintf("ALTER TABLE %s NOCHECK CONSTRAINT ALL;", h.quoteKeyword(table)))
-	}
-	if _, err := db.Exec(b.String()); err != nil {
+	if _, err := db.Exec(joinStatements(h.constraintsStatements("NOCHECK"))); err != nil {
 		return err
 	}
 
@@ -147,3 +159,235 @@ func (h *sqlserver) disableReferentialIntegrity(db *sql.DB, loadFn loadFunction)
 
 	return tx.Commit()
 }
+
+func (h *sqlserver) restoreReferentialIntegrityStatements() []string {
+	return h.constraintsStatements("WITH CHECK CHECK")
+}
+
+// constraintsStatements returns the statements to either "NOCHECK" or
+// "WITH CHECK CHECK" all constraints.
+func (h *sqlserver) constraintsStatements(action string) []string {
+	statements := make([]string, 0, len(h.tables))
+	for _, table := range h.tables {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s %s CONSTRAINT ALL", h.quoteKeyword(table), action))
+	}
+	return statements
+}
+
+func (h *sqlserver) disableReferentialIntegrityScript() (before, after []string) {
+	before = append(h.constraintsStatements("NOCHECK"), "BEGIN TRANSACTION")
+	after = append([]string{"COMMIT"}, h.constraintsStatements("WITH CHECK CHECK")...)
+	return before, after
+}
+
+// whileInsertOnTableScript checks whether the table has an identity column in
+// the script, so it's generated without querying the database.
+func (h *sqlserver) whileInsertOnTableScript(tableName string) (before, after []string) {
+	hasIdentityColumn := fmt.Sprintf("IF OBJECTPROPERTY(OBJECT_ID(%s), 'TableHasIdentity') = 1", quoteString(tableName))
+	before = []string{fmt.Sprintf("%s SET IDENTITY_INSERT %s ON", hasIdentityColumn, h.quoteKeyword(tableName))}
+	after = []string{fmt.Sprintf("%s SET IDENTITY_INSERT %s OFF", hasIdentityColumn, h.quoteKeyword(tableName))}
+	return before, after
+}
+
+func (h *sqlserver) sqlLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return "N" + quoteString(v), nil
+	case []byte:
+		return fmt.Sprintf("0x%x", v), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return fmt.Sprintf("CAST(%s AS DATETIMEOFFSET)", quoteString(v.Format("2006-01-02T15:04:05.9999999-07:00"))), nil
+	}
+	return h.baseHelper.sqlLiteral(value)
+}
+
+func (h *sqlserver) isTableModified(q shared.Querier, tableName string) (bool, error) {
+	return isChecksumModified(q, h.tablesChecksum, h.qualifiedTableName(tableName), h.getChecksum)
+}
+
+func (h *sqlserver) computeTablesChecksum(q shared.Querier, tables []string) error {
+	qualified := make([]string, 0, len(tables))
+	for _, table := range tables {
+		qualified = append(qualified, h.qualifiedTableName(table))
+	}
+
+	var err error
+	h.tablesChecksum, err = computeChecksums(q, h.tablesChecksum, qualified, h.getChecksum)
+	return err
+}
+
+// qualifiedTableName prefixes the table name with the default schema when
+// needed, so it matches the names returned by tableNames.
+func (h *sqlserver) qualifiedTableName(tableName string) string {
+	if strings.Contains(tableName, ".") {
+		return tableName
+	}
+	return h.defaultSchema + "." + tableName
+}
+
+func (h *sqlserver) getChecksum(q shared.Querier, tableName string) (string, error) {
+	sqlStr := fmt.Sprintf(
+		"SELECT COUNT_BIG(*), CHECKSUM_AGG(BINARY_CHECKSUM(*)) FROM %s",
+		h.quoteKeyword(tableName),
+	)
+
+	var (
+		count    int64
+		checksum sql.NullInt64
+	)
+	if err := q.QueryRow(sqlStr).Scan(&count, &checksum); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", count, checksum.Int64), nil
+}
+
+var sqlServerColumnRegexp = regexp.MustCompile(`column(?: name)? '([^']+)'`)
+
+func (*sqlserver) classifyInsertError(err error) (InsertErrorCause, string) {
+	var sqlErr interface {
+		error
+		SQLErrorNumber() int32
+	}
+	if !errors.As(err, &sqlErr) {
+		return CauseUnknown, ""
+	}
+
+	switch sqlErr.SQLErrorNumber() {
+	case 2601, 2627:
+		return CauseUniqueViolation, ""
+	case 547:
+		// The column in the message is the one of the referenced table.
+		return CauseForeignKeyViolation, ""
+	case 515:
+		return CauseNotNullViolation, submatch(sqlServerColumnRegexp, sqlErr.Error())
+	case 207:
+		return CauseUnknownColumn, submatch(sqlServerColumnRegexp, sqlErr.Error())
+	case 241, 242, 245, 8114, 8115:
+		return CauseTypeMismatch, ""
+	}
+	return CauseUnknown, ""
+}
+
+func (h *sqlserver) primaryKey(q shared.Querier, tableName string) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT columns.name
+		FROM sys.indexes
+		INNER JOIN sys.index_columns ON index_columns.object_id = indexes.object_id
+			AND index_columns.index_id = indexes.index_id
+		INNER JOIN sys.columns ON columns.object_id = index_columns.object_id
+			AND columns.column_id = index_columns.column_id
+		WHERE indexes.is_primary_key = 1
+		  AND indexes.object_id = OBJECT_ID(%s)
+		ORDER BY index_columns.key_ordinal
+	`, h.paramType().placeholder(1))
+	return queryStrings(q, query, h.quoteKeyword(tableName))
+}
+
+// buildReturningInsertSQL outputs the generated columns. As identity insert is
+// on while loading a table with an identity column, and requires a value for
+// it, it's turned off for the statement.
+func (h *sqlserver) buildReturningInsertSQL(q shared.Querier, tableName string, columns, values, returning []string) (string, error) {
+	outputs := make([]string, 0, len(returning))
+	for _, column := range returning {
+		outputs = append(outputs, "INSERTED."+h.quoteKeyword(column))
+	}
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (%s) OUTPUT %s VALUES (%s)",
+		tableName,
+		strings.Join(columns, ", "),
+		strings.Join(outputs, ", "),
+		strings.Join(values, ", "),
+	)
+
+	hasIdentityColumn, err := h.tableHasIdentityColumn(q, tableName)
+	if err != nil || !hasIdentityColumn {
+		return insert, err
+	}
+	return fmt.Sprintf("SET IDENTITY_INSERT %s OFF; %s; SET IDENTITY_INSERT %s ON", tableName, insert, tableName), nil
+}
+
+func (*sqlserver) lock(_ context.Context, conn shared.Conn, key string, timeout time.Duration) error {
+	const query = `
+		DECLARE @result INT;
+		EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2;
+		SELECT @result;
+	`
+	var result int
+	if err := conn.QueryRow(query, key, timeout.Milliseconds()).Scan(&result); err != nil {
+		return err
+	}
+	switch {
+	case result == -1:
+		return errLockTimeout
+	case result < 0:
+		return fmt.Errorf("testfixtures: could not acquire the database lock: sp_getapplock returned %d", result)
+	}
+	return nil
+}
+
+func (*sqlserver) unlock(conn shared.Conn, key string) error {
+	_, err := conn.Exec("EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'", key)
+	return err
+}
+
+func (h *sqlserver) foreignKeys(q shared.Querier, tableName string) ([]foreignKey, error) {
+	query := fmt.Sprintf(`
+		SELECT foreign_keys.name, columns.name,
+		       SCHEMA_NAME(referenced_tables.schema_id) + '.' + referenced_tables.name, referenced_columns.name
+		FROM sys.foreign_keys
+		INNER JOIN sys.foreign_key_columns ON foreign_key_columns.constraint_object_id = foreign_keys.object_id
+		INNER JOIN sys.columns ON columns.object_id = foreign_key_columns.parent_object_id
+			AND columns.column_id = foreign_key_columns.parent_column_id
+		INNER JOIN sys.columns referenced_columns ON referenced_columns.object_id = foreign_key_columns.referenced_object_id
+			AND referenced_columns.column_id = foreign_key_columns.referenced_column_id
+		INNER JOIN sys.tables referenced_tables ON referenced_tables.object_id = foreign_key_columns.referenced_object_id
+		WHERE foreign_keys.parent_object_id = OBJECT_ID(%s)
+		ORDER BY foreign_keys.name, foreign_key_columns.constraint_column_id
+	`, h.paramType().placeholder(1))
+	return queryForeignKeys(q, query, h.quoteKeyword(tableName))
+}
+
+func (h *sqlserver) buildUpsertSQL(_ shared.Querier, tableName string, primaryKey, columns, values []string) (string, error) {
+	keyColumns, updateColumns := upsertColumns(h.quoteKeyword, primaryKey, columns)
+	if len(keyColumns) == 0 {
+		return "", fmt.Errorf("testfixtures: table %s has no primary key, set it with the PrimaryKey option", tableName)
+	}
+
+	conditions := make([]string, 0, len(keyColumns))
+	for _, column := range keyColumns {
+		conditions = append(conditions, fmt.Sprintf("target.%s = source.%s", column, column))
+	}
+	sourceColumns := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sourceColumns = append(sourceColumns, "source."+column)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		"MERGE INTO %s AS target USING (VALUES (%s)) AS source (%s) ON %s",
+		tableName,
+		strings.Join(values, ", "),
+		strings.Join(columns, ", "),
+		strings.Join(conditions, " AND "),
+	)
+	if len(updateColumns) > 0 {
+		sets := make([]string, 0, len(updateColumns))
+		for _, column := range updateColumns {
+			sets = append(sets, fmt.Sprintf("target.%s = source.%s", column, column))
+		}
+		fmt.Fprintf(&b, " WHEN MATCHED THEN UPDATE SET %s", strings.Join(sets, ", "))
+	}
+	fmt.Fprintf(
+		&b,
+		" WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		strings.Join(columns, ", "),
+		strings.Join(sourceColumns, ", "),
+	)
+	return b.String(), nil
+}
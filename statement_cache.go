@@ -0,0 +1,59 @@
+package testfixtures
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// statementCache prepares each insert statement once per load and reuses it
+// for the following records with the same columns, instead of letting the
+// driver prepare a statement for every record.
+type statementCache struct {
+	tx         shared.Querier
+	disabled   bool
+	statements map[string]statement
+}
+
+// newStatementCache returns a statement cache for the given transaction.
+// Prepared statements are never used for ClickHouse, as its driver turns them
+// into batches.
+func (l *Loader) newStatementCache(tx shared.Querier) *statementCache {
+	_, isClickHouse := l.helper.(*clickhouse)
+	return &statementCache{
+		tx:         tx,
+		disabled:   l.skipPreparedStatements || isClickHouse,
+		statements: make(map[string]statement),
+	}
+}
+
+func (c *statementCache) exec(query string, args ...any) (sql.Result, error) {
+	tx, ok := c.tx.(transaction)
+	if c.disabled || !ok {
+		return c.tx.Exec(query, args...)
+	}
+
+	stmt, ok := c.statements[query]
+	if !ok {
+		var err error
+		if stmt, err = tx.Prepare(query); err != nil {
+			return nil, err
+		}
+		c.statements[query] = stmt
+	}
+	return stmt.Exec(args...)
+}
+
+// close closes the prepared statements. It must be called before the
+// transaction is committed.
+func (c *statementCache) close() error {
+	var errs []error
+	for query, stmt := range c.statements {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.statements, query)
+	}
+	return errors.Join(errs...)
+}
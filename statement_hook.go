@@ -0,0 +1,211 @@
+package testfixtures
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-testfixtures/testfixtures/v3/shared"
+)
+
+// LoadStep identifies the step of loading fixtures a statement belongs to.
+type LoadStep string
+
+const (
+	// StepInit is reading the database metadata when the Loader is created,
+	// and checking it is a test database.
+	StepInit LoadStep = "init"
+	// StepConstraints is disabling and enabling back referential integrity.
+	StepConstraints LoadStep = "constraints"
+	// StepCleanup is deleting the data of the fixture tables.
+	StepCleanup LoadStep = "cleanup"
+	// StepInsert is inserting the fixture records.
+	StepInsert LoadStep = "insert"
+	// StepResetSequences is resetting sequences after loading fixtures.
+	StepResetSequences LoadStep = "reset_sequences"
+	// StepChecksum is checking which tables were modified and computing
+	// their checksums after loading fixtures.
+	StepChecksum LoadStep = "checksum"
+	// StepVerify is checking the referential integrity of the loaded
+	// tables, see VerifyReferentialIntegrity.
+	StepVerify LoadStep = "verify"
+	// StepBackup is saving the rows of the fixture tables before loading
+	// fixtures, see Loader.LoadWithBackup.
+	StepBackup LoadStep = "backup"
+)
+
+// Statement is a SQL statement executed by Loader.
+type Statement struct {
+	Step LoadStep
+	SQL  string
+	Args []any
+}
+
+// StatementHook is called before each statement executed by Loader. The
+// returned function, if not nil, is called when the statement finished with
+// its error. For queries returning rows, it's called once the rows are
+// available, before they are read.
+//
+// Hooks may be called concurrently, while the Loader is created or when
+// loading tables in parallel, see Parallelism.
+type StatementHook func(Statement) func(error)
+
+// Hook adds a hook to be called around every statement executed by Loader.
+// It can be used to log statements or to export tracing spans.
+// It can be given multiple times.
+func Hook(hook StatementHook) func(*Loader) error {
+	return func(l *Loader) error {
+		l.tracer.hooks = append(l.tracer.hooks, hook)
+		return nil
+	}
+}
+
+// LogStatements logs every statement executed by Loader with the given
+// function, alongside its step and duration. Works well with testing.T.Logf:
+//
+//	testfixtures.New(
+//	        ...
+//	        testfixtures.LogStatements(t.Logf),
+//	)
+func LogStatements(logf func(format string, args ...any)) func(*Loader) error {
+	return Hook(func(s Statement) func(error) {
+		start := time.Now()
+		return func(err error) {
+			if err != nil {
+				logf("testfixtures: [%s] %s: %s: %v", s.Step, time.Since(start), s.SQL, err)
+				return
+			}
+			logf("testfixtures: [%s] %s: %s", s.Step, time.Since(start), s.SQL)
+		}
+	})
+}
+
+// tracer holds the hooks and the current step of a Loader.
+type tracer struct {
+	step  LoadStep
+	hooks []StatementHook
+
+	// nested is the time spent in steps nested in the current one.
+	nested time.Duration
+}
+
+func (t *tracer) trace(query string, args []any) func(error) {
+	statement := Statement{Step: t.step, SQL: query, Args: args}
+	dones := make([]func(error), 0, len(t.hooks))
+	for _, hook := range t.hooks {
+		if done := hook(statement); done != nil {
+			dones = append(dones, done)
+		}
+	}
+	return func(err error) {
+		for _, done := range dones {
+			done(err)
+		}
+	}
+}
+
+// runStep runs fn as the given step, adding its duration to d. Steps can be
+// nested, in which case the time spent in the nested step is only added to
+// its own duration.
+func (t *tracer) runStep(step LoadStep, d *time.Duration, fn func() error) error {
+	previousStep, previousNested := t.step, t.nested
+	t.step, t.nested = step, 0
+
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start)
+	*d += elapsed - t.nested
+
+	t.step, t.nested = previousStep, previousNested+elapsed
+	return err
+}
+
+type tracedQueryable struct {
+	q      shared.Querier
+	tracer *tracer
+}
+
+func (t tracedQueryable) Exec(query string, args ...any) (sql.Result, error) {
+	done := t.tracer.trace(query, args)
+	result, err := t.q.Exec(query, args...)
+	done(err)
+	return result, err
+}
+
+func (t tracedQueryable) Query(query string, args ...any) (shared.Rows, error) {
+	done := t.tracer.trace(query, args)
+	rows, err := t.q.Query(query, args...)
+	done(err)
+	return rows, err
+}
+
+func (t tracedQueryable) QueryRow(query string, args ...any) shared.Row {
+	done := t.tracer.trace(query, args)
+	row := t.q.QueryRow(query, args...)
+	var err error
+	if r, ok := row.(interface{ Err() error }); ok {
+		err = r.Err()
+	}
+	done(err)
+	return row
+}
+
+type tracedDatabase struct {
+	tracedQueryable
+	db database
+}
+
+func (t tracedDatabase) Conn(ctx context.Context) (shared.Conn, error) {
+	return t.db.Conn(ctx)
+}
+
+func (t tracedDatabase) WithContext(ctx context.Context) database {
+	db := t.db.WithContext(ctx)
+	return tracedDatabase{tracedQueryable{db, t.tracer}, db}
+}
+
+func (t tracedDatabase) Begin() (transaction, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return tracedTransaction{tracedQueryable{tx, t.tracer}, tx}, nil
+}
+
+type tracedTransaction struct {
+	tracedQueryable
+	tx transaction
+}
+
+func (t tracedTransaction) Prepare(query string) (statement, error) {
+	stmt, err := t.tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return tracedStatement{stmt, query, t.tracer}, nil
+}
+
+func (t tracedTransaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t tracedTransaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type tracedStatement struct {
+	stmt   statement
+	query  string
+	tracer *tracer
+}
+
+func (t tracedStatement) Exec(args ...any) (sql.Result, error) {
+	done := t.tracer.trace(t.query, args)
+	result, err := t.stmt.Exec(args...)
+	done(err)
+	return result, err
+}
+
+func (t tracedStatement) Close() error {
+	return t.stmt.Close()
+}
@@ -0,0 +1,149 @@
+package testfixtures
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// tableKey is the key of a map of labeled records giving the table of the
+// file, when it's not its name.
+const tableKey = "_table"
+
+// SchemaDirectories makes the YAML files in the subdirectories of the
+// directories given with Directory and Paths fixtures of the tables of the
+// schema named after the subdirectory, like "fixtures/audit/events.yml" for
+// the table "audit.events". Files directly in the directories are fixtures
+// of the tables named after them, as usual.
+//
+// With RecursiveDirectories, the schema is the first subdirectory of the
+// path of the file.
+func SchemaDirectories() func(*Loader) error {
+	return func(l *Loader) error {
+		l.schemaDirectories = true
+		return nil
+	}
+}
+
+// tableName returns the table of the file, which is its name unless another
+// table is declared in the file or the file is in a schema directory.
+func (f *fixtureFile) tableName() string {
+	if f.table != "" {
+		return f.table
+	}
+	return f.fileNameWithoutExtension()
+}
+
+// fixtureTable is a table with the fixture files loaded into it, in order.
+type fixtureTable struct {
+	name  string
+	files []*fixtureFile
+}
+
+// groupByTable groups the files by table, in the order of the first file of
+// each table, so each table is cleaned once and its records are inserted
+// together.
+func groupByTable(files []*fixtureFile) []*fixtureTable {
+	var (
+		tables  []*fixtureTable
+		byTable = make(map[string]*fixtureTable, len(files))
+	)
+	for _, file := range files {
+		name := file.tableName()
+		table, ok := byTable[name]
+		if !ok {
+			table = &fixtureTable{name: name}
+			byTable[name] = table
+			tables = append(tables, table)
+		}
+		table.files = append(table.files, file)
+	}
+	return tables
+}
+
+// declaredTable reads the table declared in the content of a file, either in
+// a front matter:
+//
+//	---
+//	table: audit.events
+//	---
+//	- id: 1
+//
+// or with the _table key of a map of labeled records. Lines of the front
+// matter are replaced by empty lines in the returned content, so positions of
+// records stay the same.
+func declaredTable(content []byte) (table string, _ []byte, err error) {
+	table, content, err = frontMatterTable(content)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !bytes.Contains(content, []byte(tableKey)) {
+		return table, content, nil
+	}
+	var records map[string]any
+	if err := yaml.Unmarshal(content, &records); err != nil {
+		// Not a map of records, errors are reported when parsing them.
+		return table, content, nil
+	}
+	value, ok := records[tableKey]
+	if !ok {
+		return table, content, nil
+	}
+	name, ok := value.(string)
+	if !ok || name == "" {
+		return "", nil, fmt.Errorf("%s is reserved to declare the table of the file and must be the name of a table", tableKey)
+	}
+	if table != "" {
+		return "", nil, fmt.Errorf("table is declared both in the front matter and with %s", tableKey)
+	}
+	return name, content, nil
+}
+
+func frontMatterTable(content []byte) (string, []byte, error) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines) == 0 || !isDocumentSeparator(lines[0]) {
+		return "", content, nil
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if isDocumentSeparator(lines[i]) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", content, nil
+	}
+
+	// Files with many documents are not front matters, only the first
+	// document of them is loaded.
+	var header map[string]any
+	if err := yaml.Unmarshal(bytes.Join(lines[1:end], nil), &header); err != nil {
+		return "", content, nil
+	}
+	value, ok := header["table"]
+	if !ok {
+		return "", content, nil
+	}
+	for key := range header {
+		if key != "table" {
+			return "", nil, fmt.Errorf(`unknown key "%s" in front matter`, key)
+		}
+	}
+	table, ok := value.(string)
+	if !ok || table == "" {
+		return "", nil, errors.New("table of front matter must be the name of a table")
+	}
+
+	body := bytes.Repeat([]byte("\n"), end+1)
+	body = append(body, bytes.Join(lines[end+1:], nil)...)
+	return table, body, nil
+}
+
+func isDocumentSeparator(line []byte) bool {
+	return strings.TrimRight(string(line), " \t\r\n") == "---"
+}
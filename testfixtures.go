@@ -2,33 +2,90 @@ package testfixtures // import "github.com/go-testfixtures/testfixtures/v3"
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/fs"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
 
 	"github.com/go-testfixtures/testfixtures/v3/shared"
 )
 
 // Loader is the responsible to loading fixtures.
+//
+// A Loader is safe for concurrent use, so it can be shared by parallel tests.
+// As loading fixtures cleans the same tables, concurrent loads run one after
+// the other.
 type Loader struct {
-	db            *sql.DB
+	// mu serializes the methods using the database, which share the state
+	// of the Loader and its helper, like the checksums of the tables. The
+	// fixtures are parsed by New and never modified afterwards.
+	mu sync.Mutex
+
+	db *sql.DB
+	// database is db, or the database given with Connection, and conn is
+	// database traced by the hooks.
+	database      database
+	conn          database
 	helper        helper
 	fixturesFiles []*fixtureFile
 
-	skipCleanup             bool
-	skipChecksumComputation bool
-	skipTestDatabaseCheck   bool
-	location                *time.Location
+	tracer       *tracer
+	initDuration time.Duration
+
+	skipCleanup                bool
+	skipChecksumComputation    bool
+	skipTestDatabaseCheck      bool
+	skipSeedDatabaseCheck      bool
+	skipPreparedStatements     bool
+	verifyReferentialIntegrity bool
+	parallelism                int
+	upsert                     bool
+	persistChecksums           bool
+	location                   *time.Location
+
+	// safety holds the checks run by EnsureTestDatabase.
+	safety safetyPolicy
+
+	// lockKey and lockTimeout configure the lock of UseDatabaseLock, and
+	// lock is the lock when it's held.
+	lockKey     string
+	lockTimeout time.Duration
+	lock        *databaseLock
+
+	// primaryKeys stores the primary key of the tables, used by UseUpsert,
+	// Layers and CaptureGeneratedKeys.
+	primaryKeys map[string][]string
+
+	// generatedKeys stores the keys generated by the database for the
+	// records omitting them, when captured with CaptureGeneratedKeys. It has
+	// its own mutex, as tables can be loaded in parallel.
+	captureGeneratedKeys bool
+	generatedKeysMu      sync.Mutex
+	generatedKeys        map[recordID]map[string]any
+
+	// loadedSelections stores the selection of the records last loaded on
+	// each table, when only some of them were.
+	loadedSelections map[string]string
+
+	// fixturesChecksums stores a hash of the fixtures of each table, used
+	// when checksums are persisted in the database.
+	fixturesChecksums map[string]string
+	// checksumsTableExists is whether the table of the persisted checksums
+	// is known to exist, so it's only created once.
+	checksumsTableExists bool
 
 	template           bool
 	templateFuncs      template.FuncMap
@@ -39,10 +96,24 @@ type Loader struct {
 
 	fs fs.FS
 
+	// recursiveDirectories makes Directory and Paths read the YAML files of
+	// subdirectories too.
+	recursiveDirectories bool
+	// schemaDirectories makes subdirectories schemas, see SchemaDirectories.
+	schemaDirectories bool
+
+	// recordChanges are the changes to the fixtures given by Override,
+	// Exclude and Extra.
+	recordChanges []recordChange
+
 	// pendingSources stores fixture sources to be loaded after all options
 	// are processed, so that template configuration is available regardless
 	// of option ordering.
 	pendingSources []pendingSource
+
+	// catalogs caches what the helper reads from the database in init, when
+	// the Loader was created by a FixtureSet.
+	catalogs *catalogCache
 }
 
 type pendingSourceKind int
@@ -52,6 +123,8 @@ const (
 	sourceFiles
 	sourcePaths
 	sourceFilesMultiTables
+	sourceGlob
+	sourceLayers
 )
 
 type pendingSource struct {
@@ -64,42 +137,86 @@ type fixtureFile struct {
 	fileName   string
 	content    []byte
 	insertSQLs []insertSQL
+
+	// table is the table of the file, when it's not its name: when it's
+	// declared in the file, or the file is in a schema directory.
+	table         string
+	tableDeclared bool
+
+	// layer is the position of the path of the file in Layers, starting at
+	// 1, or 0 when the file is not part of a layer.
+	layer int
+
+	// selection identifies the records of the table being loaded, when only
+	// some of them are, see Loader.LoadGroups. It's empty when all records
+	// of the table are loaded.
+	selection string
+
+	// recordsNode is the AST node of the records, when they were read from
+	// a file with multiple tables. Otherwise, the AST is parsed from content.
+	recordsNode ast.Node
+
+	// records are the parsed records, when the file belongs to a FixtureSet
+	// or its records were changed. Otherwise, they are parsed from content.
+	records []fixtureRecord
+	// changes are the changes applied to the records, which are part of the
+	// hash of the file.
+	changes []recordChange
 }
 
 type insertSQL struct {
 	sql    string
 	params []any
+
+	// columns and values are kept to build the statement again with literals
+	// instead of params when generating a SQL script. Each value is either a
+	// param or a rawSQL.
+	columns []string
+	values  []any
+
+	// returning are the columns of the primary key generated by the
+	// database, see CaptureGeneratedKeys.
+	returning []string
+
+	record fixtureRecord
 }
 
+// rawSQL is a value given with the "RAW=" prefix, which is inlined in the
+// statement as is.
+type rawSQL string
+
 var (
 	testDatabaseRegexp = regexp.MustCompile("(?i)test")
 
 	errDatabaseIsRequired = fmt.Errorf("testfixtures: database is required")
 	errDialectIsRequired  = fmt.Errorf("testfixtures: dialect is required")
+	errNothingToLoad      = fmt.Errorf("testfixtures: no table or group to load")
 )
 
 // New instantiates a new Loader instance. The "Database" and "Driver"
 // options are required.
 func New(options ...func(*Loader) error) (*Loader, error) {
-	l := &Loader{
-		templateLeftDelim:  "{{",
-		templateRightDelim: "}}",
-		templateOptions:    []string{"missingkey=zero"},
-		fs:                 defaultFS{},
-	}
-
+	l := newLoader()
 	for _, option := range options {
 		if err := option(l); err != nil {
 			return nil, err
 		}
 	}
 
-	if l.db == nil {
-		return nil, errDatabaseIsRequired
+	if l.database == nil {
+		if l.db == nil {
+			return nil, errDatabaseIsRequired
+		}
+		l.database = newSQLDatabase(l.db)
 	}
 	if l.helper == nil {
 		return nil, errDialectIsRequired
 	}
+	if err := l.checkParallelism(); err != nil {
+		return nil, err
+	}
+
+	l.conn = l.traced(l.database)
 
 	// Load fixture files after all options are processed, so that
 	// template configuration is available regardless of option ordering.
@@ -107,16 +224,44 @@ func New(options ...func(*Loader) error) (*Loader, error) {
 		return nil, err
 	}
 
-	if err := l.helper.init(l.db); err != nil {
+	if err := l.tracer.runStep(StepInit, &l.initDuration, l.initHelper); err != nil {
+		return nil, err
+	}
+	if err := l.mergeLayers(); err != nil {
+		return nil, err
+	}
+	if err := l.applyRecordChanges(); err != nil {
 		return nil, err
 	}
 	if err := l.buildInsertSQLs(); err != nil {
 		return nil, err
 	}
+	if l.persistChecksums {
+		l.computeFixturesChecksums()
+	}
 
 	return l, nil
 }
 
+func newLoader() *Loader {
+	return &Loader{
+		templateLeftDelim:  "{{",
+		templateRightDelim: "}}",
+		templateOptions:    []string{"missingkey=zero"},
+		fs:                 defaultFS{},
+		tracer:             &tracer{},
+		loadedSelections:   make(map[string]string),
+	}
+}
+
+// traced returns db tracing its statements when there are hooks, see Hook.
+func (l *Loader) traced(db database) database {
+	if len(l.tracer.hooks) == 0 {
+		return db
+	}
+	return tracedDatabase{tracedQueryable{db, l.tracer}, db}
+}
+
 // FS sets other fs.FS implementation
 //
 // Example embed.FS
@@ -136,6 +281,17 @@ func Database(db *sql.DB) func(*Loader) error {
 	}
 }
 
+// Connection sets the database to Loader when it's not a sql.DB, like a pgx
+// connection or pool given by the pgxfixtures package. It replaces the one
+// given with Database.
+func Connection(db shared.Database) func(*Loader) error {
+	return func(l *Loader) error {
+		l.db = nil
+		l.database = db
+		return nil
+	}
+}
+
 type DialectOptions func(h helper) error
 
 // WithCustomPlaceholder - allow to provide custom placeholder in queries
@@ -306,6 +462,44 @@ func SkipTableChecksumComputation() func(*Loader) error {
 	}
 }
 
+// SkipPreparedStatements makes Loader execute each insert on its own, instead
+// of preparing a statement per table and reusing it for all records. Useful
+// for drivers or proxies that don't support prepared statements.
+//
+// Prepared statements are never used for ClickHouse, as its driver turns them
+// into batches.
+func SkipPreparedStatements() func(*Loader) error {
+	return func(l *Loader) error {
+		l.skipPreparedStatements = true
+		return nil
+	}
+}
+
+// UseTriggerChangeTracking makes Loader detect modified tables with triggers
+// instead of table checksums, which can be slow on big tables.
+//
+// After fixtures are loaded for the first time, a trigger is created on each
+// table to record changes in the "testfixtures_table_versions" table, so
+// subsequent calls to Loader.Load only reload tables that were modified.
+// The database user must have permission to create tables and triggers.
+//
+// Only valid for PostgreSQL, MySQL and SQLite. Returns an error otherwise.
+func UseTriggerChangeTracking() func(*Loader) error {
+	return func(l *Loader) error {
+		switch helper := l.helper.(type) {
+		case *postgreSQL:
+			helper.trackChanges = true
+		case *mySQL:
+			helper.trackChanges = true
+		case *sqlite:
+			helper.trackChanges = true
+		default:
+			return fmt.Errorf("testfixtures: UseTriggerChangeTracking is only valid for PostgreSQL, MySQL and SQLite databases")
+		}
+		return nil
+	}
+}
+
 // Directory informs Loader to load YAML files from a given directory.
 func Directory(dir string) func(*Loader) error {
 	return func(l *Loader) error {
@@ -329,6 +523,7 @@ func Files(files ...string) func(*Loader) error {
 }
 
 // Paths inform Loader to load a given set of YAML files and directories.
+// Paths are read from the FS option, if given.
 func Paths(paths ...string) func(*Loader) error {
 	return func(l *Loader) error {
 		l.pendingSources = append(l.pendingSources, pendingSource{
@@ -339,6 +534,42 @@ func Paths(paths ...string) func(*Loader) error {
 	}
 }
 
+// Glob informs Loader to load the files matching the given patterns, in the
+// syntax of path.Match, where a "**" path element also matches any number of
+// directories, like "fixtures/**/*.yml". Files are loaded in the order of the
+// patterns, and in lexical order of their paths for each pattern, which must
+// match at least one file. Files are read from the FS option, if given.
+//
+// As the table of a file is its name, two matched files can't have the same
+// name.
+func Glob(patterns ...string) func(*Loader) error {
+	return func(l *Loader) error {
+		for _, pattern := range patterns {
+			if err := validateGlob(pattern); err != nil {
+				return err
+			}
+		}
+		l.pendingSources = append(l.pendingSources, pendingSource{
+			kind:  sourceGlob,
+			paths: patterns,
+		})
+		return nil
+	}
+}
+
+// RecursiveDirectories makes Loader also load the YAML files of the
+// subdirectories of the directories given with Directory and Paths, in
+// lexical order of their paths.
+//
+// As the table of a file is its name, two files of a directory and its
+// subdirectories can't have the same name.
+func RecursiveDirectories() func(*Loader) error {
+	return func(l *Loader) error {
+		l.recursiveDirectories = true
+		return nil
+	}
+}
+
 // FilesMultiTables informs Loader to load a given set of YAML files as multiple fixtures.
 func FilesMultiTables(files ...string) func(*Loader) error {
 	return func(l *Loader) error {
@@ -453,16 +684,23 @@ func ClickhouseUseDeleteFrom() func(*Loader) error {
 }
 
 // EnsureTestDatabase returns an error if the database name does not contains
-// "test".
+// "test", or doesn't pass the other checks of the safety policy, see
+// TestDatabaseNamePattern, RequireEnv and RequireLocalDatabase.
 func (l *Loader) EnsureTestDatabase() error {
-	dbName, err := l.helper.databaseName(l.db)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ensureTestDatabase()
+}
+
+func (l *Loader) ensureTestDatabase() error {
+	dbName, err := l.helper.databaseName(l.conn)
 	if err != nil {
 		return err
 	}
-	if !testDatabaseRegexp.MatchString(dbName) {
+	if !l.safety.isTestDatabaseName(dbName) {
 		return fmt.Errorf(`testfixtures: database "%s" does not appear to be a test database`, dbName)
 	}
-	return nil
+	return l.checkSafetyPolicy()
 }
 
 // Load wipes and after load all fixtures in the database.
@@ -471,135 +709,362 @@ func (l *Loader) EnsureTestDatabase() error {
 //	        ...
 //	}
 func (l *Loader) Load() error {
+	_, err := l.LoadWithResult()
+	return err
+}
+
+// LoadWithResult is like Load, but also returns what was done: which tables
+// were skipped because they were not modified, how many rows were deleted
+// and inserted, and how long each step took.
+//
+// The result is also returned on error, describing what was done until then.
+func (l *Loader) LoadWithResult() (*LoadResult, error) {
+	return l.load(context.Background(), l.fixturesFiles)
+}
+
+// load loads the given files, which may be a subset of the fixtures of the
+// Loader, see Loader.LoadTables.
+func (l *Loader) load(ctx context.Context, files []*fixtureFile) (*LoadResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loadLocked(ctx, files)
+}
+
+// loadLocked is load for callers already holding l.mu. It holds the lock of
+// UseDatabaseLock, unless it's already held.
+func (l *Loader) loadLocked(ctx context.Context, files []*fixtureFile) (*LoadResult, error) {
+	result := &LoadResult{InitDuration: l.initDuration}
+	err := l.withDatabaseLock(ctx, func() error {
+		return l.withContext(ctx, func() (err error) {
+			result, err = l.loadFiles(ctx, files)
+			return err
+		})
+	})
+	return result, err
+}
+
+// withContext runs fn with the statements of the Loader run with ctx, so
+// they are canceled with it. The caller must hold l.mu.
+func (l *Loader) withContext(ctx context.Context, fn func() error) error {
+	conn := l.conn
+	l.conn = conn.WithContext(ctx)
+	defer func() {
+		l.conn = conn
+	}()
+	return fn()
+}
+
+func (l *Loader) loadFiles(ctx context.Context, files []*fixtureFile) (*LoadResult, error) {
+	start := time.Now()
+	result := &LoadResult{InitDuration: l.initDuration}
+	defer func() {
+		result.TotalDuration = time.Since(start)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
 	if !l.skipTestDatabaseCheck {
-		if err := l.EnsureTestDatabase(); err != nil {
+		if err := l.tracer.runStep(StepInit, &result.InitDuration, l.ensureTestDatabase); err != nil {
+			return result, err
+		}
+	}
+	if l.lock != nil && l.usesJournal() {
+		// No other process can be loading fixtures while the lock is held,
+		// so what is left in the journal is from a process that died while
+		// referential integrity was disabled. The metadata read by New is
+		// read again, as it missed the foreign keys that were dropped.
+		err := l.tracer.runStep(StepInit, &result.InitDuration, func() error {
+			statements, err := repairJournal(l.conn, l.helper)
+			if err != nil || len(statements) == 0 {
+				return err
+			}
+			return l.initHelper()
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	var persistedChecksums map[string]persistedChecksum
+	if l.persistChecksums {
+		err := l.tracer.runStep(StepChecksum, &result.ChecksumDuration, func() (err error) {
+			persistedChecksums, err = l.readPersistedChecksums()
 			return err
+		})
+		if err != nil {
+			return result, err
 		}
 	}
+	selections := make(map[string]string, len(files))
+	for _, file := range files {
+		selections[file.tableName()] = file.selection
+	}
+	isTableModified := func(q shared.Querier, tableName string) (bool, error) {
+		// A table last loaded with other records must be reloaded, even if
+		// it was not modified since.
+		if l.loadedSelections[tableName] != selections[tableName] {
+			return true, nil
+		}
+		if l.persistChecksums {
+			return l.isPersistedTableModified(q, persistedChecksums, tableName, l.fixturesChecksum(tableName, selections[tableName]))
+		}
+		return l.helper.isTableModified(q, tableName)
+	}
 
-	err := l.helper.disableReferentialIntegrity(l.db, func(tx *sql.Tx) error {
-		modifiedTables := make(map[string]bool, len(l.fixturesFiles))
-		for _, file := range l.fixturesFiles {
-			tableName := file.fileNameWithoutExtension()
-			modified, err := l.helper.isTableModified(tx, tableName)
+	tables := groupByTable(files)
+	for _, table := range tables {
+		result.table(table.name)
+	}
+
+	var loadedTables []string
+	err := l.tracer.runStep(StepConstraints, &result.ConstraintsDuration, func() error {
+		return l.disableReferentialIntegrity(func(tx shared.Querier) error {
+			modifiedTables := make(map[string]bool, len(tables))
+			err := l.tracer.runStep(StepChecksum, &result.ChecksumDuration, func() error {
+				for _, table := range tables {
+					modified, err := isTableModified(tx, table.name)
+					if err != nil {
+						return err
+					}
+					modifiedTables[table.name] = modified
+				}
+				return nil
+			})
 			if err != nil {
 				return err
 			}
-			modifiedTables[tableName] = modified
-		}
 
-		// Delete existing table data for specified fixtures before populating the data. This helps avoid
-		// DELETE CASCADE constraints when using the `UseAlterConstraint()` option.
-		if !l.skipCleanup {
-			for _, file := range l.fixturesFiles {
-				modified := modifiedTables[file.fileNameWithoutExtension()]
-				if !modified {
-					continue
+			modifiedFixtureTables := func() []*fixtureTable {
+				var modified []*fixtureTable
+				for _, table := range tables {
+					if modifiedTables[table.name] {
+						modified = append(modified, table)
+					}
 				}
-				if err := file.delete(tx, l.helper); err != nil {
+				return modified
+			}
+
+			// When loading in parallel, tables are cleaned and loaded outside
+			// of the load transaction, so it doesn't hold locks on them.
+			var mu sync.Mutex
+
+			// Delete existing table data for specified fixtures before populating the data. This helps avoid
+			// DELETE CASCADE constraints when using the `UseAlterConstraint()` option.
+			if !l.skipCleanup {
+				err := l.tracer.runStep(StepCleanup, &result.CleanupDuration, func() error {
+					toClean := modifiedFixtureTables()
+					err := l.forEachTable(ctx, toClean, func(table *fixtureTable) error {
+						q := tx
+						if l.parallelism > 1 {
+							q = l.conn
+						}
+						rowsDeleted, err := deleteTable(q, l.helper, table.name)
+						if err != nil {
+							return err
+						}
+						mu.Lock()
+						defer mu.Unlock()
+						result.table(table.name).RowsDeleted += rowsDeleted
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					deleted := len(toClean) > 0
+
+					// Deleting from a table may cascade to tables considered
+					// unmodified, so check them again until nothing else changes.
+					for changed := deleted; changed; {
+						changed = false
+						for _, table := range tables {
+							tableName := table.name
+							if modifiedTables[tableName] {
+								continue
+							}
+							var modified bool
+							err := l.tracer.runStep(StepChecksum, &result.ChecksumDuration, func() (err error) {
+								modified, err = isTableModified(tx, tableName)
+								return err
+							})
+							if err != nil {
+								return err
+							}
+							if !modified {
+								continue
+							}
+							rowsDeleted, err := deleteTable(tx, l.helper, tableName)
+							if err != nil {
+								return err
+							}
+							result.table(tableName).RowsDeleted += rowsDeleted
+							modifiedTables[tableName] = true
+							changed = true
+						}
+					}
+					return nil
+				})
+				if err != nil {
 					return err
 				}
 			}
-		}
 
-		for _, file := range l.fixturesFiles {
-			modified := modifiedTables[file.fileNameWithoutExtension()]
-			if !modified {
-				continue
+			for _, table := range tables {
+				result.table(table.name).Skipped = !modifiedTables[table.name]
 			}
-			err := l.helper.whileInsertOnTable(tx, file.fileNameWithoutExtension(), func() error {
-				for j, i := range file.insertSQLs {
-					if _, err := tx.Exec(i.sql, i.params...); err != nil {
-						return &InsertError{
-							Err:    err,
-							File:   file.fileName,
-							Index:  j,
-							SQL:    i.sql,
-							Params: i.params,
-						}
-					}
+
+			err = l.tracer.runStep(StepInsert, &result.InsertDuration, func() error {
+				if l.parallelism > 1 {
+					return l.forEachTable(ctx, modifiedFixtureTables(), func(table *fixtureTable) error {
+						rowsInserted, err := l.insertTableInTransaction(table)
+						mu.Lock()
+						defer mu.Unlock()
+						result.table(table.name).RowsInserted += rowsInserted
+						return err
+					})
 				}
-				return nil
+
+				statements := l.newStatementCache(tx)
+				err := l.forEachTable(ctx, modifiedFixtureTables(), func(table *fixtureTable) error {
+					rowsInserted, err := l.insertTable(tx, statements, table)
+					result.table(table.name).RowsInserted += rowsInserted
+					return err
+				})
+				if closeErr := statements.close(); err == nil {
+					err = closeErr
+				}
+				return err
 			})
 			if err != nil {
 				return err
 			}
-		}
-		return nil
+
+			if reloaded := modifiedFixtureTables(); l.verifyReferentialIntegrity && len(reloaded) > 0 {
+				err := l.tracer.runStep(StepVerify, &result.VerifyDuration, func() error {
+					// Reloaded tables may be referenced by the tables that
+					// were not reloaded, or by other tables.
+					reloadedNames := make([]string, 0, len(reloaded))
+					for _, table := range reloaded {
+						reloadedNames = append(reloadedNames, table.name)
+					}
+					return l.checkReferentialIntegrity(tx, files, reloadedNames)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, table := range tables {
+				if modifiedTables[table.name] {
+					loadedTables = append(loadedTables, table.name)
+				}
+			}
+			return nil
+		})
+	})
+
+	// ensure sequences being reset after load
+	err2 := l.tracer.runStep(StepResetSequences, &result.ResetSequencesDuration, func() error {
+		return l.helper.resetSequences(l.conn)
 	})
 	if err != nil {
-		return err
+		return result, err
+	}
+	if err2 != nil {
+		return result, err2
 	}
+
 	if !l.skipChecksumComputation {
-		if err := l.helper.computeTablesChecksum(l.db); err != nil {
-			return err
+		err := l.tracer.runStep(StepChecksum, &result.ChecksumDuration, func() error {
+			if l.persistChecksums {
+				return l.persistTablesChecksum(loadedTables, selections)
+			}
+			return l.helper.computeTablesChecksum(l.conn, loadedTables)
+		})
+		if err != nil {
+			return result, err
 		}
 	}
-	return nil
-}
 
-// InsertError will be returned if any error happens on database while
-// inserting the record.
-type InsertError struct {
-	Err    error
-	File   string
-	Index  int
-	SQL    string
-	Params []any
-}
-
-func (e *InsertError) Error() string {
-	return fmt.Sprintf(
-		"testfixtures: error inserting record: %v, on file: %s, index: %d, sql: %s, params: %v",
-		e.Err,
-		e.File,
-		e.Index,
-		e.SQL,
-		e.Params,
-	)
-}
-
-func (l *Loader) buildInterfacesSlice(records any) ([]any, error) {
-	switch records := records.(type) {
-	case []any:
-		return records, nil
-	case map[string]any:
-		var result []any
-		for _, record := range records {
-			result = append(result, record)
+	for _, tableName := range loadedTables {
+		if selections[tableName] == "" {
+			delete(l.loadedSelections, tableName)
+		} else {
+			l.loadedSelections[tableName] = selections[tableName]
 		}
-		return result, nil
 	}
+	return result, nil
+}
 
-	return nil, fmt.Errorf("testfixtures: fixture is not a slice or map")
+// insertFile inserts the records of a file, returning how many were inserted.
+func (l *Loader) insertFile(tx shared.Querier, statements *statementCache, file *fixtureFile) (rowsInserted int64, err error) {
+	err = l.helper.whileInsertOnTable(tx, file.tableName(), func() error {
+		rowsInserted, err = l.insertRecords(tx, statements, file)
+		return err
+	})
+	return rowsInserted, err
 }
 
-func (l *Loader) buildInsertSQLs() error {
-	for _, f := range l.fixturesFiles {
-		var records any
-		if err := yaml.Unmarshal(f.content, &records); err != nil {
-			return fmt.Errorf("testfixtures: could not unmarshal YAML: %w", err)
+// insertTable inserts the records of the files of a table, returning how many
+// were inserted.
+func (l *Loader) insertTable(tx shared.Querier, statements *statementCache, table *fixtureTable) (rowsInserted int64, err error) {
+	err = l.helper.whileInsertOnTable(tx, table.name, func() error {
+		for _, file := range table.files {
+			n, err := l.insertRecords(tx, statements, file)
+			rowsInserted += n
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	return rowsInserted, err
+}
 
-		result, err := l.buildInterfacesSlice(records)
+func (l *Loader) insertRecords(tx shared.Querier, statements *statementCache, file *fixtureFile) (rowsInserted int64, err error) {
+	if !file.hasGeneratedKeys() {
+		var ok bool
+		if rowsInserted, ok, err = l.copyFile(tx, file); ok {
+			return rowsInserted, err
+		}
+		if rowsInserted, ok, err = l.batchFile(tx, file); ok {
+			return rowsInserted, err
+		}
+	}
+	for _, i := range file.insertSQLs {
+		if len(i.returning) > 0 {
+			err = l.insertReturning(tx, file, i)
+		} else {
+			_, err = statements.exec(i.sql, i.params...)
+		}
 		if err != nil {
-			return err
+			return rowsInserted, l.newInsertError(err, file, i)
 		}
+		rowsInserted++
+	}
+	return rowsInserted, nil
+}
 
-		f.insertSQLs = make([]insertSQL, 0, len(result))
-
-		for _, record := range result {
-			recordMap, ok := record.(map[string]any)
-			if !ok {
-				return fmt.Errorf("testfixtures: could not cast record: not a map[interface{}]interface{}")
+func (l *Loader) buildInsertSQLs() error {
+	for _, f := range l.fixturesFiles {
+		records := f.records
+		if records == nil {
+			var err error
+			if records, err = f.parseRecords(); err != nil {
+				return err
 			}
+		}
+
+		f.insertSQLs = make([]insertSQL, 0, len(records))
 
-			sql, values, err := l.buildInsertSQL(f, recordMap)
+		for _, record := range records {
+			insert, err := l.buildInsertSQL(f, record)
 			if err != nil {
 				return err
 			}
 
-			f.insertSQLs = append(f.insertSQLs, insertSQL{sql, values})
+			f.insertSQLs = append(f.insertSQLs, insert)
 		}
 	}
 
@@ -610,21 +1075,34 @@ func (f *fixtureFile) fileNameWithoutExtension() string {
 	return strings.Replace(f.fileName, filepath.Ext(f.fileName), "", 1)
 }
 
-func (f *fixtureFile) delete(tx *sql.Tx, h helper) error {
-	deleteQuery := h.cleanTableQuery(h.quoteKeyword(f.fileNameWithoutExtension()))
-	if _, err := tx.Exec(deleteQuery); err != nil {
-		return fmt.Errorf(`testfixtures: could not clean table "%s": %w`, f.fileNameWithoutExtension(), err)
+// deleteTable cleans a table, returning the number of rows deleted if
+// reported by the database.
+func deleteTable(tx shared.Querier, h helper, tableName string) (int64, error) {
+	deleteQuery := h.cleanTableQuery(h.quoteKeyword(tableName))
+	result, err := tx.Exec(deleteQuery)
+	if err != nil {
+		return 0, fmt.Errorf(`testfixtures: could not clean table "%s": %w`, tableName, err)
 	}
-	return nil
+	rowsDeleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil
+	}
+	return rowsDeleted, nil
 }
 
-func (l *Loader) buildInsertSQL(f *fixtureFile, record map[string]any) (sqlStr string, values []any, err error) {
+func (l *Loader) buildInsertSQL(f *fixtureFile, record fixtureRecord) (insert insertSQL, err error) {
+	if record.tombstone {
+		return insert, fmt.Errorf(`testfixtures: file "%s": %s is only valid in the fixtures of Layers`, f.path, deleteKey)
+	}
+	insert.record = record
+
 	var (
-		sqlColumns = make([]string, 0, len(record))
-		sqlValues  = make([]string, 0, len(record))
+		sqlColumns = make([]string, 0, len(record.values))
+		sqlValues  = make([]string, 0, len(record.values))
 		i          = 1
 	)
-	for key, value := range record {
+	for _, key := range record.columnNames() {
+		value := record.values[key]
 		sqlColumns = append(sqlColumns, l.helper.quoteKeyword(key))
 
 		// if string, try convert to SQL or time
@@ -633,6 +1111,7 @@ func (l *Loader) buildInsertSQL(f *fixtureFile, record map[string]any) (sqlStr s
 		case string:
 			if after, ok := strings.CutPrefix(v, "RAW="); ok {
 				sqlValues = append(sqlValues, after)
+				insert.values = append(insert.values, rawSQL(after))
 				continue
 			}
 			if b, err := l.tryHexStringToBytes(v); err == nil {
@@ -649,29 +1128,54 @@ func (l *Loader) buildInsertSQL(f *fixtureFile, record map[string]any) (sqlStr s
 			value = string(bytes)
 		}
 
-		switch l.helper.paramType() {
-		case ParamTypeDollar:
-			sqlValues = append(sqlValues, fmt.Sprintf("$%d", i))
-		case ParamTypeQuestion:
-			sqlValues = append(sqlValues, "?")
-		case ParamTypeAtSign:
-			sqlValues = append(sqlValues, fmt.Sprintf("@p%d", i))
-		}
+		sqlValues = append(sqlValues, l.helper.paramType().placeholder(i))
 
-		values = append(values, value)
+		insert.params = append(insert.params, value)
+		insert.values = append(insert.values, value)
 		i++
 	}
 
-	sqlStr, err = l.helper.buildInsertSQL(
-		l.db,
-		l.helper.quoteKeyword(f.fileNameWithoutExtension()),
-		sqlColumns, sqlValues,
-	)
+	insert.columns = slices.Clone(sqlColumns)
+	if l.captureGeneratedKeys {
+		if insert.returning, err = l.missingPrimaryKey(f.tableName(), record); err != nil {
+			return
+		}
+	}
+	if len(insert.returning) > 0 {
+		// Records omitting their primary key can't conflict on it, so
+		// they are inserted even with UseUpsert.
+		insert.sql, err = l.helper.buildReturningInsertSQL(l.conn, l.helper.quoteKeyword(f.tableName()), sqlColumns, sqlValues, insert.returning)
+		return
+	}
+	insert.sql, err = l.buildInsertStatement(f.tableName(), sqlColumns, sqlValues)
 	return
 }
 
+// checkPendingSources returns an error if a fixture source is not supported
+// by the dialect.
+func (l *Loader) checkPendingSources() error {
+	if _, isSpanner := l.helper.(*spanner); !isSpanner {
+		return nil
+	}
+	for _, src := range l.pendingSources {
+		switch src.kind {
+		case sourceDirectory:
+			return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Directory")
+		case sourcePaths:
+			return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Paths")
+		case sourceGlob:
+			return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Glob")
+		case sourceLayers:
+			return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Layers")
+		}
+	}
+	return nil
+}
+
 func (l *Loader) loadPendingSources() error {
-	_, isSpanner := l.helper.(*spanner)
+	if err := l.checkPendingSources(); err != nil {
+		return err
+	}
 
 	for _, src := range l.pendingSources {
 		var (
@@ -680,19 +1184,17 @@ func (l *Loader) loadPendingSources() error {
 		)
 		switch src.kind {
 		case sourceDirectory:
-			if isSpanner {
-				return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Directory")
-			}
 			fixtures, err = l.fixturesFromDir(src.paths[0])
 		case sourceFiles:
 			fixtures, err = l.fixturesFromFiles(src.paths...)
 		case sourcePaths:
-			if isSpanner {
-				return fmt.Errorf(shared.ErrorMessage_NotSupportedLoadingMethod, "Paths")
-			}
 			fixtures, err = l.fixturesFromPaths(src.paths...)
 		case sourceFilesMultiTables:
 			fixtures, err = l.fixturesFromFilesMultiTables(src.paths...)
+		case sourceGlob:
+			fixtures, err = l.fixturesFromGlob(src.paths...)
+		case sourceLayers:
+			fixtures, err = l.fixturesFromLayers(src.paths...)
 		default:
 			// should not happen as it is not exposed in the lib API
 			panic(fmt.Sprintf("testfixtures: unknown pending source kind: %d", src.kind))
@@ -708,6 +1210,10 @@ func (l *Loader) loadPendingSources() error {
 }
 
 func (l *Loader) fixturesFromDir(dir string) ([]*fixtureFile, error) {
+	if l.recursiveDirectories || l.schemaDirectories {
+		return l.fixturesFromDirRecursive(dir)
+	}
+
 	fileinfos, err := fs.ReadDir(l.fs, dir)
 	if err != nil {
 		return nil, fmt.Errorf(`testfixtures: could not stat directory "%s": %w`, dir, err)
@@ -718,15 +1224,7 @@ func (l *Loader) fixturesFromDir(dir string) ([]*fixtureFile, error) {
 	for _, fileinfo := range fileinfos {
 		fileExt := filepath.Ext(fileinfo.Name())
 		if !fileinfo.IsDir() && (fileExt == ".yml" || fileExt == ".yaml") {
-			fixture := &fixtureFile{
-				path:     path.Join(dir, fileinfo.Name()),
-				fileName: fileinfo.Name(),
-			}
-			fixture.content, err = fs.ReadFile(l.fs, fixture.path)
-			if err != nil {
-				return nil, fmt.Errorf(`testfixtures: could not read file "%s": %w`, fixture.path, err)
-			}
-			fixture.content, err = l.preProcessContent(fixture.fileName, fixture.content)
+			fixture, err := l.readFixtureFile(path.Join(dir, fileinfo.Name()))
 			if err != nil {
 				return nil, err
 			}
@@ -737,21 +1235,10 @@ func (l *Loader) fixturesFromDir(dir string) ([]*fixtureFile, error) {
 }
 
 func (l *Loader) fixturesFromFiles(fileNames ...string) ([]*fixtureFile, error) {
-	var (
-		fixtureFiles = make([]*fixtureFile, 0, len(fileNames))
-		err          error
-	)
+	fixtureFiles := make([]*fixtureFile, 0, len(fileNames))
 
 	for _, f := range fileNames {
-		fixture := &fixtureFile{
-			path:     f,
-			fileName: filepath.Base(f),
-		}
-		fixture.content, err = fs.ReadFile(l.fs, fixture.path)
-		if err != nil {
-			return nil, fmt.Errorf(`testfixtures: could not read file "%s": %w`, fixture.path, err)
-		}
-		fixture.content, err = l.preProcessContent(fixture.fileName, fixture.content)
+		fixture, err := l.readFixtureFile(f)
 		if err != nil {
 			return nil, err
 		}
@@ -761,6 +1248,30 @@ func (l *Loader) fixturesFromFiles(fileNames ...string) ([]*fixtureFile, error)
 	return fixtureFiles, nil
 }
 
+// readFixtureFile reads a fixture file, applying templates, and reads the
+// table it may declare.
+func (l *Loader) readFixtureFile(p string) (*fixtureFile, error) {
+	fixture := &fixtureFile{
+		path:     p,
+		fileName: filepath.Base(p),
+	}
+
+	content, err := fs.ReadFile(l.fs, fixture.path)
+	if err != nil {
+		return nil, fmt.Errorf(`testfixtures: could not read file "%s": %w`, fixture.path, err)
+	}
+	content, err = l.preProcessContent(fixture.fileName, content)
+	if err != nil {
+		return nil, err
+	}
+	fixture.table, fixture.content, err = declaredTable(content)
+	if err != nil {
+		return nil, fmt.Errorf(`testfixtures: file "%s": %w`, fixture.path, err)
+	}
+	fixture.tableDeclared = fixture.table != ""
+	return fixture, nil
+}
+
 func (l *Loader) fixturesFromPaths(paths ...string) ([]*fixtureFile, error) {
 	fixtureExtractor := func(p string, isDir bool) ([]*fixtureFile, error) {
 		if isDir {
@@ -773,7 +1284,7 @@ func (l *Loader) fixturesFromPaths(paths ...string) ([]*fixtureFile, error) {
 	var fixtureFiles []*fixtureFile
 
 	for _, p := range paths {
-		f, err := os.Stat(p)
+		f, err := fs.Stat(l.fs, p)
 		if err != nil {
 			return nil, fmt.Errorf(`testfixtures: could not stat path "%s": %w`, p, err)
 		}
@@ -813,25 +1324,38 @@ func (l *Loader) fixturesFromFilesMultiTables(fileNames ...string) ([]*fixtureFi
 			return nil, fmt.Errorf("testfixtures: could not unmarshal YAML: %w", err)
 		}
 
+		// The records of each table are kept in the AST, so errors can
+		// refer to their position in this file.
+		file, err := parser.ParseBytes(content, 0)
+		if err != nil {
+			return nil, fmt.Errorf("testfixtures: could not parse YAML: %w", err)
+		}
+		tableNodes := make(map[string]ast.Node, len(tablesMap))
+		if len(file.Docs) > 0 {
+			for _, kv := range mappingValues(unwrapNode(file.Docs[0].Body)) {
+				tableNodes[kv.Key.GetToken().Value] = kv.Value
+			}
+		}
+
 		for _, item := range tablesMap {
 			table := item.Key.(string)
 			records := item.Value
-			result, err := l.buildInterfacesSlice(records)
-			if err != nil {
-				return nil, err
+			switch records.(type) {
+			case []any, yaml.MapSlice:
+			default:
+				return nil, fmt.Errorf("testfixtures: fixture is not a slice or map")
 			}
 
 			var content []byte
-			if content, err = yaml.Marshal(result); err != nil {
+			if content, err = yaml.Marshal(records); err != nil {
 				return nil, fmt.Errorf("testfixtures: could not marshal YAML: %w", err)
 			}
 
-			file := fmt.Sprintf("%s.yml", table)
-			path := filepath.Join(filepath.Dir(f), file)
 			fixtureFiles = append(fixtureFiles, &fixtureFile{
-				path:     path,
-				fileName: file,
-				content:  content,
+				path:        f,
+				fileName:    fmt.Sprintf("%s.yml", table),
+				content:     content,
+				recordsNode: tableNodes[table],
 			})
 		}
 	}
@@ -1,11 +1,20 @@
 package testfixtures
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf8"
 
+	"github.com/go-testfixtures/testfixtures/v3/shared"
 	"github.com/goccy/go-yaml"
 )
 
@@ -42,6 +51,29 @@ func TestRequiredOptions(t *testing.T) {
 	})
 }
 
+func TestParallelism(t *testing.T) {
+	_, err := New(Database(&sql.DB{}), Dialect("postgres"), Parallelism(2))
+	if err == nil || !strings.Contains(err.Error(), "Parallelism") {
+		t.Errorf("should return an error for PostgreSQL without UseDropConstraint, got %v", err)
+	}
+
+	l := &Loader{helper: &postgreSQL{useDropConstraint: true}, parallelism: 2}
+	if err := l.checkParallelism(); err != nil {
+		t.Errorf("should be valid for PostgreSQL with UseDropConstraint: %v", err)
+	}
+
+	tables := []*fixtureTable{{name: "a"}, {name: "b"}, {name: "c"}}
+	err = l.forEachTable(context.Background(), tables, func(table *fixtureTable) error {
+		if table.name == "b" {
+			return nil
+		}
+		return errors.New(table.name)
+	})
+	if err == nil || err.Error() != "a\nc" {
+		t.Errorf("should return the errors of all tables, got %v", err)
+	}
+}
+
 func TestQuoteKeyword(t *testing.T) {
 	tests := []struct {
 		helper   helper
@@ -64,6 +96,383 @@ func TestQuoteKeyword(t *testing.T) {
 	}
 }
 
+func TestMySQLTriggerName(t *testing.T) {
+	if name := mySQLTriggerName("posts", "insert"); name != "testfixtures_posts_insert" {
+		t.Errorf("expected testfixtures_posts_insert, got %s", name)
+	}
+
+	long := strings.Repeat("a", 60)
+	first, second := mySQLTriggerName(long+"b", "insert"), mySQLTriggerName(long+"c", "insert")
+	if utf8.RuneCountInString(first) != mySQLMaxIdentifierLength {
+		t.Errorf("expected a name of %d characters, got %s", mySQLMaxIdentifierLength, first)
+	}
+	if first == second {
+		t.Errorf("names of different tables should differ, got %s", first)
+	}
+}
+
+func TestRestoreReferentialIntegrityStatements(t *testing.T) {
+	constraints := []pgConstraint{{
+		tableName:      "comments",
+		constraintName: "comments_post_id_fkey",
+		definition:     "FOREIGN KEY (post_id) REFERENCES posts(id)",
+	}}
+	tests := []struct {
+		name     string
+		loader   *Loader
+		expected []string
+	}{
+		{
+			"PostgreSQL triggers",
+			&Loader{helper: &postgreSQL{tables: []string{"comments"}}},
+			nil,
+		},
+		{
+			"PostgreSQL drop constraint",
+			&Loader{helper: &postgreSQL{useDropConstraint: true, constraints: constraints}},
+			[]string{`ALTER TABLE "comments" DROP CONSTRAINT IF EXISTS "comments_post_id_fkey", ADD CONSTRAINT "comments_post_id_fkey" FOREIGN KEY (post_id) REFERENCES posts(id)`},
+		},
+		{
+			"PostgreSQL alter constraint",
+			&Loader{helper: &postgreSQL{useAlterConstraint: true, nonDeferrableConstraints: constraints}},
+			[]string{`ALTER TABLE "comments" ALTER CONSTRAINT "comments_post_id_fkey" NOT DEFERRABLE`},
+		},
+		{
+			"SQL Server",
+			&Loader{helper: &sqlserver{tables: []string{"dbo.comments"}}},
+			[]string{`ALTER TABLE [dbo].[comments] WITH CHECK CHECK CONSTRAINT ALL`},
+		},
+		{
+			"SQLite",
+			&Loader{helper: &sqlite{}},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if usesJournal := test.loader.usesJournal(); usesJournal != (test.expected != nil) {
+				t.Errorf("usesJournal() = %v", usesJournal)
+			}
+			if actual := test.loader.helper.restoreReferentialIntegrityStatements(); !slices.Equal(actual, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	date := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", -3*60*60))
+
+	tests := []struct {
+		helper   helper
+		value    any
+		expected string
+	}{
+		{&postgreSQL{}, nil, `NULL`},
+		{&postgreSQL{}, 42, `42`},
+		{&postgreSQL{}, uint64(42), `42`},
+		{&postgreSQL{}, 1.5, `1.5`},
+		{&postgreSQL{}, true, `TRUE`},
+		{&postgreSQL{}, `it's`, `'it''s'`},
+		{&postgreSQL{}, `C:\dir`, `'C:\dir'`},
+		{&postgreSQL{}, []byte{0xca, 0xfe}, `'\xcafe'::bytea`},
+		{&postgreSQL{}, date, `'2020-01-02 03:04:05.6-03:00'`},
+		{&mySQL{}, `it's C:\dir`, `'it\'s C:\\dir'`},
+		{&mySQL{}, []byte{0xca, 0xfe}, `X'cafe'`},
+		{&mySQL{}, date, `'2020-01-02 06:04:05.6'`},
+		{&sqlite{}, []byte{0xca, 0xfe}, `X'cafe'`},
+		{&sqlite{}, date, `'2020-01-02 03:04:05.6-03:00'`},
+		{&sqlserver{}, `it's`, `N'it''s'`},
+		{&sqlserver{}, []byte{0xca, 0xfe}, `0xcafe`},
+		{&sqlserver{}, false, `0`},
+		{&sqlserver{}, date, `CAST('2020-01-02T03:04:05.6-03:00' AS DATETIMEOFFSET)`},
+		{&clickhouse{}, "a'\nb", `'a\'\nb'`},
+		{&spanner{}, date, `TIMESTAMP '2020-01-02T03:04:05.6-03:00'`},
+	}
+
+	for _, test := range tests {
+		actual, err := test.helper.sqlLiteral(test.value)
+		if err != nil {
+			t.Errorf("unexpected error converting %v: %v", test.value, err)
+			continue
+		}
+		if test.expected != actual {
+			t.Errorf("value %#v should have been converted to %s. Received %s instead", test.value, test.expected, actual)
+		}
+	}
+
+	if _, err := (&postgreSQL{}).sqlLiteral(struct{}{}); err == nil {
+		t.Error("should return an error for unsupported types")
+	}
+}
+
+func TestParseRecords(t *testing.T) {
+	f := &fixtureFile{content: []byte(`zoe:
+  id: 3
+  name: Zoe
+adam:
+  id: 1
+
+  name: Adam
+`)}
+	records, err := f.parseRecords()
+	if err != nil {
+		t.Fatalf("cannot parse records: %v", err)
+	}
+	if len(records) != 2 || records[0].label != "zoe" || records[1].label != "adam" {
+		t.Fatalf("records should be in file order, got %+v", records)
+	}
+	if p := records[1].position; p.line != 4 || p.column != 1 {
+		t.Errorf("unexpected record position %+v", p)
+	}
+	if p := records[1].columnPosition("NAME"); p.line != 7 || p.column != 3 {
+		t.Errorf("unexpected column position %+v", p)
+	}
+	if p := records[1].columnPosition("unknown"); p != records[1].position {
+		t.Errorf("unknown column should have the record position, got %+v", p)
+	}
+
+	f = &fixtureFile{content: []byte("- id: 1\n- id: 2\n  name: Bob\n")}
+	records, err = f.parseRecords()
+	if err != nil {
+		t.Fatalf("cannot parse records: %v", err)
+	}
+	if len(records) != 2 || records[1].label != "" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if p := records[1].columnPosition("name"); p.line != 3 || p.column != 3 {
+		t.Errorf("unexpected column position %+v", p)
+	}
+}
+
+type sqlStateError struct {
+	code    string
+	message string
+	Detail  string
+}
+
+func (e *sqlStateError) Error() string    { return e.message }
+func (e *sqlStateError) SQLState() string { return e.code }
+
+type sqlErrorNumberError struct {
+	number  int32
+	message string
+}
+
+func (e sqlErrorNumberError) Error() string         { return e.message }
+func (e sqlErrorNumberError) SQLErrorNumber() int32 { return e.number }
+
+func TestRecordTags(t *testing.T) {
+	f := &fixtureFile{content: []byte("- id: 1\n  _tags: billing\n- id: 2\n  _tags: [billing, admin]\n- id: 3\n")}
+	records, err := f.parseRecords()
+	if err != nil {
+		t.Fatalf("cannot parse records: %v", err)
+	}
+	for i, expected := range [][]string{{"billing"}, {"billing", "admin"}, nil} {
+		if !slices.Equal(records[i].tags, expected) {
+			t.Errorf("record %d should have tags %v, got %v", i, expected, records[i].tags)
+		}
+		if _, ok := records[i].values[tagsKey]; ok {
+			t.Errorf("record %d should not have a %s column", i, tagsKey)
+		}
+		if records[i].index != i {
+			t.Errorf("record %d has index %d", i, records[i].index)
+		}
+	}
+
+	f = &fixtureFile{content: []byte("- id: 1\n  _tags: {a: b}\n")}
+	if _, err := f.parseRecords(); err == nil {
+		t.Error("should return an error for invalid tags")
+	}
+}
+
+func TestReservedKeys(t *testing.T) {
+	for _, test := range []struct {
+		content  string
+		expected string
+	}{
+		{"- id: 1\n  _tags: {a: b}\n", "_tags is reserved"},
+		{"- id: 1\n  _tags: 1\n", "_tags is reserved"},
+		{"- id: 1\n  _delete: false\n", "_delete is reserved"},
+		{"- id: 1\n  _delete: yes please\n", "_delete is reserved"},
+	} {
+		f := &fixtureFile{content: []byte(test.content)}
+		if _, err := f.parseRecords(); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error %q, got %v", test.content, test.expected, err)
+		}
+	}
+}
+
+func TestSelectFiles(t *testing.T) {
+	newFile := func(path string, tags ...[]string) *fixtureFile {
+		f := &fixtureFile{path: path, fileName: filepath.Base(path)}
+		for i, tags := range tags {
+			f.insertSQLs = append(f.insertSQLs, insertSQL{record: fixtureRecord{index: i, tags: tags}})
+		}
+		return f
+	}
+	l := &Loader{fixturesFiles: []*fixtureFile{
+		newFile("fixtures/core/users.yml", nil, []string{"billing"}),
+		newFile("fixtures/billing/invoices.yml", nil, nil),
+		newFile("fixtures/core/posts.yml", nil),
+	}}
+
+	files := l.selectFiles(func(file *fixtureFile, record fixtureRecord) bool {
+		return file.group() == "billing" || slices.Contains(record.tags, "billing")
+	})
+	if len(files) != 2 || files[0].fileName != "users.yml" || files[1].fileName != "invoices.yml" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	if len(files[0].insertSQLs) != 1 || files[0].insertSQLs[0].record.index != 1 || files[0].selection == "" {
+		t.Errorf("only the tagged user should be selected, got %+v", files[0])
+	}
+	if len(files[1].insertSQLs) != 2 || files[1].selection != "" {
+		t.Errorf("all invoices should be selected, got %+v", files[1])
+	}
+	if len(l.fixturesFiles[0].insertSQLs) != 2 {
+		t.Error("fixture files should not be modified")
+	}
+}
+
+func TestSeedFiles(t *testing.T) {
+	l := &Loader{fixturesFiles: []*fixtureFile{
+		{path: "fixtures/all.yml", fileName: "users.yml", content: []byte("a")},
+		{path: "fixtures/all.yml", fileName: "posts.yml", content: []byte("b")},
+		{path: "fixtures/tags.yml", fileName: "tags.yml", content: []byte("c")},
+	}}
+	files := l.seedFiles()
+	if len(files) != 2 || files[0].Path != "fixtures/all.yml" || files[1].Path != "fixtures/tags.yml" {
+		t.Fatalf("files should be grouped by path, got %+v", files)
+	}
+	if !files[0].Pending || files[0].Hash == "" || files[0].Hash == files[1].Hash {
+		t.Errorf("unexpected files %+v", files)
+	}
+
+	l.fixturesFiles[1].content = []byte("changed")
+	if l.seedFiles()[0].Hash == files[0].Hash {
+		t.Error("hash should change with the content of any table of the file")
+	}
+}
+
+func TestFindOrphanRecord(t *testing.T) {
+	files := []*fixtureFile{{
+		path:     "fixtures/comments.yml",
+		fileName: "comments.yml",
+		insertSQLs: []insertSQL{
+			{record: fixtureRecord{index: 0, label: "valid", values: map[string]any{"post_id": uint64(1)}}},
+			{record: fixtureRecord{index: 1, label: "first", values: map[string]any{"POST_ID": uint64(99)}}},
+			{record: fixtureRecord{index: 2, label: "second", values: map[string]any{"post_id": uint64(99)}}},
+		},
+	}}
+
+	found := make(map[recordKey]bool)
+	var labels []string
+	for range 3 {
+		v := ForeignKeyViolation{Table: "comments", Columns: []string{"post_id"}, Values: []any{int64(99)}}
+		findOrphanRecord(&v, files, found)
+		labels = append(labels, v.Label)
+	}
+	if expected := []string{"first", "second", ""}; !slices.Equal(labels, expected) {
+		t.Errorf("expected records %q, got %q", expected, labels)
+	}
+}
+
+func TestColumnNames(t *testing.T) {
+	f := &fixtureFile{content: []byte(`defaults: &defaults
+  created_at: 2020-01-01
+  active: true
+post:
+  title: Post
+  id: 1
+  <<: *defaults
+  body: Body
+`)}
+	records, err := f.parseRecords()
+	if err != nil {
+		t.Fatalf("cannot parse records: %v", err)
+	}
+
+	expected := []string{"title", "id", "body", "active", "created_at"}
+	for range 10 {
+		if actual := records[1].columnNames(); !slices.Equal(actual, expected) {
+			t.Fatalf("columns should be %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestClassifyInsertError(t *testing.T) {
+	tests := []struct {
+		helper helper
+		err    error
+		cause  InsertErrorCause
+		column string
+	}{
+		{&postgreSQL{}, &sqlStateError{code: "23505", message: `pq: duplicate key value violates unique constraint "users_pkey"`, Detail: "Key (id)=(1) already exists."}, CauseUniqueViolation, "id"},
+		{&postgreSQL{}, &sqlStateError{code: "23503", message: `pq: insert or update on table "posts" violates foreign key constraint "posts_user_id_fkey"`, Detail: "Key (user_id)=(9) is not present in table \"users\"."}, CauseForeignKeyViolation, "user_id"},
+		{&postgreSQL{}, &sqlStateError{code: "23502", message: `pq: null value in column "name" of relation "users" violates not-null constraint`}, CauseNotNullViolation, "name"},
+		{&postgreSQL{}, &sqlStateError{code: "42703", message: `pq: column "foo" of relation "users" does not exist`}, CauseUnknownColumn, "foo"},
+		{&postgreSQL{}, &sqlStateError{code: "22P02", message: `pq: invalid input syntax for type integer: "abc"`}, CauseTypeMismatch, ""},
+		{&postgreSQL{}, errors.New("connection refused"), CauseUnknown, ""},
+		{&mySQL{}, errors.New("Error 1062 (23000): Duplicate entry '1' for key 'users.PRIMARY'"), CauseUniqueViolation, ""},
+		{&mySQL{}, errors.New("Error 1452 (23000): Cannot add or update a child row: a foreign key constraint fails (`db`.`posts`, CONSTRAINT `fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"), CauseForeignKeyViolation, "user_id"},
+		{&mySQL{}, errors.New("Error 1048 (23000): Column 'name' cannot be null"), CauseNotNullViolation, "name"},
+		{&mySQL{}, errors.New("Error 1054 (42S22): Unknown column 'foo' in 'field list'"), CauseUnknownColumn, "foo"},
+		{&mySQL{}, errors.New("Error 1366 (HY000): Incorrect integer value: 'abc' for column 'age' at row 1"), CauseTypeMismatch, "age"},
+		{&sqlite{}, errors.New("UNIQUE constraint failed: users.email"), CauseUniqueViolation, "email"},
+		{&sqlite{}, errors.New("FOREIGN KEY constraint failed"), CauseForeignKeyViolation, ""},
+		{&sqlite{}, errors.New("NOT NULL constraint failed: users.name"), CauseNotNullViolation, "name"},
+		{&sqlite{}, errors.New("table users has no column named foo"), CauseUnknownColumn, "foo"},
+		{&sqlserver{}, sqlErrorNumberError{2627, "mssql: Violation of PRIMARY KEY constraint 'PK_users'."}, CauseUniqueViolation, ""},
+		{&sqlserver{}, sqlErrorNumberError{515, "mssql: Cannot insert the value NULL into column 'name', table 'db.dbo.users'; column does not allow nulls. INSERT fails."}, CauseNotNullViolation, "name"},
+		{&sqlserver{}, sqlErrorNumberError{207, "mssql: Invalid column name 'foo'."}, CauseUnknownColumn, "foo"},
+		{&clickhouse{}, errors.New("code: 16, message: No such column foo in table db.users"), CauseUnknownColumn, "foo"},
+		{&spanner{}, errors.New("Row [1] in table Users already exists"), CauseUniqueViolation, ""},
+		{&spanner{}, errors.New("Column Foo is not present in table Users"), CauseUnknownColumn, "Foo"},
+	}
+
+	for _, test := range tests {
+		cause, column := test.helper.classifyInsertError(test.err)
+		if cause != test.cause || column != test.column {
+			t.Errorf("error %q should have been classified as %q on column %q. Received %q on column %q instead", test.err, test.cause, test.column, cause, column)
+		}
+	}
+}
+
+func TestBuildUpsertSQL(t *testing.T) {
+	tests := []struct {
+		helper   helper
+		expected string
+	}{
+		{&postgreSQL{}, `INSERT INTO "users" ("id", "name") VALUES (?, ?) ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`},
+		{&sqlite{}, `INSERT INTO "users" ("id", "name") VALUES (?, ?) ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`},
+		{&mySQL{}, "INSERT INTO `users` (`id`, `name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `id` = VALUES(`id`), `name` = VALUES(`name`)"},
+		{&sqlserver{}, "MERGE INTO [users] AS target USING (VALUES (?, ?)) AS source ([id], [name]) ON target.[id] = source.[id] WHEN MATCHED THEN UPDATE SET target.[name] = source.[name] WHEN NOT MATCHED THEN INSERT ([id], [name]) VALUES (source.[id], source.[name]);"},
+		{&spanner{}, "INSERT OR UPDATE INTO users (id, name) VALUES (?, ?)"},
+	}
+
+	for _, test := range tests {
+		h := test.helper
+		columns := []string{h.quoteKeyword("id"), h.quoteKeyword("name")}
+		actual, err := h.buildUpsertSQL(nil, h.quoteKeyword("users"), []string{"id"}, columns, []string{"?", "?"})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, actual)
+		}
+	}
+
+	actual, err := (&sqlite{}).buildUpsertSQL(nil, `"posts_tags"`, []string{"post_id", "tag_id"}, []string{`"post_id"`, `"tag_id"`}, []string{"?", "?"})
+	if err != nil || actual != `INSERT INTO "posts_tags" ("post_id", "tag_id") VALUES (?, ?) ON CONFLICT ("post_id", "tag_id") DO NOTHING` {
+		t.Errorf("unexpected upsert without columns to update: %s, %v", actual, err)
+	}
+	if _, err := (&postgreSQL{}).buildUpsertSQL(nil, `"users"`, nil, []string{`"id"`}, []string{"$1"}); err == nil {
+		t.Error("should return an error for tables without primary key")
+	}
+}
+
 func TestLoadPendingSources(t *testing.T) {
 	t.Run("SpannerRejectsDirectory", func(t *testing.T) {
 		l := &Loader{
@@ -360,3 +769,686 @@ func TestEnsureTestDatabase(t *testing.T) {
 		}
 	}
 }
+
+func TestSafetyPolicy(t *testing.T) {
+	t.Setenv("TESTFIXTURES_ALLOW", "1")
+
+	tests := []struct {
+		name    string
+		helper  *MockHelper
+		options []func(*Loader) error
+		wantErr string
+	}{
+		{
+			name:   "default",
+			helper: &MockHelper{dbName: "app_test", host: "db.example.com"},
+		},
+		{
+			name:    "name pattern",
+			helper:  &MockHelper{dbName: "app_test"},
+			options: []func(*Loader) error{TestDatabaseNamePattern(regexp.MustCompile(`^ci_`))},
+			wantErr: "does not appear to be a test database",
+		},
+		{
+			name:    "name allowlist",
+			helper:  &MockHelper{dbName: "fixtures"},
+			options: []func(*Loader) error{TestDatabaseNamePattern(regexp.MustCompile(`^ci_`)), TestDatabaseNames("fixtures")},
+		},
+		{
+			name:    "env",
+			helper:  &MockHelper{dbName: "app_test"},
+			options: []func(*Loader) error{RequireEnv("TESTFIXTURES_ALLOW", "1")},
+		},
+		{
+			name:    "missing env",
+			helper:  &MockHelper{dbName: "app_test"},
+			options: []func(*Loader) error{RequireEnv("TESTFIXTURES_ALLOW", "yes")},
+			wantErr: "environment variable TESTFIXTURES_ALLOW",
+		},
+		{
+			name:    "local host",
+			helper:  &MockHelper{dbName: "app_test", host: "127.0.0.1"},
+			options: []func(*Loader) error{RequireLocalDatabase()},
+		},
+		{
+			name:    "remote host",
+			helper:  &MockHelper{dbName: "app_test", host: "db.example.com"},
+			options: []func(*Loader) error{RequireLocalDatabase()},
+			wantErr: `database host "db.example.com" is not local`,
+		},
+		{
+			name:    "allowed host",
+			helper:  &MockHelper{dbName: "app_test", host: "DB.example.com"},
+			options: []func(*Loader) error{RequireLocalDatabase("db.example.com")},
+		},
+		{
+			name:    "marker table",
+			helper:  &MockHelper{dbName: "app_test", tables: []string{"public.users", "public.test_marker"}},
+			options: []func(*Loader) error{RequireMarkerTable("test_marker"), MaxTables(2)},
+		},
+		{
+			name:    "missing marker table",
+			helper:  &MockHelper{dbName: "app_test", tables: []string{"public.users"}},
+			options: []func(*Loader) error{RequireMarkerTable("test_marker")},
+			wantErr: `marker table "test_marker" not found`,
+		},
+		{
+			name:    "too many tables",
+			helper:  &MockHelper{dbName: "app_test", tables: []string{"users", "posts", "comments"}},
+			options: []func(*Loader) error{MaxTables(2)},
+			wantErr: "database has 3 tables, more than the 2 allowed",
+		},
+		{
+			name:    "internal tables not counted",
+			helper:  &MockHelper{dbName: "app_test", tables: []string{"users", "posts", "testfixtures_checksums", "public.testfixtures_journal"}},
+			options: []func(*Loader) error{MaxTables(2)},
+		},
+		{
+			name:    "custom check",
+			helper:  &MockHelper{dbName: "app_test"},
+			options: []func(*Loader) error{TestDatabaseCheck(func(*sql.DB) error { return errors.New("not today") })},
+			wantErr: "not today",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := &Loader{helper: test.helper}
+			for _, option := range test.options {
+				if err := option(l); err != nil {
+					t.Fatalf("option failed: %v", err)
+				}
+			}
+			err := l.EnsureTestDatabase()
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTracerRunStep(t *testing.T) {
+	var (
+		tr                  = &tracer{}
+		steps               []LoadStep
+		outer, inner, other time.Duration
+	)
+	tr.hooks = append(tr.hooks, func(s Statement) func(error) {
+		steps = append(steps, s.Step)
+		return nil
+	})
+
+	_ = tr.runStep(StepCleanup, &outer, func() error {
+		tr.trace("DELETE FROM posts", nil)(nil)
+		_ = tr.runStep(StepChecksum, &inner, func() error {
+			tr.trace("SELECT * FROM posts", nil)(nil)
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+		tr.trace("DELETE FROM tags", nil)(nil)
+		return nil
+	})
+	_ = tr.runStep(StepInsert, &other, func() error {
+		return nil
+	})
+
+	expected := []LoadStep{StepCleanup, StepChecksum, StepCleanup}
+	if !slices.Equal(steps, expected) {
+		t.Errorf("statements should have steps %v, got %v", expected, steps)
+	}
+	if inner < 10*time.Millisecond || outer >= inner {
+		t.Errorf("nested step duration should not be added to the outer one, got outer %s, inner %s", outer, inner)
+	}
+	if tr.step != "" {
+		t.Errorf("step should have been restored, got %q", tr.step)
+	}
+}
+
+func TestPollLock(t *testing.T) {
+	var attempts int
+	err := pollLock(context.Background(), time.Second, func() (bool, error) {
+		attempts++
+		return attempts == 3, nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("lock should be acquired on the third attempt, got %d attempts and %v", attempts, err)
+	}
+
+	err = pollLock(context.Background(), 100*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, errLockTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pollLock(ctx, time.Second, func() (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context to be canceled, got %v", err)
+	}
+}
+
+func TestNewFixtureSet(t *testing.T) {
+	set, err := NewFixtureSet(
+		Directory("testdata/fixtures_template"),
+		Template(),
+		TemplateData(map[string]any{"Ids": []int{1, 2}}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(set.files) != 1 || len(set.files[0].records) != 2 {
+		t.Fatalf("expected a file with 2 parsed records, got %+v", set.files)
+	}
+	if name := set.files[0].records[1].values["name"]; name != "item-2" {
+		t.Errorf("unexpected name %v", name)
+	}
+
+	l := &Loader{helper: &spanner{}}
+	for _, option := range set.options {
+		if err := option(l); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := set.bind(l); err == nil || !strings.Contains(err.Error(), "Directory is not supported for Spanner") {
+		t.Errorf("expected Directory to be rejected for Spanner, got %v", err)
+	}
+
+	if _, err := NewFixtureSet(Database(&sql.DB{})); err == nil {
+		t.Error("expected the database to be rejected")
+	}
+}
+
+type batchTransaction struct {
+	shared.Querier
+	failAt int
+}
+
+func (tx *batchTransaction) ExecBatch(queries []string, _ [][]any) (int, error) {
+	if tx.failAt < len(queries) {
+		return tx.failAt, errors.New("duplicate key")
+	}
+	return len(queries), nil
+}
+
+func TestBatchFile(t *testing.T) {
+	var errs []error
+	l := &Loader{helper: &MockHelper{}, tracer: &tracer{}}
+	l.tracer.hooks = append(l.tracer.hooks, func(Statement) func(error) {
+		return func(err error) {
+			errs = append(errs, err)
+		}
+	})
+	file := &fixtureFile{
+		fileName: "posts.yml",
+		path:     "testdata/posts.yml",
+		insertSQLs: []insertSQL{
+			{sql: "INSERT INTO posts (id) VALUES ($1)", params: []any{1}, record: fixtureRecord{index: 0}},
+			{sql: "INSERT INTO posts (id) VALUES ($1)", params: []any{1}, record: fixtureRecord{index: 1}},
+			{sql: "INSERT INTO posts (id) VALUES ($1)", params: []any{2}, record: fixtureRecord{index: 2}},
+		},
+	}
+
+	rowsInserted, ok, err := l.batchFile(&batchTransaction{failAt: 1}, file)
+	if !ok {
+		t.Fatal("file should have been inserted in a batch")
+	}
+	var insertErr *InsertError
+	if !errors.As(err, &insertErr) || insertErr.Index != 1 {
+		t.Fatalf("expected an insert error for record 1, got %v", err)
+	}
+	if rowsInserted != 1 {
+		t.Errorf("expected 1 row inserted, got %d", rowsInserted)
+	}
+	if len(errs) != 3 || errs[0] != nil || errs[1] == nil || !errors.Is(errs[2], errBatchAborted) {
+		t.Errorf("hooks should be given the error of each statement, got %v", errs)
+	}
+
+	if _, ok, _ := l.batchFile(shared.SQL(nil), file); ok {
+		t.Error("file should not be inserted in a batch without batch support")
+	}
+}
+
+func TestFixturesFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.yml":               {Data: []byte("- id: 1\n")},
+		"fixtures/core/posts.yml":          {Data: []byte("- id: 1\n")},
+		"fixtures/core/tags/tags.yaml":     {Data: []byte("- id: 1\n")},
+		"fixtures/core/README.md":          {Data: []byte("# Fixtures\n")},
+		"fixtures/extra/comments.yml":      {Data: []byte("- id: 1\n")},
+		"duplicates/users.yml":             {Data: []byte("- id: 1\n")},
+		"duplicates/archived/users.yml":    {Data: []byte("- id: 1\n")},
+		"duplicates/archived/comments.yml": {Data: []byte("- id: 1\n")},
+	}
+
+	tests := []struct {
+		name      string
+		options   []func(*Loader) error
+		wantPaths []string
+		wantErr   string
+	}{
+		{
+			name:      "paths",
+			options:   []func(*Loader) error{Paths("fixtures/users.yml", "fixtures/core")},
+			wantPaths: []string{"fixtures/users.yml", "fixtures/core/posts.yml"},
+		},
+		{
+			name:      "recursive directory",
+			options:   []func(*Loader) error{Directory("fixtures"), RecursiveDirectories()},
+			wantPaths: []string{"fixtures/core/posts.yml", "fixtures/core/tags/tags.yaml", "fixtures/extra/comments.yml", "fixtures/users.yml"},
+		},
+		{
+			name:      "glob",
+			options:   []func(*Loader) error{Glob("fixtures/**/*.yml")},
+			wantPaths: []string{"fixtures/core/posts.yml", "fixtures/extra/comments.yml", "fixtures/users.yml"},
+		},
+		{
+			name:      "glob order",
+			options:   []func(*Loader) error{Glob("fixtures/extra/*.yml", "fixtures/**/*.y*ml")},
+			wantPaths: []string{"fixtures/extra/comments.yml", "fixtures/core/posts.yml", "fixtures/core/tags/tags.yaml", "fixtures/users.yml"},
+		},
+		{
+			name:      "glob without directory",
+			options:   []func(*Loader) error{Glob("*/archived/*.yml")},
+			wantPaths: []string{"duplicates/archived/comments.yml", "duplicates/archived/users.yml"},
+		},
+		{
+			name:    "same table",
+			options: []func(*Loader) error{Glob("duplicates/**/*.yml")},
+			wantErr: `files "duplicates/archived/users.yml" and "duplicates/users.yml" are both fixtures of table "users"`,
+		},
+		{
+			name:    "same table in recursive directory",
+			options: []func(*Loader) error{Paths("duplicates"), RecursiveDirectories()},
+			wantErr: `are both fixtures of table "users"`,
+		},
+		{
+			name:    "no match",
+			options: []func(*Loader) error{Glob("missing/**/*.yml")},
+			wantErr: `no files match the pattern "missing/**/*.yml"`,
+		},
+		{
+			name:    "missing path",
+			options: []func(*Loader) error{Paths("fixtures/missing.yml")},
+			wantErr: `could not stat path "fixtures/missing.yml"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLoader()
+			for _, option := range append([]func(*Loader) error{FS(fsys)}, test.options...) {
+				if err := option(l); err != nil {
+					t.Fatalf("option failed: %v", err)
+				}
+			}
+
+			err := l.loadPendingSources()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var paths []string
+			for _, file := range l.fixturesFiles {
+				paths = append(paths, file.path)
+			}
+			if !slices.Equal(paths, test.wantPaths) {
+				t.Errorf("expected files %v, got %v", test.wantPaths, paths)
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		if err := Glob("fixtures/[.yml")(newLoader()); err == nil {
+			t.Error("expected an error for an invalid pattern")
+		}
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/*.yml", "users.yml", true},
+		{"**/*.yml", "a/b/users.yml", true},
+		{"a/**/users.yml", "a/users.yml", true},
+		{"a/**/users.yml", "a/b/c/users.yml", true},
+		{"a/**/users.yml", "b/users.yml", false},
+		{"a/*.yml", "a/b/users.yml", false},
+		{"a/**", "a/b/users.yml", true},
+	}
+	for _, test := range tests {
+		if got := matchGlob(strings.Split(test.pattern, "/"), strings.Split(test.name, "/")); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestDeclaredTable(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantTable   string
+		wantContent string
+		wantErr     string
+	}{
+		{
+			name:        "none",
+			content:     "- id: 1\n",
+			wantContent: "- id: 1\n",
+		},
+		{
+			name:        "front matter",
+			content:     "---\ntable: audit.events\n---\n- id: 1\n",
+			wantTable:   "audit.events",
+			wantContent: "\n\n\n- id: 1\n",
+		},
+		{
+			name:        "table key",
+			content:     "_table: audit.events\nfirst:\n  id: 1\n",
+			wantTable:   "audit.events",
+			wantContent: "_table: audit.events\nfirst:\n  id: 1\n",
+		},
+		{
+			name:        "many documents",
+			content:     "---\n- id: 1\n---\n- id: 2\n",
+			wantContent: "---\n- id: 1\n---\n- id: 2\n",
+		},
+		{
+			name:    "unknown front matter key",
+			content: "---\ntable: events\nschema: audit\n---\n- id: 1\n",
+			wantErr: `unknown key "schema" in front matter`,
+		},
+		{
+			name:    "declared twice",
+			content: "---\ntable: events\n---\n_table: events\nfirst:\n  id: 1\n",
+			wantErr: "table is declared both in the front matter and with _table",
+		},
+		{
+			name:    "table key not a name",
+			content: "_table:\n  id: 1\n",
+			wantErr: "_table is reserved to declare the table of the file and must be the name of a table",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, content, err := declaredTable([]byte(test.content))
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if table != test.wantTable {
+				t.Errorf("expected table %q, got %q", test.wantTable, table)
+			}
+			if string(content) != test.wantContent {
+				t.Errorf("expected content %q, got %q", test.wantContent, content)
+			}
+		})
+	}
+}
+
+func TestFixtureTables(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.yml":            {Data: []byte("- id: 1\n")},
+		"fixtures/users_archived.yml":   {Data: []byte("---\ntable: users\n---\n- id: 2\n")},
+		"fixtures/audit/events.yml":     {Data: []byte("- id: 1\n")},
+		"fixtures/audit/old_events.yml": {Data: []byte("_table: audit.events\nold:\n  id: 2\n")},
+		"fixtures/audit/2024/logs.yml":  {Data: []byte("- id: 1\n")},
+		"fixtures/billing/users.yml":    {Data: []byte("- id: 3\n")},
+	}
+
+	l := newLoader()
+	for _, option := range []func(*Loader) error{FS(fsys), Directory("fixtures"), SchemaDirectories()} {
+		if err := option(l); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	if err := l.loadPendingSources(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, table := range groupByTable(l.fixturesFiles) {
+		var paths []string
+		for _, file := range table.files {
+			paths = append(paths, file.path)
+		}
+		got = append(got, table.name+": "+strings.Join(paths, ", "))
+	}
+	expected := []string{
+		"audit.events: fixtures/audit/events.yml, fixtures/audit/old_events.yml",
+		"billing.users: fixtures/billing/users.yml",
+		"users: fixtures/users.yml, fixtures/users_archived.yml",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected tables %q, got %q", expected, got)
+	}
+
+	records, err := l.fixturesFiles[1].parseRecords()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || records[0].label != "old" {
+		t.Errorf("_table should not be a record, got %v", records)
+	}
+
+	l = newLoader()
+	for _, option := range []func(*Loader) error{FS(fsys), Directory("fixtures"), RecursiveDirectories()} {
+		if err := option(l); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	err = l.loadPendingSources()
+	if err == nil || !strings.Contains(err.Error(), `files "fixtures/billing/users.yml" and "fixtures/users.yml" are both fixtures of table "users"`) {
+		t.Errorf("expected an error for files of the same table, got %v", err)
+	}
+}
+
+func TestApplyRecordChanges(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.yml": {Data: []byte("john:\n  id: 1\n  banned: false\njane:\n  id: 2\n  banned: false\n")},
+		"fixtures/posts.yml": {Data: []byte("- id: 1\n")},
+	}
+
+	newTestLoader := func(options ...func(*Loader) error) (*Loader, error) {
+		l := newLoader()
+		for _, option := range append([]func(*Loader) error{FS(fsys), Directory("fixtures")}, options...) {
+			if err := option(l); err != nil {
+				t.Fatalf("option failed: %v", err)
+			}
+		}
+		if err := l.loadPendingSources(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return l, l.applyRecordChanges()
+	}
+
+	l, err := newTestLoader(
+		Override("users", "john", map[string]any{"banned": true}),
+		Exclude("users", "jane"),
+		Extra("users", map[string]any{"id": 3, "_tags": "admin"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, record := range l.fixturesFiles[1].records {
+		got = append(got, fmt.Sprintf("%d %s %v %v", record.index, record.label, record.values, record.tags))
+	}
+	expected := []string{
+		"0 john map[banned:true id:1] []",
+		"1  map[id:3] [admin]",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected records %q, got %q", expected, got)
+	}
+	if l.fixturesFiles[0].records != nil {
+		t.Errorf("records of unchanged files should not be parsed")
+	}
+
+	unchanged, err := newTestLoader()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.computeFixturesChecksums()
+	unchanged.computeFixturesChecksums()
+	if l.fixturesChecksums["users"] == unchanged.fixturesChecksums["users"] {
+		t.Errorf("changes should change the checksum of the table")
+	}
+	if l.fixturesChecksums["posts"] != unchanged.fixturesChecksums["posts"] {
+		t.Errorf("changes should not change the checksum of other tables")
+	}
+
+	for _, test := range []struct {
+		option   func(*Loader) error
+		expected string
+	}{
+		{Override("users", "bob", nil), `no record "bob" in the fixtures of table "users"`},
+		{Exclude("posts", "first"), `no record "first" in the fixtures of table "posts"`},
+		{Extra("comments", map[string]any{"id": 1}), `no fixtures for table "comments"`},
+	} {
+		if _, err := newTestLoader(test.option); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestMergeLayers(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base/users.yml":    {Data: []byte("john:\n  id: 1\njane:\n  id: 2\n")},
+		"base/posts.yml":    {Data: []byte("- id: 1\n  title: One\n- id: 2\n  title: Two\n")},
+		"base/tags.yml":     {Data: []byte("- id: 1\n")},
+		"overlay/users.yml": {Data: []byte("john:\n  id: 10\njane:\n  _delete: true\nbob:\n  id: 3\n")},
+		"overlay/posts.yml": {Data: []byte("- ID: 2\n  title: Changed\n- id: 3\n  title: Three\n")},
+		"twice/a.yml":       {Data: []byte("_table: users\njohn:\n  id: 1\n")},
+		"twice/b.yml":       {Data: []byte("_table: users\njohn:\n  id: 2\n")},
+	}
+
+	newTestLoader := func(options ...func(*Loader) error) (*Loader, error) {
+		l := newLoader()
+		l.helper = NewMockHelper("app_test")
+		for _, option := range append([]func(*Loader) error{FS(fsys)}, options...) {
+			if err := option(l); err != nil {
+				t.Fatalf("option failed: %v", err)
+			}
+		}
+		if err := l.loadPendingSources(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return l, l.mergeLayers()
+	}
+
+	l, err := newTestLoader(Layers("base", "overlay"), PrimaryKey("posts", "id"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, table := range groupByTable(l.fixturesFiles) {
+		for _, file := range table.files {
+			for _, record := range file.records {
+				got = append(got, fmt.Sprintf("%s %d %s %v", file.path, file.layer, record.label, record.values))
+			}
+		}
+	}
+	expected := []string{
+		"base/posts.yml 1  map[id:1 title:One]",
+		"overlay/posts.yml 2  map[ID:2 title:Changed]",
+		"overlay/posts.yml 2  map[id:3 title:Three]",
+		"base/tags.yml 1  map[id:1]",
+		"overlay/users.yml 2 john map[id:10]",
+		"overlay/users.yml 2 bob map[id:3]",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected records %q, got %q", expected, got)
+	}
+
+	for _, test := range []struct {
+		options  []func(*Loader) error
+		expected string
+	}{
+		{
+			[]func(*Loader) error{Layers("base", "overlay")},
+			`file "base/posts.yml": the primary key of table "posts" is unknown`,
+		},
+		{
+			[]func(*Loader) error{Layers("overlay"), PrimaryKey("posts", "id")},
+			`file "overlay/users.yml": no record "jane" in the lower layers to delete`,
+		},
+		{
+			[]func(*Loader) error{Layers("twice")},
+			`record "john" of table "users" is defined twice in the same layer, in files "twice/a.yml" and "twice/b.yml"`,
+		},
+	} {
+		if _, err := newTestLoader(test.options...); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.yml": {Data: []byte("defaults: &defaults\n  active: true\njohn:\n  <<: *defaults\n  id: 1\n  born_on: 2000-01-02\n  settings: {theme: dark}\njane:\n  name: Jane\n  code: RAW=gen_code()\n")},
+	}
+
+	l := newLoader()
+	l.helper = NewMockHelper("app_test")
+	for _, option := range []func(*Loader) error{FS(fsys), Directory("fixtures")} {
+		if err := option(l); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	if err := l.loadPendingSources(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.buildInsertSQLs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file := l.fixturesFiles[0]
+	l.generatedKeys = map[recordID]map[string]any{
+		newRecordID(file, file.insertSQLs[2].record): {"id": int64(2)},
+	}
+
+	john, err := l.Record("users", "john")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bornOn, _ := john["born_on"].(time.Time)
+	if john["id"] != uint64(1) || john["active"] != true || john["settings"] != `{"theme":"dark"}` || bornOn.Day() != 2 {
+		t.Errorf("unexpected values %v", john)
+	}
+
+	records, err := l.Records("users")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `map[id:2 name:Jane]`
+	if len(records) != 3 || fmt.Sprint(records[2]) != expected {
+		t.Errorf("expected the last record to be %s, got %v", expected, records)
+	}
+
+	if _, err := l.Record("users", "bob"); err == nil || !strings.Contains(err.Error(), `no record "bob" in the fixtures of table "users"`) {
+		t.Errorf("expected an error for an unknown record, got %v", err)
+	}
+	if _, err := l.Records("posts"); err == nil || !strings.Contains(err.Error(), `no fixtures for table "posts"`) {
+		t.Errorf("expected an error for an unknown table, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package testfixtures
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// UseUpsert makes Loader insert the fixture records or update them when they
+// already exist, keyed on the primary key of their table, instead of cleaning
+// the fixture tables before inserting them. Rows that are not part of the
+// fixtures are left untouched, which allows seeding development databases
+// without losing their data.
+//
+// The statements are INSERT ... ON CONFLICT DO UPDATE on PostgreSQL and
+// SQLite, INSERT ... ON DUPLICATE KEY UPDATE on MySQL, MERGE on SQL Server
+// and INSERT OR UPDATE on Spanner. The primary keys are read from the
+// database, unless given with PrimaryKey.
+//
+// Only valid for PostgreSQL, MySQL, SQLite, SQL Server and Spanner. Returns
+// an error otherwise.
+func UseUpsert() func(*Loader) error {
+	return func(l *Loader) error {
+		switch l.helper.(type) {
+		case *postgreSQL, *mySQL, *sqlite, *sqlserver, *spanner:
+		default:
+			return fmt.Errorf("testfixtures: UseUpsert is only valid for PostgreSQL, MySQL, SQLite, SQL Server and Spanner databases")
+		}
+		l.upsert = true
+		l.skipCleanup = true
+		return nil
+	}
+}
+
+// PrimaryKey sets the primary key of a table used by UseUpsert and Layers,
+// instead of reading it from the database. Useful for views or tables keyed
+// on a unique index.
+func PrimaryKey(tableName string, columns ...string) func(*Loader) error {
+	return func(l *Loader) error {
+		if len(columns) == 0 {
+			return fmt.Errorf("testfixtures: primary key of table %s has no column", tableName)
+		}
+		if l.primaryKeys == nil {
+			l.primaryKeys = make(map[string][]string)
+		}
+		l.primaryKeys[tableName] = columns
+		return nil
+	}
+}
+
+// buildInsertStatement builds the statement inserting a record in the given
+// table, or upserting it with UseUpsert.
+func (l *Loader) buildInsertStatement(tableName string, columns, values []string) (string, error) {
+	if !l.upsert {
+		return l.helper.buildInsertSQL(l.conn, l.helper.quoteKeyword(tableName), columns, values)
+	}
+
+	primaryKey, err := l.primaryKey(tableName)
+	if err != nil {
+		return "", err
+	}
+	return l.helper.buildUpsertSQL(l.conn, l.helper.quoteKeyword(tableName), primaryKey, columns, values)
+}
+
+// primaryKey returns the primary key of a table given with PrimaryKey, or
+// read from the database.
+func (l *Loader) primaryKey(tableName string) ([]string, error) {
+	if primaryKey, ok := l.primaryKeys[tableName]; ok {
+		return primaryKey, nil
+	}
+	primaryKey, err := l.helper.primaryKey(l.conn, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("testfixtures: could not read the primary key of table %s: %w", tableName, err)
+	}
+	if l.primaryKeys == nil {
+		l.primaryKeys = make(map[string][]string)
+	}
+	l.primaryKeys[tableName] = primaryKey
+	return primaryKey, nil
+}
+
+// upsertColumns splits the quoted columns of a record between the ones of the
+// primary key, and the others to be updated.
+func upsertColumns(quote func(string) string, primaryKey, columns []string) (keyColumns, updateColumns []string) {
+	keyColumns = make([]string, 0, len(primaryKey))
+	for _, column := range primaryKey {
+		keyColumns = append(keyColumns, quote(column))
+	}
+	for _, column := range columns {
+		isKey := slices.ContainsFunc(keyColumns, func(key string) bool {
+			return strings.EqualFold(key, column)
+		})
+		if !isKey {
+			updateColumns = append(updateColumns, column)
+		}
+	}
+	return keyColumns, updateColumns
+}
+
+// onConflictDoUpdate returns the ON CONFLICT clause of PostgreSQL and SQLite.
+func onConflictDoUpdate(tableName string, keyColumns, updateColumns []string) (string, error) {
+	if len(keyColumns) == 0 {
+		return "", fmt.Errorf("testfixtures: table %s has no primary key, set it with the PrimaryKey option", tableName)
+	}
+	if len(updateColumns) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keyColumns, ", ")), nil
+	}
+
+	sets := make([]string, 0, len(updateColumns))
+	for _, column := range updateColumns {
+		sets = append(sets, fmt.Sprintf("%s = excluded.%s", column, column))
+	}
+	return fmt.Sprintf(
+		" ON CONFLICT (%s) DO UPDATE SET %s",
+		strings.Join(keyColumns, ", "),
+		strings.Join(sets, ", "),
+	), nil
+}